@@ -7,22 +7,22 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+
 	"gote/pkg/auth"
 	"gote/pkg/config"
+	"gote/pkg/crypto"
 	"gote/pkg/models"
 	"gote/pkg/services"
 	"gote/pkg/storage"
 	"gote/pkg/types"
+	"gote/pkg/utils"
 )
 
-// Precompiled regexp for image references in markdown: ![alt](image:<id>)
-var imageIDRegexp = regexp.MustCompile(`!\[[^\]]*\]\(image:([^)]+)\)`)
-
 // App struct
 type App struct {
 	ctx            context.Context
@@ -36,6 +36,11 @@ type App struct {
 	// Service layer - simplified architecture
 	noteService *services.NoteService
 
+	unsubscribeNotes  func() // Stops forwarding note changes of the current store to the frontend
+	unsubscribeImages func() // Stops forwarding image changes of the current image store to the frontend
+
+	vaultLoaded chan struct{} // Closed once the notes of the unlocked vault are decrypted
+
 	// internals
 	backupSchedulerStarted bool
 	backupMutex            sync.Mutex
@@ -66,11 +71,14 @@ func (a *App) startup(ctx context.Context) {
 		}
 
 		// Initialize components
-		a.authManager = auth.NewManagerWithNotesDir(cfg.PasswordHashPath, cfg.NotesPath)
+		a.authManager = a.newAuthManager(cfg)
 		a.store = storage.NewNoteStore(cfg.NotesPath)
 		a.imageStore = storage.NewImageStore(cfg.NotesPath)
 		a.config = cfg
 
+		// Finish a key rotation that was interrupted before anything reads the vault
+		a.resumePendingRekey()
+
 		// Initialize services - simplified
 		a.noteService = services.NewNoteService(a.store)
 
@@ -106,15 +114,20 @@ func (a *App) IsConfigured() bool {
 	return err == nil
 }
 
-// CompleteInitialSetup handles the first-time setup process
-func (a *App) CompleteInitialSetup(notesPath, passwordHashPath, password, confirmPassword string) error {
+// noRecoveryKeyError starts the error of a first-time setup that created the vault but no
+// recovery key; the frontend recognises it
+const noRecoveryKeyError = "vault created without a recovery key"
+
+// CompleteInitialSetup handles the first-time setup process.
+// It returns a recovery key that must be shown to the user once, since it is never stored in plaintext.
+func (a *App) CompleteInitialSetup(notesPath, passwordHashPath, password, confirmPassword, keyfilePath string) (string, error) {
 	// Basic validation
 	if len(password) < 6 {
-		return fmt.Errorf("password must be at least 6 characters long")
+		return "", fmt.Errorf("password must be at least 6 characters long")
 	}
 
 	if password != confirmPassword {
-		return fmt.Errorf("passwords do not match")
+		return "", fmt.Errorf("passwords do not match")
 	}
 
 	// Use defaults if paths are empty
@@ -127,12 +140,12 @@ func (a *App) CompleteInitialSetup(notesPath, passwordHashPath, password, confir
 
 	// Create directories
 	if err := os.MkdirAll(notesPath, 0755); err != nil {
-		return fmt.Errorf("failed to create notes directory: %v", err)
+		return "", fmt.Errorf("failed to create notes directory: %v", err)
 	}
 
 	passwordDir := filepath.Dir(passwordHashPath)
 	if err := os.MkdirAll(passwordDir, 0755); err != nil {
-		return fmt.Errorf("failed to create password directory: %v", err)
+		return "", fmt.Errorf("failed to create password directory: %v", err)
 	}
 
 	// Create and save configuration
@@ -142,27 +155,45 @@ func (a *App) CompleteInitialSetup(notesPath, passwordHashPath, password, confir
 	}
 
 	if err := a.config.Save(); err != nil {
-		return fmt.Errorf("failed to save configuration: %v", err)
+		return "", fmt.Errorf("failed to save configuration: %v", err)
 	}
 
 	// Initialize components with new configuration
-	a.authManager = auth.NewManagerWithNotesDir(a.config.PasswordHashPath, a.config.NotesPath)
+	a.authManager = a.newAuthManager(a.config)
+	a.closeStores()
 	a.store = storage.NewNoteStore(a.config.NotesPath)
 	a.imageStore = storage.NewImageStore(a.config.NotesPath)
+	a.noteService = services.NewNoteService(a.store)
+
+	// An optional keyfile becomes a second factor for the new vault
+	if err := a.authManager.SetKeyfile(keyfilePath); err != nil {
+		return "", err
+	}
 
 	// Set the initial password
 	if err := a.authManager.StorePasswordHash(password); err != nil {
-		return fmt.Errorf("failed to store password: %v", err)
+		return "", fmt.Errorf("failed to store password: %v", err)
 	}
 
 	// Generate encryption key and initialize
 	key, err := a.authManager.DeriveEncryptionKey(password)
 	if err != nil {
-		return fmt.Errorf("failed to derive encryption key: %v", err)
+		return "", fmt.Errorf("failed to derive encryption key: %v", err)
 	}
 	a.currentKey = key
+	a.applyVaultSettings()
 	a.store.LoadNotes(a.currentKey)
 	a.imageStore.SetKey(a.currentKey)
+	if err := a.store.InitializeCategories(); err != nil {
+		log.Printf("Warning: Failed to create default categories: %v", err)
+	}
+
+	// The recovery key can unlock the vault if the password is ever lost. The vault works
+	// without one, but the user has to know that it is missing.
+	recoveryKey, recoveryErr := a.authManager.CreateRecoveryKey(password)
+	if recoveryErr != nil {
+		log.Printf("Error: Failed to create recovery key: %v", recoveryErr)
+	}
 
 	log.Printf("Initial setup completed:")
 	log.Printf("  Configuration file: %s", config.GetConfigFilePath())
@@ -172,7 +203,10 @@ func (a *App) CompleteInitialSetup(notesPath, passwordHashPath, password, confir
 	// Start daily backup scheduler after initial setup
 	a.startBackupScheduler()
 
-	return nil
+	if recoveryErr != nil {
+		return "", fmt.Errorf("%s: %v", noRecoveryKeyError, recoveryErr)
+	}
+	return recoveryKey, nil
 }
 
 func (a *App) SetPassword(password string) error {
@@ -189,6 +223,8 @@ func (a *App) SetPassword(password string) error {
 	}
 
 	a.currentKey = key
+	a.applyVaultSettings()
+
 	// Load existing notes with the new key
 	if a.noteService != nil {
 		a.noteService.LoadNotes(a.currentKey)
@@ -196,10 +232,23 @@ func (a *App) SetPassword(password string) error {
 		a.store.LoadNotes(a.currentKey)
 	}
 	a.imageStore.SetKey(a.currentKey)
+	if err := a.store.InitializeCategories(); err != nil {
+		log.Printf("Warning: Failed to create default categories: %v", err)
+	}
 	return nil
 }
 
-func (a *App) VerifyPassword(password string) bool {
+func (a *App) VerifyPassword(password, keyfilePath string) bool {
+	if err := a.authManager.SetKeyfile(keyfilePath); err != nil {
+		log.Printf("Failed to read keyfile: %v", err)
+		return false
+	}
+
+	// A key rotation interrupted during this session is completed before the vault is unlocked
+	if err := a.resumePendingRekey(); err != nil {
+		return false
+	}
+
 	// Verify password - this will automatically handle cross-platform setup if needed
 	if !a.authManager.VerifyPassword(password) {
 		return false
@@ -218,16 +267,101 @@ func (a *App) VerifyPassword(password string) bool {
 	sessionID := a.authManager.CreateSession(key)
 	a.currentSession = sessionID
 
-	// Load notes with the key
-	if a.noteService != nil {
-		a.noteService.LoadNotes(a.currentKey)
-	} else {
-		a.store.LoadNotes(a.currentKey)
-	}
+	a.applyVaultSettings()
+
+	// Notes are decrypted in the background; the frontend is told about the progress
+	a.loadVault()
 	a.imageStore.SetKey(a.currentKey)
 	return true
 }
 
+// RecoverWithKey unlocks the vault with a recovery key and sets a new password.
+// The used recovery key stops working; the returned replacement must be shown to the user.
+// Recovery also removes a keyfile requirement, since the keyfile may be what was lost.
+func (a *App) RecoverWithKey(recoveryKey, newPassword, confirmPassword string) (string, error) {
+	if len(newPassword) < 6 {
+		return "", fmt.Errorf("password must be at least 6 characters long")
+	}
+
+	if newPassword != confirmPassword {
+		return "", fmt.Errorf("passwords do not match")
+	}
+
+	if err := a.authManager.SetKeyfile(""); err != nil {
+		return "", err
+	}
+	if err := a.resumePendingRekey(); err != nil {
+		return "", err
+	}
+
+	key, newRecoveryKey, err := a.authManager.RecoverWithKey(recoveryKey, newPassword)
+	if err != nil {
+		return "", err
+	}
+
+	a.currentKey = key
+	a.currentSession = a.authManager.CreateSession(key)
+
+	a.applyVaultSettings()
+
+	// Notes are decrypted in the background; the frontend is told about the progress
+	a.loadVault()
+	a.imageStore.SetKey(a.currentKey)
+
+	log.Printf("Vault recovered with recovery key - new password set")
+	return newRecoveryKey, nil
+}
+
+// HasRecoveryKey reports whether a recovery key has been set up for the vault
+func (a *App) HasRecoveryKey() bool {
+	if a.authManager == nil {
+		return false
+	}
+	return a.authManager.HasRecoveryKey()
+}
+
+// IsKeyfileRequired reports whether unlocking the vault needs a keyfile besides the password
+func (a *App) IsKeyfileRequired() bool {
+	if a.authManager == nil {
+		return false
+	}
+	return a.authManager.IsKeyfileRequired()
+}
+
+// ChangeKeyfile protects the vault with a new keyfile; an empty path removes the keyfile requirement
+func (a *App) ChangeKeyfile(password, keyfilePath string) error {
+	if err := a.requireAuth(); err != nil {
+		return err
+	}
+
+	// Keep a copy of the key configuration that opens with the old keyfile
+	backupPath, err := a.backupNow()
+	if err != nil {
+		return fmt.Errorf("keyfile change aborted: %v", err)
+	}
+	log.Printf("Keyfile change: backup created at %s", backupPath)
+
+	if err := a.authManager.ChangeKeyfile(password, keyfilePath); err != nil {
+		return err
+	}
+
+	if keyfilePath == "" {
+		log.Printf("Keyfile requirement removed")
+	} else {
+		log.Printf("Vault keyfile changed")
+	}
+	return nil
+}
+
+// GenerateRecoveryKey creates a new recovery key, invalidating any previous one
+func (a *App) GenerateRecoveryKey(password string) (string, error) {
+	if err := a.requireAuth(); err != nil {
+		return "", err
+	}
+
+	return a.authManager.CreateRecoveryKey(password)
+}
+
 // Note management methods
 func (a *App) GetAllNotes() []types.WailsNote {
 	var notes []*models.Note
@@ -273,20 +407,7 @@ func (a *App) CreateNoteWithCategory(content string, category string) (types.Wai
 		return types.WailsNote{}, err
 	}
 
-	// Convert string to NoteCategory
-	var noteCategory models.NoteCategory
-	switch category {
-	case "private":
-		noteCategory = models.CategoryPrivate
-	case "work":
-		noteCategory = models.CategoryWork
-	case "trash":
-		noteCategory = models.CategoryTrash
-	default:
-		noteCategory = models.CategoryPrivate
-	}
-
-	note, err := a.noteService.CreateNoteWithCategory(content, noteCategory, a.currentKey)
+	note, err := a.noteService.CreateNoteWithCategory(content, models.NoteCategory(category), a.currentKey)
 	if err != nil {
 		return types.WailsNote{}, err
 	}
@@ -311,20 +432,7 @@ func (a *App) UpdateNoteCategory(id string, category string) (types.WailsNote, e
 		return types.WailsNote{}, err
 	}
 
-	// Convert string to NoteCategory
-	var noteCategory models.NoteCategory
-	switch category {
-	case "private":
-		noteCategory = models.CategoryPrivate
-	case "work":
-		noteCategory = models.CategoryWork
-	case "trash":
-		noteCategory = models.CategoryTrash
-	default:
-		return types.WailsNote{}, fmt.Errorf("invalid category: %s", category)
-	}
-
-	note, err := a.noteService.UpdateNoteCategory(id, noteCategory, a.currentKey)
+	note, err := a.noteService.UpdateNoteCategory(id, models.NoteCategory(category), a.currentKey)
 	if err != nil {
 		return types.WailsNote{}, err
 	}
@@ -380,9 +488,92 @@ func (a *App) SearchNotes(query string) []types.WailsNote {
 // Settings methods
 func (a *App) GetSettings() map[string]interface{} {
 	return map[string]interface{}{
-		"notesPath":        a.config.NotesPath,
-		"passwordHashPath": a.config.PasswordHashPath,
+		"notesPath":              a.config.NotesPath,
+		"passwordHashPath":       a.config.PasswordHashPath,
+		"historyMaxRevisions":    a.config.HistoryMaxRevisions,
+		"historyMaxAgeDays":      a.config.HistoryMaxAgeDays,
+		"tombstoneRetentionDays": a.config.TombstoneRetentionDays,
+		"pollingOnly":            a.config.PollingOnly,
+		"pollIntervalSeconds":    a.config.PollIntervalSeconds,
+		"lazyLoading":            a.config.LazyLoading,
+		"contentCacheSize":       a.config.ContentCacheSize,
+	}
+}
+
+// SetHistoryRetention sets how many revisions are kept per note and for how many days (0 for no age limit)
+func (a *App) SetHistoryRetention(maxRevisions, maxAgeDays int) error {
+	if maxRevisions < 0 || maxAgeDays < 0 {
+		return fmt.Errorf("retention values cannot be negative")
+	}
+
+	a.config.HistoryMaxRevisions = maxRevisions
+	a.config.HistoryMaxAgeDays = maxAgeDays
+	if err := a.config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %v", err)
+	}
+
+	if a.store != nil {
+		a.store.SetHistoryRetention(maxRevisions, time.Duration(maxAgeDays)*24*time.Hour)
+	}
+	return nil
+}
+
+// SetTombstoneRetention sets for how many days deletions are remembered (0 for the default of 90 days).
+// Devices that are offline for longer than this can bring deleted notes back.
+func (a *App) SetTombstoneRetention(days int) error {
+	if days < 0 {
+		return fmt.Errorf("retention cannot be negative")
+	}
+
+	a.config.TombstoneRetentionDays = days
+	if err := a.config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %v", err)
+	}
+
+	if a.store != nil {
+		a.store.SetTombstoneRetention(time.Duration(days) * 24 * time.Hour)
+	}
+	return nil
+}
+
+// SetPollingMode selects whether changes in the notes directory are found by polling every few
+// seconds (0 for the default of 10) instead of file system events. Network drives such as
+// SMB or sshfs mounts often do not report changes made by other machines.
+func (a *App) SetPollingMode(enabled bool, intervalSeconds int) error {
+	if intervalSeconds < 0 {
+		return fmt.Errorf("poll interval cannot be negative")
+	}
+
+	a.config.PollingOnly = enabled
+	a.config.PollIntervalSeconds = intervalSeconds
+	if err := a.config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %v", err)
+	}
+
+	if a.store != nil {
+		a.store.SetPollingMode(enabled, time.Duration(intervalSeconds)*time.Second)
+	}
+	return nil
+}
+
+// SetLazyLoading selects whether note contents are decrypted only when a note is opened or
+// searched, keeping up to cacheSize notes decrypted (0 for the default of 200). This keeps
+// unlocking fast and memory use low for large vaults; searching takes longer instead.
+func (a *App) SetLazyLoading(enabled bool, cacheSize int) error {
+	if cacheSize < 0 {
+		return fmt.Errorf("cache size cannot be negative")
+	}
+
+	a.config.LazyLoading = enabled
+	a.config.ContentCacheSize = cacheSize
+	if err := a.config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %v", err)
 	}
+
+	if a.store != nil {
+		a.store.SetLazyLoading(enabled, cacheSize)
+	}
+	return nil
 }
 
 func (a *App) UpdateSettings(notesPath, passwordHashPath string) error {
@@ -418,9 +609,12 @@ func (a *App) UpdateSettings(notesPath, passwordHashPath string) error {
 	a.currentKey = nil
 
 	// Update components with new paths
-	a.authManager = auth.NewManagerWithNotesDir(a.config.PasswordHashPath, a.config.NotesPath)
+	a.authManager = a.newAuthManager(a.config)
+	a.closeStores()
 	a.store = storage.NewNoteStore(a.config.NotesPath)
 	a.imageStore = storage.NewImageStore(a.config.NotesPath)
+	a.noteService = services.NewNoteService(a.store)
+	a.resumePendingRekey()
 
 	log.Printf("Settings updated:")
 	log.Printf("  Notes directory: %s", a.config.NotesPath)
@@ -430,134 +624,435 @@ func (a *App) UpdateSettings(notesPath, passwordHashPath string) error {
 	return nil
 }
 
+// ChangePassword rewraps the vault master key under a new password.
+// Notes and images stay encrypted with the same master key, so no files are rewritten.
 func (a *App) ChangePassword(oldPassword, newPassword string) error {
-	return fmt.Errorf("password change not supported in simplified mode. Please backup your notes, delete data, and set up fresh with new password")
-}
+	if err := a.requireAuth(); err != nil {
+		return err
+	}
 
-func (a *App) ResetApplication() error {
-	// Clear the current key to prevent any operations
-	a.currentKey = nil
+	if len(newPassword) < 6 {
+		return fmt.Errorf("password must be at least 6 characters long")
+	}
 
-	// Remove password hash file only - keep the encrypted notes
-	if err := a.authManager.RemovePasswordHash(); err != nil {
-		return fmt.Errorf("failed to remove password hash: %v", err)
+	// Check the password before anything is written, so failed attempts leave no backups behind
+	if !a.authManager.VerifyPassword(oldPassword) {
+		return fmt.Errorf("current password is incorrect")
 	}
 
-	return nil
-}
+	// Always keep a copy of the key configuration under the old password
+	backupPath, err := a.backupNow()
+	if err != nil {
+		return fmt.Errorf("password change aborted: %v", err)
+	}
+	log.Printf("Password change: backup created at %s", backupPath)
 
-// Logout clears the current session without removing password hash
-func (a *App) Logout() error {
-	// Delete the session from auth manager
-	if a.currentSession != "" {
-		a.authManager.DeleteSession(a.currentSession)
-		a.currentSession = ""
+	if err := a.authManager.ChangePassword(oldPassword, newPassword); err != nil {
+		return err
 	}
 
-	// Clear the current key to end the session
-	a.currentKey = nil
+	log.Printf("Password changed")
 	return nil
 }
 
-// ValidateCurrentSession checks if the current session is still valid
-func (a *App) ValidateCurrentSession() bool {
-	if a.currentSession == "" {
-		return false
+// RotateMasterKey re-encrypts the whole vault under a newly generated master key.
+// A backup is taken first and all files are staged before anything in the vault is replaced,
+// so an interrupted rotation is either discarded or rolled forward on the next start.
+// If the vault had a recovery key, the replacement is returned and must be shown to the user.
+func (a *App) RotateMasterKey(password string) (string, error) {
+	if err := a.requireAuth(); err != nil {
+		return "", err
 	}
 
-	valid := a.authManager.ValidateSession(a.currentSession)
-	if !valid {
-		// Session expired, clear local state
-		a.currentSession = ""
-		a.currentKey = nil
+	rotation, err := a.authManager.PrepareKeyRotation(password)
+	if err != nil {
+		return "", err
 	}
-	return valid
-}
-
-// IsAuthenticated returns true if user has a valid session
-func (a *App) IsAuthenticated() bool {
-	return a.ValidateCurrentSession()
-}
 
-// requireAuth checks if user is authenticated and returns error if not
-func (a *App) requireAuth() error {
-	if !a.IsAuthenticated() {
-		return fmt.Errorf("session expired - re-authentication required")
+	// Always keep a copy of the vault under the old master key
+	backupPath, err := a.backupNow()
+	if err != nil {
+		return "", fmt.Errorf("key rotation aborted: %v", err)
 	}
-	return nil
-}
+	log.Printf("Key rotation: backup created at %s", backupPath)
 
-// startSessionCleanup runs a background goroutine to clean up expired sessions
-func (a *App) startSessionCleanup() {
-	ticker := time.NewTicker(5 * time.Minute) // Clean up every 5 minutes
-	defer ticker.Stop()
+	// Nothing may change under the old key while the vault is staged, and the store must not
+	// read the re-encrypted files with the old key while they are moved into place
+	a.store.SuspendSync()
 
-	for {
-		select {
-		case <-ticker.C:
-			if a.authManager != nil {
-				a.authManager.CleanupExpiredSessions()
-			}
-		case <-a.ctx.Done():
-			return
+	journal, err := storage.StageRekey(a.store, a.imageStore, rotation.OldKey, rotation.NewKey, rotation.KeyConfig)
+	if err != nil {
+		if abortErr := storage.AbortRekey(a.config.NotesPath); abortErr != nil {
+			log.Printf("Warning: Failed to clean up staged files: %v", abortErr)
 		}
+		a.store.ResumeSync()
+		return "", fmt.Errorf("key rotation aborted: %v", err)
+	}
+
+	if err := a.finishRekey(journal); err != nil {
+		// Edits under the old key would end up in a half-rotated vault, so the session ends
+		// here; the next unlock completes the rotation first
+		a.Logout()
+		return "", fmt.Errorf("key rotation interrupted, unlock the vault again to complete it: %v", err)
+	}
+
+	// Switch the session over to the new key
+	if a.currentSession != "" {
+		a.authManager.DeleteSession(a.currentSession)
+	}
+	a.currentKey = rotation.NewKey
+	a.currentSession = a.authManager.CreateSession(rotation.NewKey)
+	a.imageStore.SetKey(a.currentKey)
+	if err := a.store.LoadNotes(a.currentKey); err != nil {
+		return "", fmt.Errorf("failed to reload notes: %v", err)
 	}
+
+	log.Printf("Master key rotated: re-encrypted %d files", len(journal.Files))
+	return rotation.RecoveryKey, nil
 }
 
-// CreateBackup creates a zip backup of all notes
-func (a *App) CreateBackup() (string, error) {
-	if err := a.requireAuth(); err != nil {
-		return "", err
+// applyVaultSettings copies vault-wide settings from the shared vault config, and local
+// settings from the app configuration, to the stores
+func (a *App) applyVaultSettings() {
+	a.store.SetMetadataEncryption(a.authManager.IsMetadataEncrypted())
+	a.store.SetHistoryRetention(a.config.HistoryMaxRevisions, time.Duration(a.config.HistoryMaxAgeDays)*24*time.Hour)
+	a.store.SetTombstoneRetention(time.Duration(a.config.TombstoneRetentionDays) * 24 * time.Hour)
+	a.store.SetPollingMode(a.config.PollingOnly, time.Duration(a.config.PollIntervalSeconds)*time.Second)
+	a.store.SetLazyLoading(a.config.LazyLoading, a.config.ContentCacheSize)
+
+	if a.config.DeviceID == "" {
+		a.config.DeviceID = utils.GenerateShortUUID()
+		if err := a.config.Save(); err != nil {
+			log.Printf("Warning: Failed to save device ID: %v", err)
+		}
 	}
+	a.store.SetDeviceID(a.config.DeviceID)
 
-	// Use the storage backup function
-	return a.backupNow()
+	if a.unsubscribeNotes != nil {
+		a.unsubscribeNotes()
+	}
+	a.unsubscribeNotes = a.store.Subscribe(a.forwardNoteChange)
+
+	if a.unsubscribeImages != nil {
+		a.unsubscribeImages()
+	}
+	a.unsubscribeImages = a.imageStore.SubscribeImages(a.forwardImageChange)
 }
 
-// startBackupScheduler starts a simple daily backup scheduler.
-// It creates one backup per 24 hours by checking the most recent backup file
-// in the backups directory under the notes path.
-func (a *App) startBackupScheduler() {
-	if a.backupSchedulerStarted || a.config == nil || a.config.NotesPath == "" {
-		return
+// Progress events while a vault loads are sent at most this often
+const vaultProgressInterval = 100 * time.Millisecond
+
+// loadVault decrypts the notes of the unlocked vault in the background, most recently changed first.
+// The frontend receives vault:loading events with the progress and vault:loaded at the end,
+// and can show the notes that are already decrypted in the meantime.
+func (a *App) loadVault() {
+	done := make(chan struct{})
+	a.vaultLoaded = done
+	key := a.currentKey
+
+	var lastProgress time.Time
+	progress := func(loaded, total int) {
+		if a.ctx == nil || (loaded < total && time.Since(lastProgress) < vaultProgressInterval) {
+			return
+		}
+		lastProgress = time.Now()
+		runtime.EventsEmit(a.ctx, "vault:loading", types.WailsLoadProgress{Loaded: loaded, Total: total})
 	}
-	a.backupSchedulerStarted = true
 
 	go func() {
-		// Run an immediate check at start, then hourly checks
-		a.tryCreateDailyBackup()
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
+		defer close(done)
 
-		for {
-			select {
-			case <-ticker.C:
-				a.tryCreateDailyBackup()
-			case <-a.ctx.Done():
-				return
-			}
+		var err error
+		if a.noteService != nil {
+			err = a.noteService.LoadNotesWithProgress(key, progress)
+		} else {
+			err = a.store.LoadNotesWithProgress(key, progress)
+		}
+		if err != nil {
+			log.Printf("Error loading notes: %v", err)
+		}
+		if a.ctx != nil {
+			runtime.EventsEmit(a.ctx, "vault:loaded")
 		}
 	}()
 }
 
-// tryCreateDailyBackup checks the latest backup timestamp and creates a new backup if >24h passed.
-func (a *App) tryCreateDailyBackup() {
-	if a.config == nil || a.config.NotesPath == "" {
-		return
+// waitForVault blocks until the notes of the unlocked vault are loaded.
+// Anything that must see every note, like finding unreferenced images, waits for this.
+func (a *App) waitForVault() {
+	if a.vaultLoaded != nil {
+		<-a.vaultLoaded
 	}
+}
 
-	notesDir := a.config.NotesPath
-	backupsDir := filepath.Join(notesDir, "backups")
-	if err := os.MkdirAll(backupsDir, 0755); err != nil {
-		log.Printf("Auto-backup: failed to create backups dir: %v", err)
+// forwardNoteChange passes note changes read from disk to the frontend as runtime events
+// (note:created, note:updated, note:deleted, note:conflict and note:corrupted).
+// Changes made through the bindings are not forwarded; the frontend already knows about them.
+func (a *App) forwardNoteChange(change storage.Change) {
+	if a.ctx == nil || !change.External {
 		return
 	}
 
-	// Find most recent backup-*.zip
-	entries, err := os.ReadDir(backupsDir)
-	if err != nil {
-		log.Printf("Auto-backup: failed to read backups dir: %v", err)
-		return
+	note := types.WailsNote{ID: change.NoteID}
+	if change.Note != nil {
+		note = types.ConvertToWailsNote(change.Note)
+	}
+
+	if change.Type == storage.ChangeConflict {
+		runtime.EventsEmit(a.ctx, "note:conflict", types.WailsNoteConflict{
+			Note:   note,
+			Kind:   change.Conflict.Kind,
+			CopyID: change.Conflict.CopyID,
+		})
+		return
+	}
+	runtime.EventsEmit(a.ctx, "note:"+change.Type, note)
+}
+
+// forwardImageChange tells the frontend about images that appeared on or disappeared from disk
+// (image:created and image:deleted), together with the notes that show them
+func (a *App) forwardImageChange(change storage.ImageChange) {
+	if a.ctx == nil || !change.External {
+		return
+	}
+
+	runtime.EventsEmit(a.ctx, "image:"+change.Type, types.WailsImageChange{
+		ImageID: change.ImageID,
+		NoteIDs: a.store.NotesShowingImage(change.ImageID),
+	})
+}
+
+// IsMetadataEncrypted reports whether note timestamps and sizes are hidden inside the ciphertext
+func (a *App) IsMetadataEncrypted() bool {
+	if a.authManager == nil {
+		return false
+	}
+	return a.authManager.IsMetadataEncrypted()
+}
+
+// SetMetadataEncryption switches the vault between plaintext and encrypted note metadata
+// and rewrites every note in the new format
+func (a *App) SetMetadataEncryption(enabled bool) error {
+	if err := a.requireAuth(); err != nil {
+		return err
+	}
+
+	if _, err := a.backupNow(); err != nil {
+		return fmt.Errorf("metadata encryption change aborted: %v", err)
+	}
+
+	// Record the mode first so other devices pick it up on their next unlock
+	if err := a.authManager.SetMetadataEncryption(enabled); err != nil {
+		return err
+	}
+	a.store.SetMetadataEncryption(enabled)
+
+	rewritten, err := a.store.RewriteAllNotes(a.currentKey)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite notes after %d of them: %v", rewritten, err)
+	}
+
+	log.Printf("Metadata encryption set to %v: rewrote %d notes", enabled, rewritten)
+	return nil
+}
+
+// MigrateVaultFormat rewrites notes and images stored in an older on-disk format.
+// Files written by a newer version of the app are reported and left untouched.
+func (a *App) MigrateVaultFormat() (map[string]interface{}, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	backupPath, err := a.backupNow()
+	if err != nil {
+		return nil, fmt.Errorf("migration aborted: %v", err)
+	}
+	log.Printf("Format migration: backup created at %s", backupPath)
+
+	notesMigrated, unsupportedNotes, err := a.store.MigrateNotes(a.currentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate notes: %v", err)
+	}
+
+	imagesMigrated, unsupportedImages, err := a.imageStore.MigrateImages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate images: %v", err)
+	}
+
+	unsupported := append(unsupportedNotes, unsupportedImages...)
+	if len(unsupported) > 0 {
+		log.Printf("Format migration: %d files were written by a newer version and left untouched", len(unsupported))
+	}
+	log.Printf("Format migration: rewrote %d notes and %d images", notesMigrated, imagesMigrated)
+
+	return map[string]interface{}{
+		"notes_migrated":  notesMigrated,
+		"images_migrated": imagesMigrated,
+		"unsupported":     unsupported,
+	}, nil
+}
+
+// finishRekey installs the key configuration of a committed journal and moves the staged files into place
+func (a *App) finishRekey(journal *storage.RekeyJournal) error {
+	if err := a.authManager.ApplyKeyRotation(journal.KeyConfig); err != nil {
+		return err
+	}
+	return storage.FinishRekey(a.config.NotesPath, journal)
+}
+
+// resumePendingRekey completes or discards a key rotation that was interrupted. It returns an
+// error if a committed rotation could not be completed; the vault must not be unlocked then.
+func (a *App) resumePendingRekey() error {
+	journal, err := storage.LoadRekeyJournal(a.config.NotesPath)
+	if err != nil {
+		log.Printf("Warning: Failed to read key rotation journal: %v", err)
+		return nil
+	}
+	if journal == nil {
+		return nil
+	}
+
+	if journal.State != storage.RekeyStateCommitted {
+		// The vault itself was never touched, so it is still valid under the old key
+		log.Printf("Discarding incomplete key rotation from %s", journal.StartedAt.Format(time.RFC3339))
+		if err := storage.AbortRekey(a.config.NotesPath); err != nil {
+			log.Printf("Warning: Failed to discard incomplete key rotation: %v", err)
+		}
+		return nil
+	}
+
+	if err := a.finishRekey(journal); err != nil {
+		log.Printf("Error: Failed to complete interrupted key rotation: %v", err)
+		return fmt.Errorf("failed to complete interrupted key rotation: %v", err)
+	}
+	log.Printf("Completed interrupted key rotation from %s", journal.StartedAt.Format(time.RFC3339))
+	return nil
+}
+
+func (a *App) ResetApplication() error {
+	// Clear the current key to prevent any operations
+	a.currentKey = nil
+
+	// Remove password hash file only - keep the encrypted notes
+	if err := a.authManager.RemovePasswordHash(); err != nil {
+		return fmt.Errorf("failed to remove password hash: %v", err)
+	}
+
+	return nil
+}
+
+// Logout clears the current session without removing password hash
+func (a *App) Logout() error {
+	// Delete the session from auth manager
+	if a.currentSession != "" {
+		a.authManager.DeleteSession(a.currentSession)
+		a.currentSession = ""
+	}
+
+	// Clear the current key to end the session
+	a.currentKey = nil
+	return nil
+}
+
+// ValidateCurrentSession checks if the current session is still valid
+func (a *App) ValidateCurrentSession() bool {
+	if a.currentSession == "" {
+		return false
+	}
+
+	valid := a.authManager.ValidateSession(a.currentSession)
+	if !valid {
+		// Session expired, clear local state
+		a.currentSession = ""
+		a.currentKey = nil
+	}
+	return valid
+}
+
+// IsAuthenticated returns true if user has a valid session
+func (a *App) IsAuthenticated() bool {
+	return a.ValidateCurrentSession()
+}
+
+// requireAuth checks if user is authenticated and returns error if not
+func (a *App) requireAuth() error {
+	if !a.IsAuthenticated() {
+		return fmt.Errorf("session expired - re-authentication required")
+	}
+	return nil
+}
+
+// startSessionCleanup runs a background goroutine to clean up expired sessions
+func (a *App) startSessionCleanup() {
+	ticker := time.NewTicker(5 * time.Minute) // Clean up every 5 minutes
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if a.authManager != nil {
+				a.authManager.CleanupExpiredSessions()
+			}
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// CreateBackup creates a zip backup of all notes
+func (a *App) CreateBackup() (string, error) {
+	if err := a.requireAuth(); err != nil {
+		return "", err
+	}
+
+	// Use the storage backup function
+	return a.backupNow()
+}
+
+// startBackupScheduler starts a simple daily backup scheduler.
+// It creates one backup per 24 hours by checking the most recent backup file
+// in the backups directory under the notes path.
+func (a *App) startBackupScheduler() {
+	if a.backupSchedulerStarted || a.config == nil || a.config.NotesPath == "" {
+		return
+	}
+	a.backupSchedulerStarted = true
+
+	go func() {
+		// Run an immediate check at start, then hourly checks
+		a.tryCreateDailyBackup()
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.tryCreateDailyBackup()
+			case <-a.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// tryCreateDailyBackup checks the latest backup timestamp and creates a new backup if >24h passed.
+func (a *App) tryCreateDailyBackup() {
+	if a.config == nil || a.config.NotesPath == "" {
+		return
+	}
+
+	notesDir := a.config.NotesPath
+	backupsDir := filepath.Join(notesDir, "backups")
+	if err := os.MkdirAll(backupsDir, 0755); err != nil {
+		log.Printf("Auto-backup: failed to create backups dir: %v", err)
+		return
+	}
+
+	// Find most recent backup-*.zip
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		log.Printf("Auto-backup: failed to read backups dir: %v", err)
+		return
 	}
 
 	var latest time.Time
@@ -588,6 +1083,14 @@ func (a *App) tryCreateDailyBackup() {
 	}
 }
 
+// newAuthManager creates the auth manager of a vault; one-time migrations of the vault
+// config are preceded by a backup
+func (a *App) newAuthManager(cfg *config.Config) *auth.Manager {
+	manager := auth.NewManagerWithNotesDir(cfg.PasswordHashPath, cfg.NotesPath)
+	manager.SetBackup(a.backupNow)
+	return manager
+}
+
 // backupNow performs a backup using a single shared path for manual & scheduled backups.
 // It prevents concurrent backups via a mutex and returns the created archive path.
 func (a *App) backupNow() (string, error) {
@@ -656,19 +1159,91 @@ func (a *App) ValidateSetupInputs(notesPath, passwordHashPath, password, confirm
 
 // GetSecurityInfo returns information about current security configuration
 func (a *App) GetSecurityInfo() map[string]interface{} {
-	return map[string]interface{}{
-		"method":          "PBKDF2",
-		"secure":          true,
-		"iterations":      100000,
-		"key_length":      32,
-		"salt_length":     32,
-		"recommendations": []string{"Using OWASP-compliant PBKDF2 with salt"},
+	if a.authManager == nil {
+		return map[string]interface{}{
+			"method": "unconfigured",
+			"secure": false,
+		}
+	}
+
+	kdf := a.authManager.GetKeyDerivationInfo()
+	info := map[string]interface{}{
+		"method":             keyDerivationMethodName(kdf.Params.Algorithm),
+		"secure":             true,
+		"key_length":         crypto.KeyLength,
+		"salt_length":        crypto.SaltLength,
+		"master_key_wrapped": kdf.MasterKeyWrapped,
+		"upgrade_available":  kdf.UpgradeAvailable(),
+	}
+
+	var recommendations []string
+	switch kdf.Params.Algorithm {
+	case crypto.KDFArgon2id:
+		info["memory_kib"] = kdf.Params.Memory
+		info["time"] = kdf.Params.Time
+		info["threads"] = kdf.Params.Threads
+		recommendations = append(recommendations, "Using memory-hard Argon2id key derivation")
+	case crypto.KDFPBKDF2:
+		info["iterations"] = kdf.Params.Iterations
+		recommendations = append(recommendations, "Upgrade key derivation to Argon2id")
+	}
+	if kdf.LegacyMasterKey {
+		recommendations = append(recommendations, "Upgrade to replace the PBKDF2-derived master key")
+	}
+	info["recommendations"] = recommendations
+
+	return info
+}
+
+// keyDerivationMethodName returns the display name of a key derivation algorithm
+func keyDerivationMethodName(algorithm string) string {
+	switch algorithm {
+	case crypto.KDFArgon2id:
+		return "Argon2id"
+	case crypto.KDFPBKDF2:
+		return "PBKDF2"
+	default:
+		return algorithm
 	}
 }
 
 // IsUsingSecureMethod checks if enhanced security is enabled
 func (a *App) IsUsingSecureMethod() bool {
-	return true // Always using PBKDF2 in simplified mode
+	if a.authManager == nil {
+		return false
+	}
+	return !a.authManager.GetKeyDerivationInfo().UpgradeAvailable()
+}
+
+// UpgradeKeyDerivation migrates the vault to Argon2id key derivation.
+// Vaults whose master key was derived with PBKDF2 are re-encrypted under a new master key,
+// in which case a replacement recovery key may be returned.
+func (a *App) UpgradeKeyDerivation(password string) (string, error) {
+	if err := a.requireAuth(); err != nil {
+		return "", err
+	}
+
+	kdf := a.authManager.GetKeyDerivationInfo()
+	if !kdf.UpgradeAvailable() {
+		return "", nil
+	}
+
+	// Rotation wraps the new master key with the default parameters as well
+	if !kdf.MasterKeyWrapped || kdf.LegacyMasterKey {
+		return a.RotateMasterKey(password)
+	}
+
+	// Always keep a copy of the key configuration before rewrapping
+	if _, err := a.backupNow(); err != nil {
+		return "", fmt.Errorf("upgrade aborted: %v", err)
+	}
+
+	if err := a.authManager.UpgradeKeyDerivation(password); err != nil {
+		return "", err
+	}
+
+	log.Printf("Key derivation upgraded to %s", keyDerivationMethodName(crypto.DefaultKDFParams().Algorithm))
+	return "", nil
 }
 
 // Performance monitoring methods
@@ -678,7 +1253,9 @@ func (a *App) GetPerformanceStats() map[string]interface{} {
 	stats := map[string]interface{}{
 		"notes_count":       len(a.GetAllNotes()),
 		"has_service_layer": a.noteService != nil,
-		"security_method":   "PBKDF2",
+	}
+	if a.authManager != nil {
+		stats["security_method"] = keyDerivationMethodName(a.authManager.GetKeyDerivationInfo().Params.Algorithm)
 	}
 
 	// Add basic performance information
@@ -773,40 +1350,16 @@ func (a *App) GetImageAsDataURL(imageID string) (string, error) {
 
 // extractImageIDsFromContent extracts image IDs from note content
 func (a *App) extractImageIDsFromContent(content string) []string {
-	var imageIDs []string
-
-	// Regular expression to match ![alt](image:imageId) pattern (precompiled)
-	matches := imageIDRegexp.FindAllStringSubmatch(content, -1)
-
-	for _, match := range matches {
-		if len(match) > 1 {
-			imageIDs = append(imageIDs, match[1])
-		}
-	}
-
-	return imageIDs
+	return models.ParseImageReferences(content)
 }
 
 // isImageReferencedByOtherNotes checks if an image is referenced by notes other than the excluded note
 func (a *App) isImageReferencedByOtherNotes(imageID, excludeNoteID string) bool {
-	var allNotes []*models.Note
-
-	if a.noteService != nil {
-		allNotes = a.noteService.GetAllNotes()
-	} else {
-		allNotes = a.store.GetAllNotes()
-	}
-
-	for _, note := range allNotes {
-		if note.ID == excludeNoteID {
-			continue // Skip the note being deleted
-		}
+	a.waitForVault()
 
-		imageIDs := a.extractImageIDsFromContent(note.Content)
-		for _, id := range imageIDs {
-			if id == imageID {
-				return true // Image is referenced by another note
-			}
+	for _, id := range a.noteService.NotesShowingImage(imageID) {
+		if id != excludeNoteID {
+			return true // Image is referenced by another note
 		}
 	}
 
@@ -818,6 +1371,7 @@ func (a *App) CleanupOrphanedImages() (int, error) {
 	if a.currentKey == nil {
 		return 0, fmt.Errorf("not authenticated")
 	}
+	a.waitForVault()
 
 	// Get all stored images
 	allImages, err := a.imageStore.ListImages()
@@ -825,22 +1379,8 @@ func (a *App) CleanupOrphanedImages() (int, error) {
 		return 0, fmt.Errorf("failed to list images: %v", err)
 	}
 
-	// Get all notes
-	var allNotes []*models.Note
-	if a.noteService != nil {
-		allNotes = a.noteService.GetAllNotes()
-	} else {
-		allNotes = a.store.GetAllNotes()
-	}
-
-	// Create a set of all referenced image IDs
-	referencedImages := make(map[string]bool)
-	for _, note := range allNotes {
-		imageIDs := a.extractImageIDsFromContent(note.Content)
-		for _, imageID := range imageIDs {
-			referencedImages[imageID] = true
-		}
-	}
+	// The set of all referenced image IDs
+	referencedImages := a.noteService.ReferencedImages()
 
 	// Delete orphaned images
 	cleanedUp := 0
@@ -873,9 +1413,9 @@ func (a *App) GetImageStats() (map[string]interface{}, error) {
 	// Get all notes
 	var allNotes []*models.Note
 	if a.noteService != nil {
-		allNotes = a.noteService.GetAllNotes()
+		allNotes = a.noteService.GetAllNotesWithContent()
 	} else {
-		allNotes = a.store.GetAllNotes()
+		allNotes = a.store.GetAllNotesWithContent()
 	}
 
 	// Create a set of all referenced image IDs
@@ -915,21 +1455,305 @@ func (a *App) GetNotesByCategory(category string) []types.WailsNote {
 		return []types.WailsNote{}
 	}
 
-	// Convert string to NoteCategory
-	var noteCategory models.NoteCategory
-	switch category {
-	case "private":
-		noteCategory = models.CategoryPrivate
-	case "work":
-		noteCategory = models.CategoryWork
-	case "trash":
-		noteCategory = models.CategoryTrash
-	default:
-		return []types.WailsNote{}
+	notes := a.noteService.GetNotesByCategory(models.NoteCategory(category))
+	return types.ConvertToWailsNotes(notes)
+}
+
+// ListTags returns all tags of notes outside the trash with their note counts, most used first
+func (a *App) ListTags() ([]types.WailsTagCount, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
 	}
 
-	notes := a.noteService.GetNotesByCategory(noteCategory)
-	return types.ConvertToWailsNotes(notes)
+	return types.ConvertToWailsTagCounts(a.noteService.ListTags()), nil
+}
+
+// GetNotesByTags returns the notes with any of the tags, or with all of them if matchAll is set
+func (a *App) GetNotesByTags(tags []string, matchAll bool) ([]types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	return types.ConvertToWailsNotes(a.noteService.GetNotesByTags(tags, matchAll)), nil
+}
+
+// SetNoteTags replaces the tags of a note; #tags written in its content stay
+func (a *App) SetNoteTags(id string, tags []string) (types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNote{}, err
+	}
+
+	note, err := a.noteService.SetNoteTags(id, tags, a.currentKey)
+	if err != nil {
+		return types.WailsNote{}, err
+	}
+	return types.ConvertToWailsNote(note), nil
+}
+
+// RenameTag renames a tag in all notes, including #tags in their content. Renaming to an
+// existing tag merges the two. It returns the number of notes changed.
+func (a *App) RenameTag(from, to string) (int, error) {
+	if err := a.requireAuth(); err != nil {
+		return 0, err
+	}
+
+	return a.noteService.RenameTag(from, to, a.currentKey)
+}
+
+// MergeTags replaces the given tags by one tag in all notes and returns the number of notes changed
+func (a *App) MergeTags(from []string, to string) (int, error) {
+	if err := a.requireAuth(); err != nil {
+		return 0, err
+	}
+
+	return a.noteService.MergeTags(from, to, a.currentKey)
+}
+
+// GetBacklinks returns the notes that link to a note, by its ID or by its title
+func (a *App) GetBacklinks(id string) ([]types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	notes, err := a.noteService.GetBacklinks(id)
+	if err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsNotes(notes), nil
+}
+
+// GetNotesLinkingTo returns the notes with a [[target]] link, whether or not it refers to a note
+func (a *App) GetNotesLinkingTo(target string) ([]types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	return types.ConvertToWailsNotes(a.noteService.GetNotesLinkingTo(target)), nil
+}
+
+// GetUnresolvedLinks returns the [[link]] targets that match no note, with the notes using them
+func (a *App) GetUnresolvedLinks() ([]types.WailsUnresolvedLink, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	return types.ConvertToWailsUnresolvedLinks(a.noteService.GetUnresolvedLinks()), nil
+}
+
+// GetNoteGraph returns the notes outside the trash and the links between them
+func (a *App) GetNoteGraph() (types.WailsNoteGraph, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNoteGraph{}, err
+	}
+
+	return types.ConvertToWailsNoteGraph(a.noteService.GetNoteGraph()), nil
+}
+
+// RenameLinks points all [[from]] links to to, e.g. after the note they refer to was renamed.
+// It returns the number of notes changed.
+func (a *App) RenameLinks(from, to string) (int, error) {
+	if err := a.requireAuth(); err != nil {
+		return 0, err
+	}
+
+	return a.noteService.RenameLinks(from, to, a.currentKey)
+}
+
+// ListNotebooks returns the notebooks outside the trash, parents before their children
+func (a *App) ListNotebooks() ([]types.WailsNotebook, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	notebooks, err := a.noteService.ListNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsNotebooks(notebooks), nil
+}
+
+// ListTrashedNotebooks returns the notebooks that were moved to trash
+func (a *App) ListTrashedNotebooks() ([]types.WailsNotebook, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	notebooks, err := a.noteService.ListTrashedNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsNotebooks(notebooks), nil
+}
+
+// GetNotebookSubtree returns a notebook and all notebooks below it
+func (a *App) GetNotebookSubtree(id string) ([]types.WailsNotebook, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	notebooks, err := a.noteService.GetNotebookSubtree(id)
+	if err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsNotebooks(notebooks), nil
+}
+
+// GetNotesInNotebook returns the notes in a notebook, and in the notebooks below it if
+// recursive is set. An empty ID returns the notes without a notebook.
+func (a *App) GetNotesInNotebook(id string, recursive bool) ([]types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	notes, err := a.noteService.GetNotesInNotebook(id, recursive)
+	if err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsNotes(notes), nil
+}
+
+// CreateNotebook adds a notebook inside parentID, or at the top level if it is empty
+func (a *App) CreateNotebook(name, parentID string) (types.WailsNotebook, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNotebook{}, err
+	}
+
+	notebook, err := a.noteService.CreateNotebook(name, parentID)
+	if err != nil {
+		return types.WailsNotebook{}, err
+	}
+	return types.ConvertToWailsNotebook(notebook), nil
+}
+
+// RenameNotebook renames a notebook
+func (a *App) RenameNotebook(id, name string) (types.WailsNotebook, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNotebook{}, err
+	}
+
+	notebook, err := a.noteService.RenameNotebook(id, name)
+	if err != nil {
+		return types.WailsNotebook{}, err
+	}
+	return types.ConvertToWailsNotebook(notebook), nil
+}
+
+// MoveNotebook moves a notebook into parentID, or to the top level if it is empty
+func (a *App) MoveNotebook(id, parentID string) (types.WailsNotebook, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNotebook{}, err
+	}
+
+	notebook, err := a.noteService.MoveNotebook(id, parentID)
+	if err != nil {
+		return types.WailsNotebook{}, err
+	}
+	return types.ConvertToWailsNotebook(notebook), nil
+}
+
+// MoveNoteToNotebook moves a note into a notebook, or out of its notebook if notebookID is empty
+func (a *App) MoveNoteToNotebook(noteID, notebookID string) (types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNote{}, err
+	}
+
+	note, err := a.noteService.MoveNoteToNotebook(noteID, notebookID, a.currentKey)
+	if err != nil {
+		return types.WailsNote{}, err
+	}
+	return types.ConvertToWailsNote(note), nil
+}
+
+// MoveNotebookToTrash moves a notebook, the notebooks below it and their notes to trash.
+// It returns the number of notes moved.
+func (a *App) MoveNotebookToTrash(id string) (int, error) {
+	if err := a.requireAuth(); err != nil {
+		return 0, err
+	}
+
+	return a.noteService.MoveNotebookToTrash(id, a.currentKey)
+}
+
+// RestoreNotebook takes a notebook out of trash with the notes that went there with it.
+// It returns the number of notes restored.
+func (a *App) RestoreNotebook(id string) (int, error) {
+	if err := a.requireAuth(); err != nil {
+		return 0, err
+	}
+
+	return a.noteService.RestoreNotebook(id, a.currentKey)
+}
+
+// PermanentlyDeleteNotebook deletes a notebook in trash with the notes that went there with it.
+// It returns the number of notes deleted.
+func (a *App) PermanentlyDeleteNotebook(id string) (int, error) {
+	if err := a.requireAuth(); err != nil {
+		return 0, err
+	}
+
+	return a.noteService.PermanentlyDeleteNotebook(id)
+}
+
+// ListCategories returns the user-defined categories in their sort order; trash is not included
+func (a *App) ListCategories() ([]types.WailsCategory, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	categories, err := a.noteService.ListCategories()
+	if err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsCategories(categories), nil
+}
+
+// CreateCategory adds a category with a name and an optional colour such as "#4a90d9"
+func (a *App) CreateCategory(name, color string) (types.WailsCategory, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsCategory{}, err
+	}
+
+	category, err := a.noteService.CreateCategory(name, color)
+	if err != nil {
+		return types.WailsCategory{}, err
+	}
+	return types.ConvertToWailsCategory(category), nil
+}
+
+// UpdateCategory renames a category and sets its colour
+func (a *App) UpdateCategory(id, name, color string) (types.WailsCategory, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsCategory{}, err
+	}
+
+	category, err := a.noteService.UpdateCategory(models.NoteCategory(id), name, color)
+	if err != nil {
+		return types.WailsCategory{}, err
+	}
+	return types.ConvertToWailsCategory(category), nil
+}
+
+// SetCategoryOrder sorts the categories in the order of the given IDs
+func (a *App) SetCategoryOrder(ids []string) error {
+	if err := a.requireAuth(); err != nil {
+		return err
+	}
+
+	order := make([]models.NoteCategory, len(ids))
+	for i, id := range ids {
+		order[i] = models.NoteCategory(id)
+	}
+	return a.noteService.SetCategoryOrder(order)
+}
+
+// DeleteCategory deletes a category and moves its notes to reassignTo, or to the default
+// category if reassignTo is empty. It returns the number of notes that were moved.
+func (a *App) DeleteCategory(id, reassignTo string) (int, error) {
+	if err := a.requireAuth(); err != nil {
+		return 0, err
+	}
+
+	return a.noteService.DeleteCategory(models.NoteCategory(id), models.NoteCategory(reassignTo), a.currentKey)
 } // MoveToTrash moves a note to trash category
 func (a *App) MoveToTrash(id string) (types.WailsNote, error) {
 	if a.currentKey == nil {
@@ -946,12 +1770,22 @@ func (a *App) MoveToTrash(id string) (types.WailsNote, error) {
 // shutdown is called when the app is closing; clean up resources here
 func (a *App) shutdown(ctx context.Context) {
 	// Close file watchers to avoid leaks
+	a.closeStores()
+	// Let background cleanup goroutine exit via context cancellation
+}
+
+// closeStores stops the file watchers of the note and image stores before they are dropped
+func (a *App) closeStores() {
 	if a.store != nil {
 		if err := a.store.Close(); err != nil {
 			log.Printf("warning: failed to close note store watcher: %v", err)
 		}
 	}
-	// Let background cleanup goroutine exit via context cancellation
+	if a.imageStore != nil {
+		if err := a.imageStore.Close(); err != nil {
+			log.Printf("warning: failed to close image store watcher: %v", err)
+		}
+	}
 }
 
 // PermanentlyDeleteNote permanently deletes a note (only works for trash items)
@@ -984,6 +1818,92 @@ func (a *App) PermanentlyDeleteNote(id string) error {
 	return nil
 }
 
+// ListNoteRevisions returns the stored earlier versions of a note, newest first
+func (a *App) ListNoteRevisions(id string) ([]types.WailsRevision, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	revisions, err := a.noteService.ListRevisions(id)
+	if err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsRevisions(revisions), nil
+}
+
+// GetNoteRevision returns a note as it was in a stored revision
+func (a *App) GetNoteRevision(id, revisionID string) (types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNote{}, err
+	}
+
+	revision, err := a.noteService.GetRevision(id, revisionID)
+	if err != nil {
+		return types.WailsNote{}, err
+	}
+	return types.ConvertToWailsNote(revision), nil
+}
+
+// DiffNoteRevision returns the line changes from a stored revision to the current note
+func (a *App) DiffNoteRevision(id, revisionID string) ([]utils.DiffLine, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+
+	return a.noteService.DiffRevision(id, revisionID)
+}
+
+// RestoreNoteRevision makes a stored revision the current content of a note
+func (a *App) RestoreNoteRevision(id, revisionID string) (types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNote{}, err
+	}
+
+	note, err := a.noteService.RestoreRevision(id, revisionID, a.currentKey)
+	if err != nil {
+		return types.WailsNote{}, err
+	}
+	return types.ConvertToWailsNote(note), nil
+}
+
+// GetNotesNeedingReview returns notes whose automatic merge of edits from two devices left conflict markers
+func (a *App) GetNotesNeedingReview() ([]types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsNotes(a.noteService.GetNotesNeedingReview()), nil
+}
+
+// MarkNoteReviewed clears the review flag of a merged note
+func (a *App) MarkNoteReviewed(id string) (types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return types.WailsNote{}, err
+	}
+
+	note, err := a.noteService.MarkNoteReviewed(id, a.currentKey)
+	if err != nil {
+		return types.WailsNote{}, err
+	}
+	return types.ConvertToWailsNote(note), nil
+}
+
+// GetNotesWithSyncConflicts returns notes that have conflicting copies left by a sync client.
+// The copies are listed by ListNoteRevisions and can be compared and restored like revisions.
+func (a *App) GetNotesWithSyncConflicts() ([]types.WailsNote, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+	return types.ConvertToWailsNotes(a.noteService.GetNotesWithSyncConflicts()), nil
+}
+
+// DismissSyncConflicts keeps the current version of a note and moves its sync conflict copies into its history
+func (a *App) DismissSyncConflicts(id string) error {
+	if err := a.requireAuth(); err != nil {
+		return err
+	}
+	return a.noteService.DismissSyncConflicts(id)
+}
+
 // RestoreFromTrash restores a note from trash to its original category
 func (a *App) RestoreFromTrash(id string) (types.WailsNote, error) {
 	if a.currentKey == nil {
@@ -996,3 +1916,12 @@ func (a *App) RestoreFromTrash(id string) (types.WailsNote, error) {
 	}
 	return types.ConvertToWailsNote(note), nil
 }
+
+// SyncFromDisk reads notes that changed on disk and reports how many were added, updated,
+// removed and left unread because they did not change
+func (a *App) SyncFromDisk() (*storage.SyncStats, error) {
+	if err := a.requireAuth(); err != nil {
+		return nil, err
+	}
+	return a.noteService.SyncFromDisk()
+}
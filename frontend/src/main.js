@@ -30,6 +30,8 @@ import {
   CreateBackup,
   IsConfigured,
   CompleteInitialSetup,
+  RecoverWithKey,
+  IsKeyfileRequired,
   SaveImageFromClipboard,
   GetImage,
   DeleteImage,
@@ -37,6 +39,10 @@ import {
   GetImageAsDataURL,
   CleanupOrphanedImages,
   GetImageStats,
+  MarkNoteReviewed,
+  ListCategories,
+  GetNotesLinkingTo,
+  RenameLinks,
 } from "../wailsjs/go/main/App.js";
 
 // Import Wails runtime for browser functionality
@@ -44,6 +50,7 @@ import {
   BrowserOpenURL,
   ClipboardGetText,
   ClipboardSetText,
+  EventsOn,
 } from "../wailsjs/runtime/runtime.js";
 
 // State management
@@ -52,11 +59,13 @@ let currentNote = null;
 let isDraftMode = false; // Track if we're creating a new note that hasn't been saved yet
 let draftCategory = "private"; // Track the category for draft notes
 let originalNoteContent = ""; // Track original content to detect changes
+let openedNoteTitle = ""; // Title of the note when it was opened, to offer updating links after a rename
 let autosaveTimer = null; // Debounced autosave timer
 let allNotes = [];
 let filteredNotes = [];
 let searchQuery = "";
 let currentCategory = "private"; // Track currently selected category
+let categories = []; // User-defined categories in their sort order
 
 // Markdown instance
 let markedInstance = null;
@@ -157,24 +166,24 @@ async function callAPI(apiCall) {
 // DOM elements
 let authScreen, mainApp, settingsScreen, passwordSetup, passwordLogin;
 let setupPasswordInput, confirmPasswordInput, setupBtn;
-let loginPasswordInput, loginBtn, loginError, resetPasswordBtn;
+let loginPasswordInput, loginKeyfileInput, loginBtn, loginError;
+let resetPasswordBtn, recoverBtn;
 let newNoteBtn, newNoteFromClipboardBtn, searchInput, searchBtn, clearSearchBtn;
 let settingsBtn, trashBtn, notesGrid, noteEditor;
 let noteContent, searchResultsHeader, emptyState;
+let vaultLoadingBanner, vaultLoadingText;
 let saveNoteBtn, cancelEditorBtn, createFirstNoteBtn;
 let backFromSettings;
 let createBackupBtn, logoutBtn;
 let notesPathInput, passwordHashPathInput, saveSettingsBtn;
 
-// Category filter elements
-let filterPrivateBtn, filterWorkBtn;
-
-// Editor category filter elements
-let editorFilterPrivateBtn, editorFilterWorkBtn;
+// Category filter containers, filled by renderCategories
+let categoryFilter, editorCategoryFilter;
 
 // Setup screen elements
 let initialSetupScreen, setupNotesPath, setupPasswordHashPath;
-let setupMasterPassword, setupConfirmPassword, completeSetupBtn;
+let setupMasterPassword, setupConfirmPassword, setupKeyfilePath;
+let completeSetupBtn;
 
 // Delete confirmation modal elements
 let deleteModal, confirmDeleteBtn, cancelDeleteBtn;
@@ -207,9 +216,11 @@ function initializeDOM() {
   confirmPasswordInput = document.getElementById("confirm-password");
   setupBtn = document.getElementById("setup-btn");
   loginPasswordInput = document.getElementById("login-password");
+  loginKeyfileInput = document.getElementById("login-keyfile");
   loginBtn = document.getElementById("login-btn");
   loginError = document.getElementById("login-error");
   resetPasswordBtn = document.getElementById("reset-password-btn");
+  recoverBtn = document.getElementById("recover-btn");
   newNoteBtn = document.getElementById("new-note-btn");
   newNoteFromClipboardBtn = document.getElementById(
     "new-note-from-clipboard-btn"
@@ -224,6 +235,8 @@ function initializeDOM() {
   noteContent = document.getElementById("note-content");
   searchResultsHeader = document.getElementById("search-results-header");
   emptyState = document.getElementById("empty-state");
+  vaultLoadingBanner = document.getElementById("vault-loading");
+  vaultLoadingText = document.getElementById("vault-loading-text");
   saveNoteBtn = document.getElementById("save-note-btn");
   cancelEditorBtn = document.getElementById("cancel-editor-btn");
   createFirstNoteBtn = document.getElementById("create-first-note");
@@ -231,13 +244,9 @@ function initializeDOM() {
   createBackupBtn = document.getElementById("create-backup-btn");
   logoutBtn = document.getElementById("logout-btn");
 
-  // Category filter elements
-  filterPrivateBtn = document.getElementById("filter-private");
-  filterWorkBtn = document.getElementById("filter-work");
-
-  // Editor category filter elements
-  editorFilterPrivateBtn = document.getElementById("editor-filter-private");
-  editorFilterWorkBtn = document.getElementById("editor-filter-work");
+  // Category filter containers
+  categoryFilter = document.getElementById("category-filter");
+  editorCategoryFilter = document.getElementById("editor-category-filter");
 
   // Settings input elements
   notesPathInput = document.getElementById("notes-path-input");
@@ -250,6 +259,7 @@ function initializeDOM() {
   setupPasswordHashPath = document.getElementById("setup-password-hash-path");
   setupMasterPassword = document.getElementById("setup-master-password");
   setupConfirmPassword = document.getElementById("setup-confirm-password");
+  setupKeyfilePath = document.getElementById("setup-keyfile-path");
   completeSetupBtn = document.getElementById("complete-setup-btn");
 
   // Delete confirmation modal elements
@@ -324,6 +334,7 @@ function setupEventListeners() {
   setupBtn.addEventListener("click", handlePasswordSetup);
   loginBtn.addEventListener("click", handleLogin);
   resetPasswordBtn.addEventListener("click", handlePasswordReset);
+  recoverBtn.addEventListener("click", handleRecoverWithKey);
 
   // Enter key listeners for auth
   setupPasswordInput.addEventListener("keypress", (e) => {
@@ -335,6 +346,9 @@ function setupEventListeners() {
   loginPasswordInput.addEventListener("keypress", (e) => {
     if (e.key === "Enter") handleLogin();
   });
+  loginKeyfileInput.addEventListener("keypress", (e) => {
+    if (e.key === "Enter") handleLogin();
+  });
 
   // Main app listeners
   newNoteBtn.addEventListener("click", createNewNote);
@@ -345,18 +359,6 @@ function setupEventListeners() {
   settingsBtn.addEventListener("click", openSettings);
   createFirstNoteBtn.addEventListener("click", createNewNote);
 
-  // Category filter listeners
-  filterPrivateBtn.addEventListener("click", () => switchCategory("private"));
-  filterWorkBtn.addEventListener("click", () => switchCategory("work"));
-
-  // Editor category filter listeners
-  editorFilterPrivateBtn.addEventListener("click", () =>
-    switchEditorCategory("private")
-  );
-  editorFilterWorkBtn.addEventListener("click", () =>
-    switchEditorCategory("work")
-  );
-
   // Search input listener with debouncing
   searchInput.addEventListener("keypress", (e) => {
     if (e.key === "Enter") handleSearch();
@@ -435,6 +437,108 @@ function setupEventListeners() {
 
   // Clipboard handling for images
   document.addEventListener("paste", handleClipboardPaste);
+
+  // Changes made on disk by other devices or tools
+  EventsOn("note:created", handleExternalNoteChange);
+  EventsOn("note:updated", handleExternalNoteChange);
+  EventsOn("note:deleted", handleExternalNoteChange);
+  EventsOn("note:corrupted", handleCorruptedNote);
+
+  // Notes edited on two devices at once are kept side by side
+  EventsOn("note:conflict", handleNoteConflict);
+
+  // Notes of a large vault appear while they are being decrypted
+  EventsOn("vault:loading", handleVaultLoading);
+  EventsOn("vault:loaded", handleVaultLoaded);
+
+  // Images synced from or removed on other devices
+  EventsOn("image:created", handleExternalImageChange);
+  EventsOn("image:deleted", handleExternalImageChange);
+}
+
+// Reload the list once a burst of external changes has settled
+let externalReloadTimer = null;
+function scheduleExternalReload() {
+  if (externalReloadTimer) clearTimeout(externalReloadTimer);
+  externalReloadTimer = setTimeout(() => {
+    externalReloadTimer = null;
+    loadCategories().then(loadNotes);
+  }, 200);
+}
+
+function handleExternalNoteChange(note) {
+  if (!currentUser) return;
+
+  // Show the new content in the editor unless there are unsaved local edits
+  const editorOpen = !noteEditor.classList.contains("hidden");
+  if (
+    editorOpen &&
+    currentNote &&
+    note &&
+    note.id === currentNote.id &&
+    note.content !== undefined &&
+    !note.partial &&
+    noteContent.value === currentNote.content
+  ) {
+    currentNote = note;
+    noteContent.value = note.content;
+    originalNoteContent = note.content;
+  }
+
+  scheduleExternalReload();
+}
+
+// Show the notes decrypted so far, most recent first, at most twice a second
+let lastVaultLoadingRefresh = 0;
+function handleVaultLoading(progress) {
+  if (!currentUser || !progress) return;
+
+  vaultLoadingBanner.style.display = "block";
+  vaultLoadingText.textContent = `Decrypting notes... ${progress.loaded} of ${progress.total}`;
+
+  const now = Date.now();
+  if (now - lastVaultLoadingRefresh > 500) {
+    lastVaultLoadingRefresh = now;
+    loadNotes();
+  }
+}
+
+function handleVaultLoaded() {
+  vaultLoadingBanner.style.display = "none";
+  if (currentUser) loadNotes();
+}
+
+// Render the notes showing an image again, so they pick up or drop it
+function handleExternalImageChange(change) {
+  if (!currentUser || !change || !change.note_ids || change.note_ids.length === 0) return;
+  scheduleExternalReload();
+}
+
+function handleCorruptedNote(note) {
+  if (!currentUser) return;
+  console.warn(`Note ${note && note.id} could not be read from disk`);
+  scheduleExternalReload();
+}
+
+async function handleNoteConflict(conflict) {
+  if (!currentUser) return;
+  await loadNotes();
+  switch (conflict && conflict.kind) {
+    case "copy":
+      alert(
+        "A note was changed on another device while you were editing it. Both versions were kept; your version was saved as a separate conflict copy."
+      );
+      break;
+    case "sync_file":
+      alert(
+        "Your sync client kept a conflicting copy of a note. It is listed in the note's history, where it can be compared and restored."
+      );
+      break;
+    default:
+      alert(
+        "A note was changed on this and another device at the same time. The changes were merged, but some lines conflict and are marked in the note for review."
+      );
+  }
 }
 
 async function checkAuthState() {
@@ -449,6 +553,9 @@ async function checkAuthState() {
       // Clear password field when showing login screen
       loginPasswordInput.value = "";
       loginPasswordInput.focus();
+      // Only ask for the keyfile if the vault was set up with one
+      const keyfileRequired = await IsKeyfileRequired();
+      loginKeyfileInput.style.display = keyfileRequired ? "block" : "none";
     } else {
       passwordSetup.style.display = "block";
       passwordLogin.style.display = "none";
@@ -491,13 +598,20 @@ async function handleLogin() {
   }
 
   try {
-    const isValid = await VerifyPassword(password);
+    const isValid = await VerifyPassword(
+      password,
+      loginKeyfileInput.value.trim()
+    );
     if (isValid) {
       // Clear password field on successful login
       loginPasswordInput.value = "";
       initializeApp();
     } else {
-      showLoginError("Invalid password");
+      showLoginError(
+        loginKeyfileInput.style.display === "none"
+          ? "Invalid password"
+          : "Invalid password or keyfile"
+      );
       loginPasswordInput.value = "";
       loginPasswordInput.focus();
     }
@@ -564,25 +678,40 @@ async function handleCompleteSetup() {
   const passwordHashPath = setupPasswordHashPath.value.trim();
   const password = setupMasterPassword.value;
   const confirmPassword = setupConfirmPassword.value;
+  const keyfilePath = setupKeyfilePath.value.trim();
 
   // Disable button during setup
   completeSetupBtn.disabled = true;
   completeSetupBtn.textContent = "Setting up...";
 
   try {
-    await CompleteInitialSetup(
+    const recoveryKey = await CompleteInitialSetup(
       notesPath,
       passwordHashPath,
       password,
-      confirmPassword
+      confirmPassword,
+      keyfilePath
     );
+    showRecoveryKey(recoveryKey);
 
     // Setup completed successfully, initialize the app
     initialSetupScreen.style.display = "none";
     initializeApp();
   } catch (error) {
     console.error("Error completing setup:", error);
-    alert("Setup failed: " + error.message);
+    const message = error.message || String(error);
+    if (message.includes("vault created without a recovery key")) {
+      // The vault works, but a forgotten password cannot be recovered
+      alert(
+        "Your vault was set up, but no recovery key could be created:\n\n" +
+          message +
+          "\n\nWithout a recovery key, notes cannot be recovered if you forget your password."
+      );
+      initialSetupScreen.style.display = "none";
+      initializeApp();
+      return;
+    }
+    alert("Setup failed: " + message);
   } finally {
     completeSetupBtn.disabled = false;
     completeSetupBtn.textContent = "🚀 Complete Setup";
@@ -597,13 +726,61 @@ async function initializeApp() {
   // Start activity tracking for auto-logout
   startActivityTracking();
 
-  // Update category buttons to show active state
-  updateCategoryButtons();
+  // Show the vault's categories
+  await loadCategories();
 
   // Load notes
   await loadNotes();
 }
 
+// The category new notes go to when none is selected, like the backend's default
+function defaultCategory() {
+  if (categories.some((c) => c.id === "private")) return "private";
+  return categories.length > 0 ? categories[0].id : "private";
+}
+
+async function loadCategories() {
+  try {
+    categories = (await callAPI(() => ListCategories())) || [];
+  } catch (error) {
+    console.error("Error loading categories:", error);
+  }
+
+  // The selected category may have been deleted, e.g. on another device
+  if (
+    currentCategory !== "trash" &&
+    !categories.some((c) => c.id === currentCategory)
+  ) {
+    currentCategory = defaultCategory();
+  }
+
+  renderCategories();
+  updateCategoryButtons();
+}
+
+function renderCategories() {
+  const renderInto = (container, onClick) => {
+    container.innerHTML = "";
+    categories.forEach((category) => {
+      const button = document.createElement("button");
+      button.className = "category-btn";
+      button.dataset.category = category.id;
+      if (category.color) {
+        const dot = document.createElement("span");
+        dot.className = "category-dot";
+        dot.style.background = category.color;
+        button.appendChild(dot);
+      }
+      button.appendChild(document.createTextNode(category.name));
+      button.addEventListener("click", () => onClick(category.id));
+      container.appendChild(button);
+    });
+  };
+
+  renderInto(categoryFilter, switchCategory);
+  renderInto(editorCategoryFilter, switchEditorCategory);
+}
+
 async function loadNotes() {
   try {
     // Load notes filtered by current category
@@ -664,6 +841,7 @@ function renderNotesList() {
     sortedNotes.forEach((note) => {
       const noteCard = document.createElement("div");
       noteCard.className = "note-card";
+      noteCard.classList.toggle("needs-review", !!note.needs_review);
       noteCard.dataset.noteId = note.id;
 
       const noteActions = document.createElement("div");
@@ -680,6 +858,12 @@ function renderNotesList() {
             <button class="edit-btn" data-note-id="${note.id}">Edit</button>
             <button class="delete-btn" data-note-id="${note.id}">Delete</button>
           `;
+        if (note.needs_review) {
+          noteActions.insertAdjacentHTML(
+            "afterbegin",
+            `<button class="reviewed-btn" data-note-id="${note.id}" title="Merge conflicts in this note are resolved">Mark Reviewed</button>`
+          );
+        }
       }
 
       const noteContentDiv = document.createElement("div");
@@ -723,6 +907,19 @@ function renderNotesList() {
           e.stopPropagation();
           deleteNote(note.id);
         });
+
+        const reviewedBtn = noteCard.querySelector(".reviewed-btn");
+        if (reviewedBtn) {
+          reviewedBtn.addEventListener("click", async (e) => {
+            e.stopPropagation();
+            try {
+              await callAPI(() => MarkNoteReviewed(note.id));
+              await loadNotes();
+            } catch (error) {
+              console.error("Error marking note as reviewed:", error);
+            }
+          });
+        }
       }
 
       fragment.appendChild(noteCard);
@@ -909,8 +1106,9 @@ function renderMarkdown(content) {
 
 async function createNewNote() {
   try {
-    // Don't create notes in trash category - use private instead
-    const category = currentCategory === "trash" ? "private" : currentCategory;
+    // Don't create notes in trash category - use the default category instead
+    const category =
+      currentCategory === "trash" ? defaultCategory() : currentCategory;
 
     // Enter draft mode - don't create the note yet, just open the editor
     isDraftMode = true;
@@ -957,8 +1155,9 @@ async function createNoteFromClipboard() {
       return;
     }
 
-    // Don't create notes in trash category - switch to private instead
-    const category = currentCategory === "trash" ? "private" : currentCategory;
+    // Don't create notes in trash category - switch to the default category instead
+    const category =
+      currentCategory === "trash" ? defaultCategory() : currentCategory;
 
     // Create note with the clipboard content
     const newNote = await CreateNoteWithCategory(clipboardContent, category);
@@ -1014,6 +1213,7 @@ async function editNote(noteId) {
     isDraftMode = false; // Ensure we're not in draft mode when editing existing note
     noteContent.value = note.content;
     originalNoteContent = note.content; // Track original content for change detection
+    openedNoteTitle = note.title;
 
     // Set the editor category buttons to reflect the note's category
     updateEditorCategoryButtons(note.category);
@@ -1118,14 +1318,44 @@ function closeEditor() {
     autosaveTimer = null;
   }
 
+  if (
+    currentNote &&
+    openedNoteTitle &&
+    currentNote.title.toLowerCase() !== openedNoteTitle.toLowerCase()
+  ) {
+    offerLinkRewrite(currentNote.id, openedNoteTitle, currentNote.title);
+  }
+
   noteEditor.classList.add("hidden");
   currentNote = null;
+  openedNoteTitle = "";
   isDraftMode = false; // Reset draft mode
-  draftCategory = "private"; // Reset draft category
+  draftCategory = defaultCategory(); // Reset draft category
   noteContent.value = "";
   originalNoteContent = ""; // Reset original content tracking
 }
 
+// Offers to point the [[links]] to a renamed note's old title to its new title
+async function offerLinkRewrite(noteId, oldTitle, newTitle) {
+  try {
+    const linking = (await GetNotesLinkingTo(oldTitle)).filter(
+      (note) => note.id !== noteId
+    );
+    if (linking.length === 0 || !newTitle) return;
+
+    const notes =
+      linking.length === 1 ? "1 note links" : `${linking.length} notes link`;
+    if (
+      confirm(`${notes} to "${oldTitle}". Update the links to "${newTitle}"?`)
+    ) {
+      await RenameLinks(oldTitle, newTitle);
+      await loadNotes();
+    }
+  } catch (error) {
+    console.error("Error updating links:", error);
+  }
+}
+
 async function saveCurrentNote() {
   // Handle draft mode - create note if it doesn't exist yet
   if (isDraftMode) {
@@ -1400,6 +1630,61 @@ async function handlePasswordReset() {
   }
 }
 
+function showRecoveryKey(recoveryKey) {
+  if (!recoveryKey) {
+    return;
+  }
+  alert(
+    "Your recovery key:\n\n" +
+      recoveryKey +
+      "\n\nWrite it down and keep it somewhere safe. " +
+      "It can unlock your notes if you forget your password and will not be shown again."
+  );
+}
+
+async function handleRecoverWithKey() {
+  const recoveryKey = prompt("Enter your recovery key:");
+  if (!recoveryKey) {
+    return;
+  }
+
+  const newPassword = prompt("Enter a new master password (min 6 characters):");
+  if (!newPassword) {
+    return;
+  }
+  const confirmPassword = prompt("Confirm the new master password:");
+  if (confirmPassword === null) {
+    return;
+  }
+
+  try {
+    const newRecoveryKey = await RecoverWithKey(
+      recoveryKey,
+      newPassword,
+      confirmPassword
+    );
+    showRecoveryKey(newRecoveryKey);
+    initializeApp();
+  } catch (error) {
+    console.error("Error recovering with key:", error);
+    showLoginError("Recovery failed: " + (error.message || error));
+  }
+}
+
+// Find the listed notes containing the query. With lazy loading the list only holds
+// titles, so the backend searches the contents instead.
+async function searchListedNotes(query) {
+  if (!allNotes.some((note) => note.partial)) {
+    return allNotes.filter((note) =>
+      note.content.toLowerCase().includes(query)
+    );
+  }
+
+  const listed = new Set(allNotes.map((note) => note.id));
+  const results = (await SearchNotes(query)) || [];
+  return results.filter((note) => listed.has(note.id));
+}
+
 async function handleSearch() {
   const query = searchInput.value.trim().toLowerCase();
   searchQuery = query;
@@ -1409,15 +1694,15 @@ async function handleSearch() {
     filteredNotes = [...allNotes];
   } else {
     // Filter notes by search query
-    filteredNotes = allNotes.filter((note) =>
-      note.content.toLowerCase().includes(query)
-    );
+    const results = await searchListedNotes(query);
+    if (query !== searchQuery) return; // Superseded by a newer search
+    filteredNotes = results;
   }
 
   renderNotesList();
 }
 
-function handleSearchInput() {
+async function handleSearchInput() {
   // Real-time search as user types
   const query = searchInput.value.trim().toLowerCase();
   searchQuery = query;
@@ -1426,9 +1711,9 @@ function handleSearchInput() {
     clearSearch();
   } else {
     // Filter notes by search query
-    filteredNotes = allNotes.filter((note) =>
-      note.content.toLowerCase().includes(query)
-    );
+    const results = await searchListedNotes(query);
+    if (query !== searchQuery) return; // Superseded by a newer search
+    filteredNotes = results;
     renderNotesList();
   }
 }
@@ -1480,41 +1765,21 @@ function switchCategory(category) {
 }
 
 function updateCategoryButtons() {
-  // Remove active class from all category filter buttons
-  filterPrivateBtn.classList.remove("active");
-  filterWorkBtn.classList.remove("active");
-
-  // Remove active class from trash button
-  trashBtn.classList.remove("active");
-
-  // Add active class to current category button
-  switch (currentCategory) {
-    case "private":
-      filterPrivateBtn.classList.add("active");
-      break;
-    case "work":
-      filterWorkBtn.classList.add("active");
-      break;
-    case "trash":
-      trashBtn.classList.add("active");
-      break;
-  }
+  // Mark the current category button, or the trash button, as active
+  categoryFilter.querySelectorAll(".category-btn").forEach((button) => {
+    button.classList.toggle(
+      "active",
+      button.dataset.category === currentCategory
+    );
+  });
+  trashBtn.classList.toggle("active", currentCategory === "trash");
 }
 
 function updateEditorCategoryButtons(category) {
-  // Remove active class from all editor category buttons
-  editorFilterPrivateBtn.classList.remove("active");
-  editorFilterWorkBtn.classList.remove("active");
-
-  // Add active class to the specified category button
-  switch (category) {
-    case "private":
-      editorFilterPrivateBtn.classList.add("active");
-      break;
-    case "work":
-      editorFilterWorkBtn.classList.add("active");
-      break;
-  }
+  // Mark the button of the specified category as active
+  editorCategoryFilter.querySelectorAll(".category-btn").forEach((button) => {
+    button.classList.toggle("active", button.dataset.category === category);
+  });
 }
 
 async function switchEditorCategory(category) {
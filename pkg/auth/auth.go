@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,15 +21,25 @@ const SessionTimeout = 30 * time.Minute
 
 // PasswordData stores password hash and salt
 type PasswordData struct {
-	Hash string `json:"hash"`
-	Salt string `json:"salt"`
+	Hash    string            `json:"hash"`
+	Salt    string            `json:"salt"`
+	KDF     *crypto.KDFParams `json:"kdf,omitempty"`     // Nil for hashes created with legacy PBKDF2
+	Keyfile bool              `json:"keyfile,omitempty"` // Whether the keyfile hash is mixed into the hash
 }
 
 // CrossPlatformConfig stores the salt in the synced notes directory for cross-platform compatibility
 type CrossPlatformConfig struct {
-	Salt      string `json:"salt"`
-	CreatedAt string `json:"createdAt"`
-	Version   string `json:"version"`
+	Salt      string    `json:"salt"`
+	CreatedAt string    `json:"createdAt"`
+	Version   string    `json:"version"`
+	KeySlots  []KeySlot `json:"keySlots,omitempty"` // Wrapped master key, once per unlock method
+
+	// LegacyMasterKey marks a master key that was derived with PBKDF2 by a pre-wrapping vault
+	LegacyMasterKey bool `json:"legacyMasterKey,omitempty"`
+	// KeyfileRequired tells every device that the password alone does not unlock the vault
+	KeyfileRequired bool `json:"keyfileRequired,omitempty"`
+	// EncryptedMetadata tells every device to keep note timestamps inside the ciphertext
+	EncryptedMetadata bool `json:"encryptedMetadata,omitempty"`
 }
 
 // Manager handles authentication and session management
@@ -37,6 +49,9 @@ type Manager struct {
 	passwordHashPath string
 	currentSalt      []byte // Store the current salt for key derivation
 	notesDir         string // Store notes directory for cross-platform config
+	keyfileHash      []byte // Hash of the keyfile supplied for unlocking, if any
+
+	backup func() (string, error) // Backs up the vault before a one-time migration rewrites its config, see SetBackup
 }
 
 // NewManager creates a new authentication manager
@@ -56,6 +71,11 @@ func NewManagerWithNotesDir(passwordHashPath, notesDir string) *Manager {
 	}
 }
 
+// SetBackup sets the function that backs up the vault before its shared config is migrated
+func (m *Manager) SetBackup(backup func() (string, error)) {
+	m.backup = backup
+}
+
 // IsFirstTimeSetup checks if this is the first time setup (no password hash exists AND no cross-platform config exists)
 func (m *Manager) IsFirstTimeSetup() bool {
 	// Check if local password hash exists
@@ -87,33 +107,25 @@ func (m *Manager) StorePasswordHash(password string) error {
 	if m.notesDir != "" {
 		configPath := filepath.Join(m.notesDir, ".gote_config.json")
 		if _, err := os.Stat(configPath); err == nil {
+			// Joining a vault with a wrapped master key - the password has to unlock it
+			if _, slot := m.passwordSlot(); slot != nil {
+				if _, err := slot.unlock(password, m.keyfileHash); err != nil {
+					return fmt.Errorf("password or keyfile does not unlock the existing vault")
+				}
+				return m.useSlotSalt(password, slot)
+			}
+
 			if salt, err := m.loadCrossPlatformSalt(); err == nil {
 				// Use the existing cross-platform salt
 				m.currentSalt = salt
 
-				// Create verification hash using the existing salt
-				verificationKey := crypto.DeriveKey(password+"verification", salt)
-
-				passwordData := PasswordData{
-					Hash: base64.StdEncoding.EncodeToString(verificationKey),
-					Salt: base64.StdEncoding.EncodeToString(salt),
-				}
-
-				// Ensure password hash directory exists
-				hashDir := filepath.Dir(m.passwordHashPath)
-				if err := os.MkdirAll(hashDir, 0755); err != nil {
-					return err
-				}
-
-				data, err := json.Marshal(passwordData)
-				if err != nil {
-					return fmt.Errorf("failed to marshal password data: %v", err)
-				}
-
 				// Save local password hash with existing salt
-				return os.WriteFile(m.passwordHashPath, data, 0600)
+				return m.createLocalPasswordHashFromCrossPlatform(password, salt)
 			}
 		}
+
+		// New vault - generate a random master key protected by the password
+		return m.createVault(password)
 	}
 
 	// Generate salt for password verification
@@ -125,39 +137,8 @@ func (m *Manager) StorePasswordHash(password string) error {
 	// Store the salt for key derivation
 	m.currentSalt = salt
 
-	// Create verification hash using PBKDF2
-	verificationKey := crypto.DeriveKey(password+"verification", salt)
-
-	passwordData := PasswordData{
-		Hash: base64.StdEncoding.EncodeToString(verificationKey),
-		Salt: base64.StdEncoding.EncodeToString(salt),
-	}
-
-	// Ensure password hash directory exists
-	hashDir := filepath.Dir(m.passwordHashPath)
-	if err := os.MkdirAll(hashDir, 0755); err != nil {
-		return err
-	}
-
-	data, err := json.Marshal(passwordData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal password data: %v", err)
-	}
-
 	// Save local password hash
-	if err := os.WriteFile(m.passwordHashPath, data, 0600); err != nil {
-		return err
-	}
-
-	// Save cross-platform config if notes directory is set
-	if m.notesDir != "" {
-		if err := m.saveCrossPlatformSalt(salt); err != nil {
-			// Log warning but don't fail - local storage still works
-			fmt.Printf("Warning: Could not save cross-platform config: %v\n", err)
-		}
-	}
-
-	return nil
+	return m.createLocalPasswordHashFromCrossPlatform(password, salt)
 }
 
 // VerifyPassword verifies the provided password against the stored hash
@@ -166,6 +147,18 @@ func (m *Manager) VerifyPassword(password string) bool {
 		return false
 	}
 
+	// Vaults with a wrapped master key are verified by unwrapping it
+	if _, slot := m.passwordSlot(); slot != nil {
+		if _, err := slot.unlock(password, m.keyfileHash); err != nil {
+			return false
+		}
+		if err := m.useSlotSalt(password, slot); err != nil {
+			// Log warning but don't fail - the master key was unwrapped successfully
+			log.Printf("Warning: Could not update local password hash: %v", err)
+		}
+		return true
+	}
+
 	// Try local password hash first
 	data, err := os.ReadFile(m.passwordHashPath)
 	if err == nil {
@@ -173,15 +166,24 @@ func (m *Manager) VerifyPassword(password string) bool {
 		if err := json.Unmarshal(data, &passwordData); err == nil {
 			// Decode the stored salt
 			salt, err := base64.StdEncoding.DecodeString(passwordData.Salt)
-			if err == nil {
+			// Skip a local hash left behind by a password change on another device
+			if err == nil && !m.isLocalSaltStale(salt) {
 				// Store the salt for key derivation
 				m.currentSalt = salt
 
-				// Create verification hash using the same salt
-				verificationKey := crypto.DeriveKey(password+"verification", salt)
-				computedHash := base64.StdEncoding.EncodeToString(verificationKey)
-
-				if computedHash == passwordData.Hash {
+				// Create verification hash using the same salt and parameters
+				params := crypto.LegacyKDFParams()
+				if passwordData.KDF != nil {
+					params = *passwordData.KDF
+				}
+				verificationKey, err := crypto.DeriveKeyWithParams(password+"verification", salt, params)
+				if err == nil && passwordData.Keyfile {
+					if m.keyfileHash == nil {
+						return false
+					}
+					verificationKey = crypto.CombineWithKeyfile(verificationKey, m.keyfileHash)
+				}
+				if err == nil && base64.StdEncoding.EncodeToString(verificationKey) == passwordData.Hash {
 					return true
 				}
 			}
@@ -202,7 +204,7 @@ func (m *Manager) VerifyPassword(password string) bool {
 				// Password is correct - create local password hash for faster future logins
 				if err := m.createLocalPasswordHashFromCrossPlatform(password, salt); err != nil {
 					// Log warning but don't fail - cross-platform verification already passed
-					fmt.Printf("Warning: Could not create local password hash: %v\n", err)
+					log.Printf("Warning: Could not create local password hash: %v", err)
 				}
 				return true
 			}
@@ -295,18 +297,29 @@ func (m *Manager) RemovePasswordHash() error {
 	return os.Remove(m.passwordHashPath)
 }
 
-// DeriveEncryptionKey derives the encryption key from password using the stored salt
+// DeriveEncryptionKey returns the vault master key for the password.
+// Legacy vaults, whose notes are encrypted directly with the password-derived key,
+// are migrated by wrapping that key so it becomes the master key.
 func (m *Manager) DeriveEncryptionKey(password string) ([]byte, error) {
+	if _, slot := m.passwordSlot(); slot != nil {
+		masterKey, err := slot.unlock(password, m.keyfileHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock master key: %v", err)
+		}
+		return masterKey, nil
+	}
+
 	if m.currentSalt == nil {
 		// Try loading salt from cross-platform config first (for multi-device support)
 		if m.notesDir != "" {
 			salt, err := m.loadCrossPlatformSalt()
 			if err == nil {
 				m.currentSalt = salt
-				return crypto.DeriveKey(password, m.currentSalt), nil
 			}
 		}
+	}
 
+	if m.currentSalt == nil {
 		// Load salt from local password file
 		if m.IsFirstTimeSetup() {
 			return nil, fmt.Errorf("no password set up")
@@ -328,37 +341,39 @@ func (m *Manager) DeriveEncryptionKey(password string) ([]byte, error) {
 		}
 
 		m.currentSalt = salt
+	}
 
-		// Create cross-platform config if it doesn't exist and notes directory is set
-		if m.notesDir != "" {
-			configPath := filepath.Join(m.notesDir, ".gote_config.json")
-			if _, err := os.Stat(configPath); os.IsNotExist(err) {
-				if err := m.saveCrossPlatformSalt(salt); err != nil {
-					// Log warning but don't fail
-					fmt.Printf("Warning: Could not create cross-platform config: %v\n", err)
-				}
-			}
+	legacyKey := crypto.DeriveKey(password, m.currentSalt)
+
+	// Wrap the legacy key so future password changes only need to rewrap it
+	if m.notesDir != "" {
+		if err := m.migrateToMasterKey(password, legacyKey); err != nil {
+			// Log warning but don't fail - the legacy key still works
+			log.Printf("Warning: Could not migrate vault to a wrapped master key: %v", err)
 		}
 	}
 
-	return crypto.DeriveKey(password, m.currentSalt), nil
+	return legacyKey, nil
 }
 
-// loadCrossPlatformSalt loads salt from the notes directory for cross-platform compatibility
-func (m *Manager) loadCrossPlatformSalt() ([]byte, error) {
+// isLocalSaltStale reports whether the cross-platform salt differs from the local one,
+// which happens after the password was changed on another device
+func (m *Manager) isLocalSaltStale(localSalt []byte) bool {
 	if m.notesDir == "" {
-		return nil, fmt.Errorf("notes directory not set")
+		return false
 	}
-
-	configPath := filepath.Join(m.notesDir, ".gote_config.json")
-	data, err := os.ReadFile(configPath)
+	salt, err := m.loadCrossPlatformSalt()
 	if err != nil {
-		return nil, fmt.Errorf("cross-platform config not found: %v", err)
+		return false
 	}
+	return !bytes.Equal(salt, localSalt)
+}
 
-	var config CrossPlatformConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse cross-platform config: %v", err)
+// loadCrossPlatformSalt loads salt from the notes directory for cross-platform compatibility
+func (m *Manager) loadCrossPlatformSalt() ([]byte, error) {
+	config, err := m.loadCrossPlatformConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	salt, err := base64.StdEncoding.DecodeString(config.Salt)
@@ -369,27 +384,6 @@ func (m *Manager) loadCrossPlatformSalt() ([]byte, error) {
 	return salt, nil
 }
 
-// saveCrossPlatformSalt saves salt to the notes directory for cross-platform compatibility
-func (m *Manager) saveCrossPlatformSalt(salt []byte) error {
-	if m.notesDir == "" {
-		return fmt.Errorf("notes directory not set")
-	}
-
-	config := CrossPlatformConfig{
-		Salt:      base64.StdEncoding.EncodeToString(salt),
-		CreatedAt: time.Now().Format(time.RFC3339),
-		Version:   "1.0",
-	}
-
-	configPath := filepath.Join(m.notesDir, ".gote_config.json")
-	data, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %v", err)
-	}
-
-	return os.WriteFile(configPath, data, 0600)
-}
-
 // SyncFromCrossPlatform creates a local password hash from cross-platform config
 // This is used when setting up Gote on a new device that has access to synced notes
 func (m *Manager) SyncFromCrossPlatform(password string) error {
@@ -439,16 +433,13 @@ func (m *Manager) verifyPasswordWithCrossPlatformData(password string, salt []by
 			}
 
 			// Try to parse as encrypted note
-			var encryptedNote struct {
-				EncryptedData string `json:"encryptedData"`
-			}
-
+			var encryptedNote models.EncryptedNote
 			if err := json.Unmarshal(data, &encryptedNote); err != nil {
 				continue
 			}
 
 			// Attempt to decrypt - if this succeeds, the password is correct
-			_, err = crypto.Decrypt(encryptedNote.EncryptedData, key)
+			_, err = crypto.DecryptBytesWithAAD(encryptedNote.EncryptedData, key, encryptedNote.AssociatedData())
 			if err == nil {
 				// Successfully decrypted at least one note - password is valid
 				return true
@@ -479,12 +470,31 @@ func (m *Manager) verifyPasswordWithCrossPlatformData(password string, salt []by
 
 // createLocalPasswordHashFromCrossPlatform creates a local password hash using the cross-platform salt
 func (m *Manager) createLocalPasswordHashFromCrossPlatform(password string, salt []byte) error {
-	// Create verification hash using the cross-platform salt
-	verificationKey := crypto.DeriveKey(password+"verification", salt)
+	return m.writeLocalPasswordHash(password, salt, nil, nil)
+}
+
+// writeLocalPasswordHash saves a verification hash derived with the given parameters (nil for legacy PBKDF2).
+// A non-nil keyfileHash is mixed into the hash so the password cannot be checked without the keyfile.
+func (m *Manager) writeLocalPasswordHash(password string, salt []byte, params *crypto.KDFParams, keyfileHash []byte) error {
+	var verificationKey []byte
+	if params != nil {
+		key, err := crypto.DeriveKeyWithParams(password+"verification", salt, *params)
+		if err != nil {
+			return fmt.Errorf("failed to derive verification hash: %v", err)
+		}
+		verificationKey = key
+	} else {
+		verificationKey = crypto.DeriveKey(password+"verification", salt)
+	}
+	if keyfileHash != nil {
+		verificationKey = crypto.CombineWithKeyfile(verificationKey, keyfileHash)
+	}
 
 	passwordData := PasswordData{
-		Hash: base64.StdEncoding.EncodeToString(verificationKey),
-		Salt: base64.StdEncoding.EncodeToString(salt),
+		Hash:    base64.StdEncoding.EncodeToString(verificationKey),
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		KDF:     params,
+		Keyfile: keyfileHash != nil,
 	}
 
 	// Ensure password hash directory exists
@@ -499,5 +509,5 @@ func (m *Manager) createLocalPasswordHashFromCrossPlatform(password string, salt
 	}
 
 	// Save local password hash
-	return os.WriteFile(m.passwordHashPath, data, 0600)
+	return utils.WriteFileAtomic(m.passwordHashPath, data, 0600)
 }
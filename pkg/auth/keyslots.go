@@ -0,0 +1,598 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gote/pkg/crypto"
+	"gote/pkg/utils"
+)
+
+const (
+	// KeySlotPassword is the key slot unlocked by the master password
+	KeySlotPassword = "password"
+	// KeySlotRecovery is the key slot unlocked by the printable recovery key
+	KeySlotRecovery = "recovery"
+
+	// configVersionWrapped marks cross-platform configs that store a wrapped master key
+	configVersionWrapped = "2.0"
+)
+
+// KeySlot stores the vault master key wrapped under a key derived from one unlock method.
+// Every slot wraps the same master key, so notes never need to be re-encrypted when a slot changes.
+type KeySlot struct {
+	Method     string            `json:"method"`
+	Salt       string            `json:"salt"`
+	KDF        *crypto.KDFParams `json:"kdf,omitempty"`     // Nil for slots created with legacy PBKDF2
+	Keyfile    bool              `json:"keyfile,omitempty"` // Whether a keyfile hash is mixed into the slot key
+	WrappedKey string            `json:"wrappedKey"`
+}
+
+// KeyRotation holds the key material for re-encrypting the vault under a new master key
+type KeyRotation struct {
+	OldKey      []byte
+	NewKey      []byte
+	KeyConfig   []byte // Cross-platform config to install once the vault has been re-encrypted
+	RecoveryKey string // Replacement recovery key, empty if the vault had none
+}
+
+// newKeySlot wraps the master key under a key derived from secret with a fresh salt
+// and the default key derivation parameters. A non-nil keyfileHash is mixed into the key.
+func newKeySlot(method, secret string, keyfileHash []byte, masterKey []byte) (*KeySlot, error) {
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	params := crypto.DefaultKDFParams()
+	kek, err := crypto.DeriveKeyWithParams(secret, salt, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	if keyfileHash != nil {
+		kek = crypto.CombineWithKeyfile(kek, keyfileHash)
+	}
+
+	wrappedKey, err := crypto.WrapKey(masterKey, kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap master key: %v", err)
+	}
+
+	return &KeySlot{
+		Method:     method,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		KDF:        &params,
+		Keyfile:    keyfileHash != nil,
+		WrappedKey: wrappedKey,
+	}, nil
+}
+
+// kdfParams returns the key derivation parameters of the slot
+func (s *KeySlot) kdfParams() crypto.KDFParams {
+	if s.KDF == nil {
+		return crypto.LegacyKDFParams()
+	}
+	return *s.KDF
+}
+
+// unlock derives the slot key from secret (and keyfile, if the slot needs one) and unwraps the master key with it
+func (s *KeySlot) unlock(secret string, keyfileHash []byte) ([]byte, error) {
+	if s.Keyfile && keyfileHash == nil {
+		return nil, fmt.Errorf("keyfile required")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(s.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %v", err)
+	}
+
+	kek, err := crypto.DeriveKeyWithParams(secret, salt, s.kdfParams())
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	if s.Keyfile {
+		kek = crypto.CombineWithKeyfile(kek, keyfileHash)
+	}
+
+	masterKey, err := crypto.UnwrapKey(s.WrappedKey, kek)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", s.Method)
+	}
+	return masterKey, nil
+}
+
+// slot returns the key slot for the given unlock method, or nil if there is none
+func (c *CrossPlatformConfig) slot(method string) *KeySlot {
+	for i := range c.KeySlots {
+		if c.KeySlots[i].Method == method {
+			return &c.KeySlots[i]
+		}
+	}
+	return nil
+}
+
+// setSlot adds or replaces the key slot for the slot's unlock method
+func (c *CrossPlatformConfig) setSlot(slot *KeySlot) {
+	if existing := c.slot(slot.Method); existing != nil {
+		*existing = *slot
+	} else {
+		c.KeySlots = append(c.KeySlots, *slot)
+	}
+
+	// The top-level salt always belongs to the password so the local password hash stays in sync,
+	// and the keyfile flag tells other devices to ask for the keyfile
+	if slot.Method == KeySlotPassword {
+		c.Salt = slot.Salt
+		c.KeyfileRequired = slot.Keyfile
+	}
+	c.Version = configVersionWrapped
+}
+
+// loadCrossPlatformConfig reads the cross-platform config from the notes directory
+func (m *Manager) loadCrossPlatformConfig() (*CrossPlatformConfig, error) {
+	if m.notesDir == "" {
+		return nil, fmt.Errorf("notes directory not set")
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.notesDir, ".gote_config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("cross-platform config not found: %v", err)
+	}
+
+	var config CrossPlatformConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse cross-platform config: %v", err)
+	}
+	return &config, nil
+}
+
+// saveCrossPlatformConfig replaces the cross-platform config via a temporary file.
+// The config holds the only copy of the wrapped master key, so it must never be left half-written.
+func (m *Manager) saveCrossPlatformConfig(config *CrossPlatformConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	return m.writeCrossPlatformConfig(data)
+}
+
+// writeCrossPlatformConfig atomically writes raw config data to the notes directory
+func (m *Manager) writeCrossPlatformConfig(data []byte) error {
+	if m.notesDir == "" {
+		return fmt.Errorf("notes directory not set")
+	}
+
+	return utils.WriteFileAtomic(filepath.Join(m.notesDir, ".gote_config.json"), data, 0600)
+}
+
+// passwordSlot loads the config and returns its password slot, or nil for legacy vaults
+func (m *Manager) passwordSlot() (*CrossPlatformConfig, *KeySlot) {
+	config, err := m.loadCrossPlatformConfig()
+	if err != nil {
+		return nil, nil
+	}
+	return config, config.slot(KeySlotPassword)
+}
+
+// useSlotSalt remembers the password salt and refreshes the local password hash if it is missing or outdated
+func (m *Manager) useSlotSalt(password string, slot *KeySlot) error {
+	salt, err := base64.StdEncoding.DecodeString(slot.Salt)
+	if err != nil {
+		return fmt.Errorf("failed to decode salt: %v", err)
+	}
+	m.currentSalt = salt
+
+	if data, err := os.ReadFile(m.passwordHashPath); err == nil {
+		var passwordData PasswordData
+		if err := json.Unmarshal(data, &passwordData); err == nil && passwordData.Salt == slot.Salt {
+			return nil
+		}
+	}
+	return m.writeLocalPasswordHash(password, salt, slot.KDF, m.slotKeyfile(slot))
+}
+
+// slotKeyfile returns the supplied keyfile hash if the slot requires a keyfile, nil otherwise.
+// This keeps a keyfile given for a vault that does not need one from being added by accident.
+func (m *Manager) slotKeyfile(slot *KeySlot) []byte {
+	if slot == nil || !slot.Keyfile {
+		return nil
+	}
+	return m.keyfileHash
+}
+
+// SetKeyfile sets the keyfile used for the next unlock. An empty path clears it.
+func (m *Manager) SetKeyfile(path string) error {
+	if path == "" {
+		m.keyfileHash = nil
+		return nil
+	}
+
+	hash, err := crypto.HashKeyfile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyfile: %v", err)
+	}
+	m.keyfileHash = hash
+	return nil
+}
+
+// IsKeyfileRequired reports whether the vault needs a keyfile in addition to the password
+func (m *Manager) IsKeyfileRequired() bool {
+	config, err := m.loadCrossPlatformConfig()
+	return err == nil && config.KeyfileRequired
+}
+
+// createVault generates a random master key protected by the password and writes a new cross-platform config
+func (m *Manager) createVault(password string) error {
+	masterKey, err := crypto.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate master key: %v", err)
+	}
+
+	slot, err := newKeySlot(KeySlotPassword, password, m.keyfileHash, masterKey)
+	if err != nil {
+		return err
+	}
+
+	config := &CrossPlatformConfig{CreatedAt: time.Now().Format(time.RFC3339)}
+	config.setSlot(slot)
+	if err := m.saveCrossPlatformConfig(config); err != nil {
+		return fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+
+	return m.useSlotSalt(password, slot)
+}
+
+// migrateToMasterKey wraps the key of a legacy vault so it becomes the vault master key.
+// The notes stay encrypted with the same key, so nothing needs to be rewritten.
+func (m *Manager) migrateToMasterKey(password string, legacyKey []byte) error {
+	// Never wrap a key derived from a wrong password - it would lock everyone out
+	if !m.VerifyPassword(password) {
+		return fmt.Errorf("password could not be verified")
+	}
+
+	// The shared config is rewritten for every device; keep a copy of the vault as it was
+	if m.backup != nil {
+		backupPath, err := m.backup()
+		if err != nil {
+			return fmt.Errorf("backup failed: %v", err)
+		}
+		log.Printf("Master key migration: backup created at %s", backupPath)
+	}
+
+	config, err := m.loadCrossPlatformConfig()
+	if err != nil {
+		config = &CrossPlatformConfig{CreatedAt: time.Now().Format(time.RFC3339)}
+	}
+
+	// newKeySlot uses a fresh salt, so the key-encryption key always differs from the legacy key
+	slot, err := newKeySlot(KeySlotPassword, password, nil, legacyKey)
+	if err != nil {
+		return err
+	}
+	config.setSlot(slot)
+	config.LegacyMasterKey = true
+
+	if err := m.saveCrossPlatformConfig(config); err != nil {
+		return fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+	return m.useSlotSalt(password, slot)
+}
+
+// ChangePassword rewraps the vault master key under a new password.
+// Only the cross-platform config and the local password hash are rewritten.
+func (m *Manager) ChangePassword(oldPassword, newPassword string) error {
+	if !m.VerifyPassword(oldPassword) {
+		return fmt.Errorf("current password is incorrect")
+	}
+
+	// Legacy vaults are migrated to a wrapped master key here
+	masterKey, err := m.DeriveEncryptionKey(oldPassword)
+	if err != nil {
+		return fmt.Errorf("failed to unlock master key: %v", err)
+	}
+
+	config, slot := m.passwordSlot()
+	if slot == nil {
+		return fmt.Errorf("vault has no wrapped master key")
+	}
+
+	newSlot, err := newKeySlot(KeySlotPassword, newPassword, m.slotKeyfile(slot), masterKey)
+	if err != nil {
+		return err
+	}
+	config.setSlot(newSlot)
+
+	if err := m.saveCrossPlatformConfig(config); err != nil {
+		return fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+	return m.useSlotSalt(newPassword, newSlot)
+}
+
+// PrepareKeyRotation generates a new master key wrapped under the password.
+// Other unlock methods cannot be rewrapped without their secrets and are dropped,
+// except the recovery key, which is replaced by a new one.
+// Nothing is persisted until ApplyKeyRotation is called.
+func (m *Manager) PrepareKeyRotation(password string) (*KeyRotation, error) {
+	if !m.VerifyPassword(password) {
+		return nil, fmt.Errorf("password is incorrect")
+	}
+
+	oldKey, err := m.DeriveEncryptionKey(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock master key: %v", err)
+	}
+
+	config, slot := m.passwordSlot()
+	if slot == nil {
+		return nil, fmt.Errorf("vault has no wrapped master key")
+	}
+
+	newKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %v", err)
+	}
+
+	newSlot, err := newKeySlot(KeySlotPassword, password, m.slotKeyfile(slot), newKey)
+	if err != nil {
+		return nil, err
+	}
+	hadRecovery := config.slot(KeySlotRecovery) != nil
+	config.KeySlots = nil
+	config.LegacyMasterKey = false
+	config.setSlot(newSlot)
+
+	var recoveryKey string
+	if hadRecovery {
+		if recoveryKey, err = addRecoverySlot(config, newKey); err != nil {
+			return nil, err
+		}
+	}
+
+	keyConfig, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	return &KeyRotation{
+		OldKey:      oldKey,
+		NewKey:      newKey,
+		KeyConfig:   keyConfig,
+		RecoveryKey: recoveryKey,
+	}, nil
+}
+
+// ApplyKeyRotation installs the cross-platform config produced by PrepareKeyRotation.
+// The local password hash is refreshed on the next successful login.
+func (m *Manager) ApplyKeyRotation(keyConfig []byte) error {
+	var config CrossPlatformConfig
+	if err := json.Unmarshal(keyConfig, &config); err != nil {
+		return fmt.Errorf("failed to parse key config: %v", err)
+	}
+
+	if err := m.writeCrossPlatformConfig(keyConfig); err != nil {
+		return fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+
+	if salt, err := base64.StdEncoding.DecodeString(config.Salt); err == nil {
+		m.currentSalt = salt
+	}
+	return nil
+}
+
+// KeyDerivationInfo describes how the vault master key is protected
+type KeyDerivationInfo struct {
+	Params           crypto.KDFParams // Parameters of the password slot
+	MasterKeyWrapped bool             // False for legacy vaults that encrypt notes with the password-derived key
+	LegacyMasterKey  bool             // True if the master key was itself derived with PBKDF2 before wrapping
+}
+
+// UpgradeAvailable reports whether UpgradeKeyDerivation would strengthen the vault
+func (info KeyDerivationInfo) UpgradeAvailable() bool {
+	defaults := crypto.DefaultKDFParams()
+	return !info.MasterKeyWrapped || info.LegacyMasterKey ||
+		info.Params.Algorithm != defaults.Algorithm ||
+		info.Params.Memory < defaults.Memory ||
+		info.Params.Time < defaults.Time
+}
+
+// GetKeyDerivationInfo returns the key derivation parameters currently in use
+func (m *Manager) GetKeyDerivationInfo() KeyDerivationInfo {
+	config, slot := m.passwordSlot()
+	if slot == nil {
+		return KeyDerivationInfo{Params: crypto.LegacyKDFParams()}
+	}
+	return KeyDerivationInfo{
+		Params:           slot.kdfParams(),
+		MasterKeyWrapped: true,
+		LegacyMasterKey:  config.LegacyMasterKey,
+	}
+}
+
+// UpgradeKeyDerivation rewraps the master key under a key derived with the default (Argon2id) parameters.
+// A master key that was derived with PBKDF2 stays in use; rotate it to remove that dependency as well.
+func (m *Manager) UpgradeKeyDerivation(password string) error {
+	if !m.VerifyPassword(password) {
+		return fmt.Errorf("password is incorrect")
+	}
+
+	// Legacy vaults are migrated to a wrapped master key here
+	masterKey, err := m.DeriveEncryptionKey(password)
+	if err != nil {
+		return fmt.Errorf("failed to unlock master key: %v", err)
+	}
+
+	config, slot := m.passwordSlot()
+	if slot == nil {
+		return fmt.Errorf("vault has no wrapped master key")
+	}
+
+	newSlot, err := newKeySlot(KeySlotPassword, password, m.slotKeyfile(slot), masterKey)
+	if err != nil {
+		return err
+	}
+	config.setSlot(newSlot)
+
+	if err := m.saveCrossPlatformConfig(config); err != nil {
+		return fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+	return m.useSlotSalt(password, newSlot)
+}
+
+// addRecoverySlot generates a new recovery key and adds the master key wrapped under it to config
+func addRecoverySlot(config *CrossPlatformConfig, masterKey []byte) (string, error) {
+	recoveryKey, err := crypto.GenerateRecoveryKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate recovery key: %v", err)
+	}
+
+	normalized, err := crypto.NormalizeRecoveryKey(recoveryKey)
+	if err != nil {
+		return "", err
+	}
+
+	slot, err := newKeySlot(KeySlotRecovery, normalized, nil, masterKey)
+	if err != nil {
+		return "", err
+	}
+	config.setSlot(slot)
+
+	return recoveryKey, nil
+}
+
+// HasRecoveryKey reports whether the vault can be unlocked with a recovery key
+func (m *Manager) HasRecoveryKey() bool {
+	config, err := m.loadCrossPlatformConfig()
+	return err == nil && config.slot(KeySlotRecovery) != nil
+}
+
+// IsMetadataEncrypted reports whether the vault keeps note metadata inside the ciphertext
+func (m *Manager) IsMetadataEncrypted() bool {
+	config, err := m.loadCrossPlatformConfig()
+	return err == nil && config.EncryptedMetadata
+}
+
+// SetMetadataEncryption records in the vault config whether note metadata should be encrypted
+func (m *Manager) SetMetadataEncryption(enabled bool) error {
+	config, err := m.loadCrossPlatformConfig()
+	if err != nil {
+		return err
+	}
+
+	config.EncryptedMetadata = enabled
+	if err := m.saveCrossPlatformConfig(config); err != nil {
+		return fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+	return nil
+}
+
+// CreateRecoveryKey generates a recovery key that can unlock the vault if the password is lost.
+// Any previous recovery key stops working. The key is only returned here and never stored in plaintext.
+func (m *Manager) CreateRecoveryKey(password string) (string, error) {
+	if !m.VerifyPassword(password) {
+		return "", fmt.Errorf("password is incorrect")
+	}
+
+	// Legacy vaults are migrated to a wrapped master key here
+	masterKey, err := m.DeriveEncryptionKey(password)
+	if err != nil {
+		return "", fmt.Errorf("failed to unlock master key: %v", err)
+	}
+
+	config, slot := m.passwordSlot()
+	if slot == nil {
+		return "", fmt.Errorf("vault has no wrapped master key")
+	}
+
+	recoveryKey, err := addRecoverySlot(config, masterKey)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.saveCrossPlatformConfig(config); err != nil {
+		return "", fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+	return recoveryKey, nil
+}
+
+// RecoverWithKey unlocks the vault with the recovery key and sets a new password.
+// The used recovery key is replaced, so it returns the master key and the new recovery key.
+// The new password only requires a keyfile if one was supplied with SetKeyfile.
+func (m *Manager) RecoverWithKey(recoveryKey, newPassword string) ([]byte, string, error) {
+	normalized, err := crypto.NormalizeRecoveryKey(recoveryKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, err := m.loadCrossPlatformConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	slot := config.slot(KeySlotRecovery)
+	if slot == nil {
+		return nil, "", fmt.Errorf("no recovery key has been set up for this vault")
+	}
+
+	masterKey, err := slot.unlock(normalized, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("recovery key is incorrect")
+	}
+
+	passwordSlot, err := newKeySlot(KeySlotPassword, newPassword, m.keyfileHash, masterKey)
+	if err != nil {
+		return nil, "", err
+	}
+	config.setSlot(passwordSlot)
+
+	newRecoveryKey, err := addRecoverySlot(config, masterKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := m.saveCrossPlatformConfig(config); err != nil {
+		return nil, "", fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+	if err := m.useSlotSalt(newPassword, passwordSlot); err != nil {
+		return nil, "", fmt.Errorf("failed to update local password hash: %v", err)
+	}
+
+	return masterKey, newRecoveryKey, nil
+}
+
+// ChangeKeyfile rewraps the master key under the password and a new keyfile.
+// An empty keyfilePath removes the keyfile requirement.
+func (m *Manager) ChangeKeyfile(password, keyfilePath string) error {
+	if !m.VerifyPassword(password) {
+		return fmt.Errorf("password or keyfile is incorrect")
+	}
+
+	masterKey, err := m.DeriveEncryptionKey(password)
+	if err != nil {
+		return fmt.Errorf("failed to unlock master key: %v", err)
+	}
+
+	config, slot := m.passwordSlot()
+	if slot == nil {
+		return fmt.Errorf("vault has no wrapped master key")
+	}
+
+	if err := m.SetKeyfile(keyfilePath); err != nil {
+		return err
+	}
+
+	newSlot, err := newKeySlot(KeySlotPassword, password, m.keyfileHash, masterKey)
+	if err != nil {
+		return err
+	}
+	config.setSlot(newSlot)
+
+	if err := m.saveCrossPlatformConfig(config); err != nil {
+		return fmt.Errorf("failed to save cross-platform config: %v", err)
+	}
+	return m.useSlotSalt(password, newSlot)
+}
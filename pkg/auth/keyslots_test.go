@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gote/pkg/crypto"
+)
+
+func testMasterKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+	return key
+}
+
+func TestKeySlotUnlock(t *testing.T) {
+	masterKey := testMasterKey(t)
+	keyfileHash := bytes.Repeat([]byte{7}, 32)
+	otherKeyfileHash := bytes.Repeat([]byte{8}, 32)
+
+	passwordSlot, err := newKeySlot(KeySlotPassword, "correct horse", nil, masterKey)
+	if err != nil {
+		t.Fatalf("newKeySlot() error: %v", err)
+	}
+	keyfileSlot, err := newKeySlot(KeySlotPassword, "correct horse", keyfileHash, masterKey)
+	if err != nil {
+		t.Fatalf("newKeySlot() error: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		slot        *KeySlot
+		secret      string
+		keyfileHash []byte
+		wantErr     bool
+	}{
+		{"password", passwordSlot, "correct horse", nil, false},
+		{"wrong password", passwordSlot, "battery staple", nil, true},
+		{"keyfile given but not needed", passwordSlot, "correct horse", keyfileHash, false},
+		{"password and keyfile", keyfileSlot, "correct horse", keyfileHash, false},
+		{"keyfile missing", keyfileSlot, "correct horse", nil, true},
+		{"wrong keyfile", keyfileSlot, "correct horse", otherKeyfileHash, true},
+		{"wrong password with keyfile", keyfileSlot, "battery staple", keyfileHash, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Slots are read back from the cross-platform config, so unlock a decoded copy
+			data, err := json.Marshal(tt.slot)
+			if err != nil {
+				t.Fatalf("Marshal() error: %v", err)
+			}
+			var slot KeySlot
+			if err := json.Unmarshal(data, &slot); err != nil {
+				t.Fatalf("Unmarshal() error: %v", err)
+			}
+
+			got, err := slot.unlock(tt.secret, tt.keyfileHash)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unlock() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unlock() error: %v", err)
+			}
+			if !bytes.Equal(got, masterKey) {
+				t.Errorf("unlock() returned another key than the wrapped master key")
+			}
+		})
+	}
+}
+
+func TestKeySlotUnlockLegacyKDF(t *testing.T) {
+	masterKey := testMasterKey(t)
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatalf("GenerateSalt() error: %v", err)
+	}
+
+	// Slots from before Argon2id have no KDF parameters and use PBKDF2
+	kek := crypto.DeriveKey("old password", salt)
+	wrappedKey, err := crypto.WrapKey(masterKey, kek)
+	if err != nil {
+		t.Fatalf("WrapKey() error: %v", err)
+	}
+	slot := &KeySlot{
+		Method:     KeySlotPassword,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		WrappedKey: wrappedKey,
+	}
+
+	got, err := slot.unlock("old password", nil)
+	if err != nil {
+		t.Fatalf("unlock() error: %v", err)
+	}
+	if !bytes.Equal(got, masterKey) {
+		t.Errorf("unlock() returned another key than the wrapped master key")
+	}
+}
+
+func TestChangePasswordKeepsMasterKey(t *testing.T) {
+	dir := t.TempDir()
+	notesDir := filepath.Join(dir, "notes")
+	if err := os.Mkdir(notesDir, 0755); err != nil {
+		t.Fatalf("Mkdir() error: %v", err)
+	}
+	manager := NewManagerWithNotesDir(filepath.Join(dir, "password.json"), notesDir)
+
+	if err := manager.StorePasswordHash("first password"); err != nil {
+		t.Fatalf("StorePasswordHash() error: %v", err)
+	}
+	before, err := manager.DeriveEncryptionKey("first password")
+	if err != nil {
+		t.Fatalf("DeriveEncryptionKey() error: %v", err)
+	}
+
+	if err := manager.ChangePassword("first password", "second password"); err != nil {
+		t.Fatalf("ChangePassword() error: %v", err)
+	}
+
+	// Another device reads the rewrapped slot from the shared config
+	other := NewManagerWithNotesDir(filepath.Join(dir, "other-password.json"), notesDir)
+	after, err := other.DeriveEncryptionKey("second password")
+	if err != nil {
+		t.Fatalf("DeriveEncryptionKey() after the change error: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("the master key changed with the password")
+	}
+	if _, err := other.DeriveEncryptionKey("first password"); err == nil {
+		t.Errorf("DeriveEncryptionKey() with the old password succeeded")
+	}
+}
+
+func TestKeyDerivationUpgradeAvailable(t *testing.T) {
+	defaults := crypto.DefaultKDFParams()
+	weaker := defaults
+	weaker.Memory /= 2
+
+	tests := []struct {
+		name string
+		info KeyDerivationInfo
+		want bool
+	}{
+		{"current", KeyDerivationInfo{Params: defaults, MasterKeyWrapped: true}, false},
+		{"PBKDF2 slot", KeyDerivationInfo{Params: crypto.LegacyKDFParams(), MasterKeyWrapped: true}, true},
+		{"weaker Argon2id", KeyDerivationInfo{Params: weaker, MasterKeyWrapped: true}, true},
+		{"no wrapped master key", KeyDerivationInfo{Params: defaults}, true},
+		{"master key derived with PBKDF2", KeyDerivationInfo{Params: defaults, MasterKeyWrapped: true, LegacyMasterKey: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.UpgradeAvailable(); got != tt.want {
+				t.Errorf("UpgradeAvailable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeKeyDerivation(t *testing.T) {
+	dir := t.TempDir()
+	notesDir := filepath.Join(dir, "notes")
+	if err := os.Mkdir(notesDir, 0755); err != nil {
+		t.Fatalf("Mkdir() error: %v", err)
+	}
+	manager := NewManagerWithNotesDir(filepath.Join(dir, "password.json"), notesDir)
+
+	if err := manager.StorePasswordHash("password"); err != nil {
+		t.Fatalf("StorePasswordHash() error: %v", err)
+	}
+	masterKey, err := manager.DeriveEncryptionKey("password")
+	if err != nil {
+		t.Fatalf("DeriveEncryptionKey() error: %v", err)
+	}
+
+	// Replace the password slot with one from before Argon2id
+	config, slot := manager.passwordSlot()
+	salt, err := base64.StdEncoding.DecodeString(slot.Salt)
+	if err != nil {
+		t.Fatalf("slot salt is not base64: %v", err)
+	}
+	wrappedKey, err := crypto.WrapKey(masterKey, crypto.DeriveKey("password", salt))
+	if err != nil {
+		t.Fatalf("WrapKey() error: %v", err)
+	}
+	config.setSlot(&KeySlot{Method: KeySlotPassword, Salt: slot.Salt, WrappedKey: wrappedKey})
+	if err := manager.saveCrossPlatformConfig(config); err != nil {
+		t.Fatalf("saveCrossPlatformConfig() error: %v", err)
+	}
+	if info := manager.GetKeyDerivationInfo(); info.Params.Algorithm != crypto.KDFPBKDF2 || !info.UpgradeAvailable() {
+		t.Fatalf("legacy slot reported as %+v", info)
+	}
+
+	if err := manager.UpgradeKeyDerivation("wrong password"); err == nil {
+		t.Errorf("UpgradeKeyDerivation() with a wrong password succeeded")
+	}
+	if err := manager.UpgradeKeyDerivation("password"); err != nil {
+		t.Fatalf("UpgradeKeyDerivation() error: %v", err)
+	}
+	if info := manager.GetKeyDerivationInfo(); info.Params.Algorithm != crypto.KDFArgon2id || info.UpgradeAvailable() {
+		t.Errorf("upgraded slot reported as %+v", info)
+	}
+
+	// Another device unlocks the same master key with the upgraded slot
+	other := NewManagerWithNotesDir(filepath.Join(dir, "other-password.json"), notesDir)
+	after, err := other.DeriveEncryptionKey("password")
+	if err != nil {
+		t.Fatalf("DeriveEncryptionKey() after the upgrade error: %v", err)
+	}
+	if !bytes.Equal(after, masterKey) {
+		t.Errorf("the master key changed with the key derivation")
+	}
+}
@@ -6,12 +6,34 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+
+	"gote/pkg/utils"
 )
 
 // Config holds application configuration
 type Config struct {
 	NotesPath        string `json:"notesPath"`
 	PasswordHashPath string `json:"passwordHashPath"`
+
+	// Note history retention; zero values keep the default count and no age limit
+	HistoryMaxRevisions int `json:"historyMaxRevisions,omitempty"`
+	HistoryMaxAgeDays   int `json:"historyMaxAgeDays,omitempty"`
+
+	// How long deletions are remembered so other devices apply them; zero keeps the default
+	TombstoneRetentionDays int `json:"tombstoneRetentionDays,omitempty"`
+
+	// Find changes in the notes directory by polling only, for network drives that report no file
+	// system events; zero seconds polls at the default interval
+	PollingOnly         bool `json:"pollingOnly,omitempty"`
+	PollIntervalSeconds int  `json:"pollIntervalSeconds,omitempty"`
+
+	// Decrypt note contents only when needed, keeping the given number of notes decrypted;
+	// zero keeps the default cache size
+	LazyLoading      bool `json:"lazyLoading,omitempty"`
+	ContentCacheSize int  `json:"contentCacheSize,omitempty"`
+
+	// Identifies this installation in note versions, to tell concurrent edits on different devices apart
+	DeviceID string `json:"deviceId,omitempty"`
 }
 
 // GetDefaultDataPath returns the default path for storing notes
@@ -117,5 +139,5 @@ func (c *Config) Save() error {
 		return err
 	}
 
-	return os.WriteFile(configFile, data, 0644)
+	return utils.WriteFileAtomic(configFile, data, 0644)
 }
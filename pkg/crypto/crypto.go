@@ -3,12 +3,17 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base32"
 	"encoding/base64"
 	"fmt"
 	"io"
+	"os"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -17,8 +22,57 @@ const (
 	PBKDF2Iterations = 100000 // OWASP recommended minimum
 	KeyLength        = 32     // 256 bits
 	SaltLength       = 32     // 256 bits
+
+	// Argon2id configuration (RFC 9106 second recommended option)
+	Argon2Memory  = 64 * 1024 // 64 MiB, in KiB
+	Argon2Time    = 3
+	Argon2Threads = 4
+
+	// Recovery keys are 160 random bits, shown as 32 base32 characters in groups of four
+	RecoveryKeyLength    = 20
+	recoveryKeyGroupSize = 4
+
+	// Upper bound for Argon2id memory read from a vault, so a tampered config cannot exhaust RAM
+	maxArgon2Memory = 4 * 1024 * 1024 // 4 GiB, in KiB
+
+	// Padding buckets grow in powers of two from the minimum, then in steps of the maximum
+	paddingMinBucket = 512
+	paddingMaxBucket = 1024 * 1024
 )
 
+// Key derivation algorithms
+const (
+	KDFPBKDF2   = "pbkdf2-sha256"
+	KDFArgon2id = "argon2id"
+)
+
+// KDFParams describes how a key is derived from a password
+type KDFParams struct {
+	Algorithm  string `json:"algorithm"`
+	Iterations int    `json:"iterations,omitempty"` // PBKDF2 only
+	Memory     uint32 `json:"memory,omitempty"`     // Argon2id only, in KiB
+	Time       uint32 `json:"time,omitempty"`       // Argon2id only
+	Threads    uint8  `json:"threads,omitempty"`    // Argon2id only
+}
+
+// DefaultKDFParams returns the parameters used for newly derived keys
+func DefaultKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm: KDFArgon2id,
+		Memory:    Argon2Memory,
+		Time:      Argon2Time,
+		Threads:   Argon2Threads,
+	}
+}
+
+// LegacyKDFParams returns the PBKDF2 parameters used by vaults created before Argon2id support
+func LegacyKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm:  KDFPBKDF2,
+		Iterations: PBKDF2Iterations,
+	}
+}
+
 // Encrypt encrypts plaintext using AES-GCM with the provided key
 func Encrypt(plaintext string, key []byte) (string, error) {
 	block, err := aes.NewCipher(key)
@@ -73,6 +127,17 @@ func Decrypt(ciphertext string, key []byte) (string, error) {
 
 // EncryptBytes encrypts raw bytes using AES-GCM with the provided key
 func EncryptBytes(data []byte, key []byte) (string, error) {
+	return EncryptBytesWithAAD(data, key, nil)
+}
+
+// DecryptBytes decrypts ciphertext and returns raw bytes using AES-GCM with the provided key
+func DecryptBytes(ciphertext string, key []byte) ([]byte, error) {
+	return DecryptBytesWithAAD(ciphertext, key, nil)
+}
+
+// EncryptBytesWithAAD encrypts raw bytes using AES-GCM and authenticates the additional data with them.
+// The additional data is not stored; the same bytes must be passed to DecryptBytesWithAAD.
+func EncryptBytesWithAAD(data []byte, key []byte, additionalData []byte) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -88,12 +153,13 @@ func EncryptBytes(data []byte, key []byte) (string, error) {
 		return "", err
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
+	ciphertext := gcm.Seal(nonce, nonce, data, additionalData)
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// DecryptBytes decrypts ciphertext and returns raw bytes using AES-GCM with the provided key
-func DecryptBytes(ciphertext string, key []byte) ([]byte, error) {
+// DecryptBytesWithAAD decrypts ciphertext produced by EncryptBytesWithAAD.
+// It fails if either the ciphertext or the additional data has been changed.
+func DecryptBytesWithAAD(ciphertext string, key []byte, additionalData []byte) ([]byte, error) {
 	data, err := base64.StdEncoding.DecodeString(ciphertext)
 	if err != nil {
 		return nil, err
@@ -115,7 +181,7 @@ func DecryptBytes(ciphertext string, key []byte) ([]byte, error) {
 	}
 
 	nonce, ciphertext_bytes := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext_bytes, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext_bytes, additionalData)
 	if err != nil {
 		return nil, err
 	}
@@ -123,11 +189,62 @@ func DecryptBytes(ciphertext string, key []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-// DeriveKey derives an encryption key from a password using PBKDF2
+// PadToBucket pads data up to the next size bucket so the ciphertext length only reveals the bucket.
+// It uses ISO/IEC 7816-4 padding (a 0x80 byte followed by zeros), which Unpad removes.
+func PadToBucket(data []byte) []byte {
+	needed := len(data) + 1
+	size := paddingMinBucket
+	for size < needed && size < paddingMaxBucket {
+		size *= 2
+	}
+	if size < needed {
+		size = (needed + paddingMaxBucket - 1) / paddingMaxBucket * paddingMaxBucket
+	}
+
+	padded := make([]byte, size)
+	copy(padded, data)
+	padded[len(data)] = 0x80
+	return padded
+}
+
+// Unpad removes padding added by PadToBucket
+func Unpad(data []byte) ([]byte, error) {
+	end := len(data) - 1
+	for end >= 0 && data[end] == 0 {
+		end--
+	}
+	if end < 0 || data[end] != 0x80 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return data[:end], nil
+}
+
+// DeriveKey derives an encryption key from a password using PBKDF2 (legacy parameters)
 func DeriveKey(password string, salt []byte) []byte {
 	return pbkdf2.Key([]byte(password), salt, PBKDF2Iterations, KeyLength, sha256.New)
 }
 
+// DeriveKeyWithParams derives an encryption key from a password using the given parameters
+func DeriveKeyWithParams(password string, salt []byte, params KDFParams) ([]byte, error) {
+	switch params.Algorithm {
+	case KDFPBKDF2:
+		if params.Iterations <= 0 {
+			return nil, fmt.Errorf("invalid PBKDF2 iterations: %d", params.Iterations)
+		}
+		return pbkdf2.Key([]byte(password), salt, params.Iterations, KeyLength, sha256.New), nil
+	case KDFArgon2id:
+		if params.Memory == 0 || params.Time == 0 || params.Threads == 0 {
+			return nil, fmt.Errorf("invalid Argon2id parameters")
+		}
+		if params.Memory > maxArgon2Memory {
+			return nil, fmt.Errorf("memory of %d KiB exceeds the Argon2id limit", params.Memory)
+		}
+		return argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, KeyLength), nil
+	default:
+		return nil, fmt.Errorf("unsupported key derivation algorithm: %s", params.Algorithm)
+	}
+}
+
 // GenerateSalt generates a random salt for key derivation
 func GenerateSalt() ([]byte, error) {
 	salt := make([]byte, SaltLength)
@@ -136,3 +253,83 @@ func GenerateSalt() ([]byte, error) {
 	}
 	return salt, nil
 }
+
+// GenerateKey generates a random key suitable for AES-256
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a key with a key-encryption key so it can be stored alongside the vault
+func WrapKey(key []byte, kek []byte) (string, error) {
+	return EncryptBytes(key, kek)
+}
+
+// UnwrapKey decrypts a key wrapped with WrapKey. It fails if the key-encryption key is wrong.
+func UnwrapKey(wrappedKey string, kek []byte) ([]byte, error) {
+	key, err := DecryptBytes(wrappedKey, kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeyLength {
+		return nil, fmt.Errorf("unwrapped key has invalid length %d", len(key))
+	}
+	return key, nil
+}
+
+// GenerateRecoveryKey generates a random, printable recovery key such as "ABCD-EFGH-..."
+func GenerateRecoveryKey() (string, error) {
+	raw := make([]byte, RecoveryKeyLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	groups := make([]string, 0, len(encoded)/recoveryKeyGroupSize)
+	for i := 0; i < len(encoded); i += recoveryKeyGroupSize {
+		groups = append(groups, encoded[i:i+recoveryKeyGroupSize])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// NormalizeRecoveryKey converts user input into the canonical recovery key form,
+// ignoring case, spaces and dashes
+func NormalizeRecoveryKey(input string) (string, error) {
+	cleaned := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(input)))
+
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(cleaned)
+	if err != nil || len(raw) != RecoveryKeyLength {
+		return "", fmt.Errorf("invalid recovery key format")
+	}
+	return cleaned, nil
+}
+
+// HashKeyfile returns the SHA-256 hash of a keyfile's contents.
+// Any file can serve as a keyfile, but it must not be modified afterwards.
+func HashKeyfile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("keyfile is empty")
+	}
+	return hash.Sum(nil), nil
+}
+
+// CombineWithKeyfile mixes a keyfile hash into a derived key, so both are needed to reproduce the result
+func CombineWithKeyfile(key []byte, keyfileHash []byte) []byte {
+	mac := hmac.New(sha256.New, keyfileHash)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeriveKeyWithParams(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, SaltLength)
+	otherSalt := bytes.Repeat([]byte{2}, SaltLength)
+	// Small Argon2id parameters keep the test fast; the algorithm is the same
+	params := KDFParams{Algorithm: KDFArgon2id, Memory: 1024, Time: 1, Threads: 1}
+
+	legacy, err := DeriveKeyWithParams("password", salt, LegacyKDFParams())
+	if err != nil {
+		t.Fatalf("DeriveKeyWithParams() with legacy parameters error: %v", err)
+	}
+	if !bytes.Equal(legacy, DeriveKey("password", salt)) {
+		t.Errorf("legacy parameters derive another key than DeriveKey")
+	}
+
+	key, err := DeriveKeyWithParams("password", salt, params)
+	if err != nil {
+		t.Fatalf("DeriveKeyWithParams() error: %v", err)
+	}
+	if len(key) != KeyLength {
+		t.Errorf("derived key has %d bytes, want %d", len(key), KeyLength)
+	}
+	if bytes.Equal(key, legacy) {
+		t.Errorf("Argon2id derives the same key as PBKDF2")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		salt     []byte
+		params   KDFParams
+		same     bool
+	}{
+		{"same input", "password", salt, params, true},
+		{"other password", "Password", salt, params, false},
+		{"other salt", "password", otherSalt, params, false},
+		{"more passes", "password", salt, KDFParams{Algorithm: KDFArgon2id, Memory: 1024, Time: 2, Threads: 1}, false},
+		{"more memory", "password", salt, KDFParams{Algorithm: KDFArgon2id, Memory: 2048, Time: 1, Threads: 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DeriveKeyWithParams(tt.password, tt.salt, tt.params)
+			if err != nil {
+				t.Fatalf("DeriveKeyWithParams() error: %v", err)
+			}
+			if bytes.Equal(got, key) != tt.same {
+				t.Errorf("DeriveKeyWithParams() same key = %v, want %v", !tt.same, tt.same)
+			}
+		})
+	}
+}
+
+func TestDeriveKeyWithParamsRejects(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, SaltLength)
+
+	tests := []struct {
+		name   string
+		params KDFParams
+	}{
+		{"unknown algorithm", KDFParams{Algorithm: "scrypt"}},
+		{"no algorithm", KDFParams{}},
+		{"PBKDF2 without iterations", KDFParams{Algorithm: KDFPBKDF2}},
+		{"Argon2id without memory", KDFParams{Algorithm: KDFArgon2id, Time: 1, Threads: 1}},
+		{"Argon2id without passes", KDFParams{Algorithm: KDFArgon2id, Memory: 1024, Threads: 1}},
+		{"Argon2id without threads", KDFParams{Algorithm: KDFArgon2id, Memory: 1024, Time: 1}},
+		{"Argon2id memory over the limit", KDFParams{Algorithm: KDFArgon2id, Memory: maxArgon2Memory + 1, Time: 1, Threads: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DeriveKeyWithParams("password", salt, tt.params); err == nil {
+				t.Errorf("DeriveKeyWithParams() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Category is a user-defined group of notes; notes refer to it by ID
+type Category struct {
+	ID        NoteCategory `json:"id"`
+	Name      string       `json:"name"`
+	Color     string       `json:"color,omitempty"` // CSS colour, e.g. "#4a90d9"
+	SortOrder int          `json:"sort_order"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// DefaultCategories returns the categories of a vault that has none yet. They keep the IDs
+// of the categories that were built in before categories could be defined, so existing
+// notes stay in them.
+func DefaultCategories() []*Category {
+	now := time.Now()
+	return []*Category{
+		{ID: CategoryWork, Name: "Work", SortOrder: 0, UpdatedAt: now},
+		{ID: CategoryPrivate, Name: "Private", SortOrder: 1, UpdatedAt: now},
+	}
+}
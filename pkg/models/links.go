@@ -0,0 +1,94 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// wikiLinkPattern matches a [[target]] or [[target|label]] link to another note
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|\n]+)(\|[^\[\]\n]*)?\]\]`)
+
+// UnresolvedLink is a link target that matches no note, with the notes that link to it
+type UnresolvedLink struct {
+	Target  string   `json:"target"`
+	NoteIDs []string `json:"note_ids"`
+}
+
+// GraphNode is a note in the note graph
+type GraphNode struct {
+	ID       string       `json:"id"`
+	Title    string       `json:"title"`
+	Category NoteCategory `json:"category"`
+}
+
+// GraphEdge is a link from one note to another
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// NoteGraph is the notes outside the trash and the links between them
+type NoteGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// NormalizeLinkTarget returns the form in which link targets, note IDs and titles are
+// compared: lower case, with runs of white space as one space
+func NormalizeLinkTarget(target string) string {
+	return strings.ToLower(strings.Join(strings.Fields(target), " "))
+}
+
+// RewriteWikiLinks calls rewrite for every [[link]] in content, outside of code, and
+// replaces the targets for which it returns true. rewrite receives the target as written;
+// a label after '|' is kept.
+func RewriteWikiLinks(content string, rewrite func(target string) (string, bool)) string {
+	return rewriteOutsideCode(content, func(text string) string {
+		return wikiLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+			match := wikiLinkPattern.FindStringSubmatch(link)
+			target := strings.TrimSpace(match[1])
+			if target == "" {
+				return link
+			}
+			replacement, replace := rewrite(target)
+			if !replace {
+				return link
+			}
+			return "[[" + replacement + match[2] + "]]"
+		})
+	})
+}
+
+// ParseWikiLinks returns the normalized targets of the [[links]] in content, sorted and
+// without duplicates
+func ParseWikiLinks(content string) []string {
+	seen := make(map[string]bool)
+	var targets []string
+	RewriteWikiLinks(content, func(target string) (string, bool) {
+		normalized := NormalizeLinkTarget(target)
+		if !seen[normalized] {
+			seen[normalized] = true
+			targets = append(targets, normalized)
+		}
+		return "", false
+	})
+	sort.Strings(targets)
+	return targets
+}
+
+// LinkTargets returns the normalized targets of the [[links]] in a note
+func (n *Note) LinkTargets() []string {
+	if n.Partial {
+		return n.Links
+	}
+	return ParseWikiLinks(n.Content)
+}
+
+// DisplayTitle returns the title of a note, also when only its metadata is loaded
+func (n *Note) DisplayTitle() string {
+	if n.Partial {
+		return n.Title
+	}
+	return NoteTitle(n.Content)
+}
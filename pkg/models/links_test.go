@@ -0,0 +1,57 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteWikiLinks(t *testing.T) {
+	rename := func(target string) (string, bool) {
+		return "New Title", NormalizeLinkTarget(target) == "old title"
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"link", "see [[Old Title]]", "see [[New Title]]"},
+		{"other case and spaces", "see [[ old   TITLE ]]", "see [[New Title]]"},
+		{"label kept", "see [[Old Title|the old one]]", "see [[New Title|the old one]]"},
+		{"other links kept", "[[Other]] and [[Old Title]]", "[[Other]] and [[New Title]]"},
+		{"empty link", "[[ ]] and [[|label]]", "[[ ]] and [[|label]]"},
+		{"across lines", "[[Old\nTitle]]", "[[Old\nTitle]]"},
+		{"inline code", "`[[Old Title]]` [[Old Title]]", "`[[Old Title]]` [[New Title]]"},
+		{"code block", "```\n[[Old Title]]\n```", "```\n[[Old Title]]\n```"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RewriteWikiLinks(tt.content, rename); got != tt.want {
+				t.Errorf("RewriteWikiLinks(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWikiLinks(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"no links", nil},
+		{"[[B]] [[a]] [[ b ]]", []string{"a", "b"}},
+		{"[[Two  Words|label]]", []string{"two words"}},
+		{"[[1a2b3c4d]]", []string{"1a2b3c4d"}},
+		{"`[[code]]` [[real]]", []string{"real"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			got := ParseWikiLinks(tt.content)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("ParseWikiLinks(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// imageReferencePattern matches an embedded image, ![alt](image:id)
+var imageReferencePattern = regexp.MustCompile(`!\[[^\]]*\]\(image:([^)]+)\)`)
+
+// codeFence starts and ends a Markdown code block, in which #tags and [[links]] are not parsed
+const codeFence = "```"
+
+// rewriteOutsideCode applies rewrite to the parts of content that are not code: neither in
+// a fenced code block nor between backticks
+func rewriteOutsideCode(content string, rewrite func(text string) string) string {
+	lines := strings.Split(content, "\n")
+	inFence := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), codeFence) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		// Every other part between backticks is inline code
+		parts := strings.Split(line, "`")
+		for j := 0; j < len(parts); j += 2 {
+			parts[j] = rewrite(parts[j])
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseImageReferences returns the IDs of the images embedded in content, in order and
+// once per reference
+func ParseImageReferences(content string) []string {
+	var ids []string
+	for _, match := range imageReferencePattern.FindAllStringSubmatch(content, -1) {
+		ids = append(ids, match[1])
+	}
+	return ids
+}
+
+// ImageReferences returns the IDs of the images a note shows, sorted and without duplicates
+func (n *Note) ImageReferences() []string {
+	if n.Partial {
+		return n.ImageRefs
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range ParseImageReferences(n.Content) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -1,14 +1,18 @@
 package models
 
-import "time"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
-// NoteCategory represents the category of a note
+// NoteCategory is the ID of the category of a note
 type NoteCategory string
 
 const (
-	CategoryPrivate NoteCategory = "private"
-	CategoryWork    NoteCategory = "work"
-	CategoryTrash   NoteCategory = "trash"
+	CategoryPrivate NoteCategory = "private" // Default category, see DefaultCategories
+	CategoryWork    NoteCategory = "work"    // Default category, see DefaultCategories
+	CategoryTrash   NoteCategory = "trash"   // Reserved for deleted notes; not a user-defined category
 )
 
 // Note represents a decrypted note in memory
@@ -20,6 +24,40 @@ type Note struct {
 	Images           []Image      `json:"images,omitempty"`
 	CreatedAt        time.Time    `json:"created_at"`
 	UpdatedAt        time.Time    `json:"updated_at"`
+
+	Versions    VersionVector `json:"versions,omitempty"`     // Saves per device, used to detect sync conflicts
+	ConflictOf  string        `json:"conflict_of,omitempty"`  // ID of the note this is a conflict copy of
+	NeedsReview bool          `json:"needs_review,omitempty"` // Set when a merge left conflict markers in the content
+	Tags        []string      `json:"tags,omitempty"`         // Tags given to the note; #tags in the content come on top, see AllTags
+	Notebook    string        `json:"notebook,omitempty"`     // ID of the notebook the note is in, empty for none
+	TrashedWith string        `json:"trashed_with,omitempty"` // ID of the notebook whose move to trash took the note along
+
+	// Partial notes carry only metadata; their content is decrypted on demand (lazy loading).
+	// Title stands in for the content of such notes, InlineTags for its #tags, Links for
+	// the targets of its [[links]] and ImageRefs for the images it shows.
+	Partial    bool     `json:"partial,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	InlineTags []string `json:"inline_tags,omitempty"`
+	Links      []string `json:"links,omitempty"`
+	ImageRefs  []string `json:"image_refs,omitempty"`
+}
+
+// Longest title kept for notes whose content is not loaded
+const maxTitleLength = 120
+
+// NoteTitle returns the first non-empty line of a note without Markdown heading marks
+func NoteTitle(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+		if line == "" {
+			continue
+		}
+		if runes := []rune(line); len(runes) > maxTitleLength {
+			line = string(runes[:maxTitleLength])
+		}
+		return line
+	}
+	return ""
 }
 
 // Image represents an embedded image in a note
@@ -31,10 +69,49 @@ type Image struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// On-disk note formats
+const (
+	NoteFormatV1 = 1 // Metadata stored next to the ciphertext without authentication (files without a format_version)
+	NoteFormatV2 = 2 // Note ID and timestamps authenticated as AES-GCM additional data
+	NoteFormatV3 = 3 // All metadata inside the padded ciphertext; only the version and ID are in plaintext
+
+	LatestNoteFormat = NoteFormatV3 // Newest format this build can read
+)
+
 // EncryptedNote represents an encrypted note for storage
 type EncryptedNote struct {
+	FormatVersion int       `json:"format_version,omitempty"`
 	ID            string    `json:"id"`
 	EncryptedData string    `json:"encrypted_data"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
+
+// Version returns the on-disk format of the note, treating files without a version as V1
+func (n *EncryptedNote) Version() int {
+	if n.FormatVersion == 0 {
+		return NoteFormatV1
+	}
+	return n.FormatVersion
+}
+
+// AssociatedData returns the metadata that is authenticated together with the ciphertext,
+// or nil for formats that do not authenticate metadata
+func (n *EncryptedNote) AssociatedData() []byte {
+	if n.Version() < NoteFormatV2 {
+		return nil
+	}
+	if n.Version() >= NoteFormatV3 {
+		// Timestamps are encrypted, so only the ID needs binding to the file
+		return []byte(strings.Join([]string{"gote-note", strconv.Itoa(n.Version()), n.ID}, "\x00"))
+	}
+
+	// Timestamps are normalised to UTC so the bytes survive a JSON round trip in any time zone
+	return []byte(strings.Join([]string{
+		"gote-note",
+		strconv.Itoa(n.Version()),
+		n.ID,
+		n.CreatedAt.UTC().Format(time.RFC3339Nano),
+		n.UpdatedAt.UTC().Format(time.RFC3339Nano),
+	}, "\x00"))
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Notebook is a user-defined folder of notes. Notebooks nest through their parent;
+// notes refer to their notebook by ID.
+type Notebook struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  string    `json:"parent_id,omitempty"` // Empty for top-level notebooks
+	SortOrder int       `json:"sort_order"`
+	Trashed   bool      `json:"trashed,omitempty"` // Set when moved to trash; the notebooks below it are in trash with it
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
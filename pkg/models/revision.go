@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Revision describes a stored earlier version of a note
+type Revision struct {
+	ID        string    `json:"id"`
+	NoteID    string    `json:"note_id"`
+	SavedAt   time.Time `json:"saved_at"`   // When the version was replaced
+	UpdatedAt time.Time `json:"updated_at"` // Last edit contained in the version
+	Size      int       `json:"size"`       // Content length in bytes
+
+	SyncConflict bool `json:"sync_conflict,omitempty"` // A conflicting copy left by a sync client rather than a saved revision
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// hashtagPattern matches an inline #tag that is not part of a word, URL or HTML entity
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/#])#([\p{L}\p{N}_][\p{L}\p{N}_/-]*)`)
+
+	tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_][\p{L}\p{N}_/-]*$`)
+	numberTag  = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// TagCount is a tag together with the number of notes that have it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTag returns the canonical form of a tag: lower case, without a leading '#'.
+// ok is false for strings that cannot be tags, such as "#42" or "two words".
+func NormalizeTag(tag string) (normalized string, ok bool) {
+	tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	tag = strings.TrimRight(tag, "/-")
+	if !tagPattern.MatchString(tag) || numberTag.MatchString(tag) {
+		return "", false
+	}
+	return tag, true
+}
+
+// RewriteHashtags calls rewrite for every inline #tag in content, outside of code, and
+// replaces the tags for which it returns true. rewrite receives the normalized tag and
+// returns the text that replaces it after the '#'.
+func RewriteHashtags(content string, rewrite func(tag string) (string, bool)) string {
+	return rewriteOutsideCode(content, func(text string) string {
+		return rewriteHashtagsInText(text, rewrite)
+	})
+}
+
+func rewriteHashtagsInText(text string, rewrite func(tag string) (string, bool)) string {
+	matches := hashtagPattern.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+
+	var result strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[2], match[3]
+		tag, ok := NormalizeTag(text[start:end])
+		if !ok {
+			continue
+		}
+		// Trailing '/' and '-' are not part of the tag and stay in place
+		end = start + len(strings.TrimRight(text[start:end], "/-"))
+		replacement, replace := rewrite(tag)
+		if !replace {
+			continue
+		}
+		result.WriteString(text[last:start])
+		result.WriteString(replacement)
+		last = end
+	}
+	result.WriteString(text[last:])
+	return result.String()
+}
+
+// ParseHashtags returns the normalized inline #tags of content, sorted and without duplicates
+func ParseHashtags(content string) []string {
+	var tags []string
+	RewriteHashtags(content, func(tag string) (string, bool) {
+		tags = append(tags, tag)
+		return "", false
+	})
+	return UniqueTags(tags)
+}
+
+// AllTags returns the tags of a note: its own tags and the #tags in its content
+func (n *Note) AllTags() []string {
+	tags := append([]string(nil), n.Tags...)
+	tags = append(tags, n.InlineTags...)
+	tags = append(tags, ParseHashtags(n.Content)...)
+	return UniqueTags(tags)
+}
+
+// UniqueTags normalizes tags, drops invalid ones and duplicates, and sorts them
+func UniqueTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	var unique []string
+	for _, tag := range tags {
+		normalized, ok := NormalizeTag(tag)
+		if !ok || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		unique = append(unique, normalized)
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		tag    string
+		want   string
+		wantOK bool
+	}{
+		{"Idea", "idea", true},
+		{"#Idea", "idea", true},
+		{"  project/gote  ", "project/gote", true},
+		{"trailing/", "trailing", true},
+		{"to-do-", "to-do", true},
+		{"café", "café", true},
+		{"2024", "", false},
+		{"v2", "v2", true},
+		{"two words", "", false},
+		{"#", "", false},
+		{"", "", false},
+		{"-dash", "", false},
+		{"/slash", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			got, ok := NormalizeTag(tt.tag)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("NormalizeTag(%q) = %q, %v; want %q, %v", tt.tag, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRewriteHashtags(t *testing.T) {
+	rename := func(from, to string) func(tag string) (string, bool) {
+		return func(tag string) (string, bool) {
+			return to, tag == from
+		}
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"start of content", "#old note", "#new note"},
+		{"after a word", "a #old note", "a #new note"},
+		{"any case", "a #OLD note", "a #new note"},
+		{"several", "#old and #old", "#new and #new"},
+		{"other tags kept", "#older #old #old/sub", "#older #new #old/sub"},
+		{"trailing punctuation", "see #old, #old. #old/", "see #new, #new. #new/"},
+		{"in a word", "a#old", "a#old"},
+		{"in a URL", "https://example.com/#old", "https://example.com/#old"},
+		{"HTML entity", "&#old;", "&#old;"},
+		{"heading", "# old", "# old"},
+		{"inline code", "`#old` and #old", "`#old` and #new"},
+		{"code block", "#old\n```\n#old\n```\n#old", "#new\n```\n#old\n```\n#new"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RewriteHashtags(tt.content, rename("old", "new")); got != tt.want {
+				t.Errorf("RewriteHashtags(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHashtags(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"no tags", nil},
+		{"#b #a #B", []string{"a", "b"}},
+		{"#42 is not a tag, #v2 is", []string{"v2"}},
+		{"`#code` #real", []string{"real"}},
+		{"#project/gote/", []string{"project/gote"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			got := ParseHashtags(tt.content)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("ParseHashtags(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
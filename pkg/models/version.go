@@ -0,0 +1,63 @@
+package models
+
+// VersionVector counts the saves of a note per device. Comparing two vectors tells
+// whether one version was derived from the other or both were edited independently.
+type VersionVector map[string]uint64
+
+// Version orderings returned by VersionVector.Compare
+const (
+	VersionEqual      = iota // Same version
+	VersionBefore            // The other version was derived from this one
+	VersionAfter             // This version was derived from the other one
+	VersionConcurrent        // Both were changed independently
+)
+
+// Compare reports how this version relates to other
+func (v VersionVector) Compare(other VersionVector) int {
+	before, after := false, false
+	for device, count := range v {
+		if count > other[device] {
+			after = true
+		}
+	}
+	for device, count := range other {
+		if count > v[device] {
+			before = true
+		}
+	}
+
+	switch {
+	case before && after:
+		return VersionConcurrent
+	case before:
+		return VersionBefore
+	case after:
+		return VersionAfter
+	default:
+		return VersionEqual
+	}
+}
+
+// Increment returns a copy of the vector with the count of device raised by one
+func (v VersionVector) Increment(device string) VersionVector {
+	next := make(VersionVector, len(v)+1)
+	for d, count := range v {
+		next[d] = count
+	}
+	next[device]++
+	return next
+}
+
+// Merge returns a vector that has seen both versions
+func (v VersionVector) Merge(other VersionVector) VersionVector {
+	merged := make(VersionVector, len(v)+len(other))
+	for d, count := range v {
+		merged[d] = count
+	}
+	for d, count := range other {
+		if count > merged[d] {
+			merged[d] = count
+		}
+	}
+	return merged
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"gote/pkg/models"
 	"gote/pkg/storage"
+	"gote/pkg/utils"
 	"strings"
 )
 
@@ -24,11 +25,32 @@ func (s *NoteService) LoadNotes(key []byte) error {
 	return s.store.LoadNotes(key)
 }
 
+// LoadNotesWithProgress loads notes and reports how many have been decrypted so far
+func (s *NoteService) LoadNotesWithProgress(key []byte, progress func(loaded, total int)) error {
+	return s.store.LoadNotesWithProgress(key, progress)
+}
+
 // GetAllNotes returns all notes
 func (s *NoteService) GetAllNotes() []*models.Note {
 	return s.store.GetAllNotes()
 }
 
+// GetAllNotesWithContent returns all notes including their contents, which in lazy
+// mode means decrypting every note that is not cached
+func (s *NoteService) GetAllNotesWithContent() []*models.Note {
+	return s.store.GetAllNotesWithContent()
+}
+
+// NotesShowingImage returns the IDs of the notes that embed an image
+func (s *NoteService) NotesShowingImage(imageID string) []string {
+	return s.store.NotesShowingImage(imageID)
+}
+
+// ReferencedImages returns the IDs of all images embedded in a note
+func (s *NoteService) ReferencedImages() map[string]bool {
+	return s.store.ReferencedImages()
+}
+
 // GetNote returns a specific note by ID
 func (s *NoteService) GetNote(id string) (*models.Note, error) {
 	if strings.TrimSpace(id) == "" {
@@ -54,6 +76,11 @@ func (s *NoteService) CreateNoteWithCategory(content string, category models.Not
 		return nil, fmt.Errorf("authentication required")
 	}
 
+	// Notes created for a category that does not exist go to the default one
+	if category == "" || !s.store.IsValidCategory(category) {
+		category = s.store.DefaultCategory()
+	}
+
 	// Allow empty content for new notes - users can fill them in later
 	return s.store.CreateNoteWithCategory(content, category, key)
 }
@@ -85,6 +112,10 @@ func (s *NoteService) UpdateNoteCategory(id string, category models.NoteCategory
 		return nil, fmt.Errorf("note ID cannot be empty")
 	}
 
+	if !s.store.IsValidCategory(category) {
+		return nil, fmt.Errorf("invalid category: %s", category)
+	}
+
 	return s.store.UpdateNoteCategory(id, category, key)
 }
 
@@ -119,6 +150,196 @@ func (s *NoteService) GetNotesByCategory(category models.NoteCategory) []*models
 	return s.store.GetNotesByCategory(category)
 }
 
+// ListCategories returns the user-defined categories in their sort order
+func (s *NoteService) ListCategories() ([]*models.Category, error) {
+	return s.store.ListCategories()
+}
+
+// CreateCategory adds a category
+func (s *NoteService) CreateCategory(name, color string) (*models.Category, error) {
+	return s.store.CreateCategory(name, color)
+}
+
+// UpdateCategory renames a category and sets its colour
+func (s *NoteService) UpdateCategory(id models.NoteCategory, name, color string) (*models.Category, error) {
+	if strings.TrimSpace(string(id)) == "" {
+		return nil, fmt.Errorf("category ID cannot be empty")
+	}
+
+	return s.store.UpdateCategory(id, name, color)
+}
+
+// SetCategoryOrder sorts the categories in the given order
+func (s *NoteService) SetCategoryOrder(ids []models.NoteCategory) error {
+	return s.store.SetCategoryOrder(ids)
+}
+
+// DeleteCategory deletes a category after moving its notes to another one
+func (s *NoteService) DeleteCategory(id, reassignTo models.NoteCategory, key []byte) (int, error) {
+	if key == nil {
+		return 0, fmt.Errorf("authentication required")
+	}
+
+	if id == models.CategoryTrash {
+		return 0, fmt.Errorf("the trash cannot be deleted")
+	}
+
+	return s.store.DeleteCategory(id, reassignTo, key)
+}
+
+// ListTags returns all tags with the number of notes that have them
+func (s *NoteService) ListTags() []models.TagCount {
+	return s.store.ListTags()
+}
+
+// GetNotesByTags returns the notes with any, or all, of the tags
+func (s *NoteService) GetNotesByTags(tags []string, matchAll bool) []*models.Note {
+	return s.store.GetNotesByTags(tags, matchAll)
+}
+
+// SetNoteTags replaces the tags of a note
+func (s *NoteService) SetNoteTags(id string, tags []string, key []byte) (*models.Note, error) {
+	if key == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+
+	if strings.TrimSpace(id) == "" {
+		return nil, fmt.Errorf("note ID cannot be empty")
+	}
+
+	return s.store.SetNoteTags(id, tags, key)
+}
+
+// RenameTag renames a tag in all notes, merging it into the new tag if that exists
+func (s *NoteService) RenameTag(from, to string, key []byte) (int, error) {
+	if key == nil {
+		return 0, fmt.Errorf("authentication required")
+	}
+
+	return s.store.RenameTag(from, to, key)
+}
+
+// MergeTags replaces several tags by one in all notes
+func (s *NoteService) MergeTags(from []string, to string, key []byte) (int, error) {
+	if key == nil {
+		return 0, fmt.Errorf("authentication required")
+	}
+
+	return s.store.MergeTags(from, to, key)
+}
+
+// GetBacklinks returns the notes that link to a note
+func (s *NoteService) GetBacklinks(id string) ([]*models.Note, error) {
+	return s.store.GetBacklinks(id)
+}
+
+// GetNotesLinkingTo returns the notes with a [[link]] to target
+func (s *NoteService) GetNotesLinkingTo(target string) []*models.Note {
+	return s.store.GetNotesLinkingTo(target)
+}
+
+// GetUnresolvedLinks returns the link targets that match no note
+func (s *NoteService) GetUnresolvedLinks() []models.UnresolvedLink {
+	return s.store.GetUnresolvedLinks()
+}
+
+// GetNoteGraph returns the notes and the links between them
+func (s *NoteService) GetNoteGraph() models.NoteGraph {
+	return s.store.GetNoteGraph()
+}
+
+// RenameLinks points all [[from]] links to another target
+func (s *NoteService) RenameLinks(from, to string, key []byte) (int, error) {
+	if key == nil {
+		return 0, fmt.Errorf("authentication required")
+	}
+
+	return s.store.RenameLinks(from, to, key)
+}
+
+// ListNotebooks returns the notebooks outside the trash, parents before their children
+func (s *NoteService) ListNotebooks() ([]*models.Notebook, error) {
+	return s.store.ListNotebooks()
+}
+
+// ListTrashedNotebooks returns the notebooks that were moved to trash
+func (s *NoteService) ListTrashedNotebooks() ([]*models.Notebook, error) {
+	return s.store.ListTrashedNotebooks()
+}
+
+// GetNotebookSubtree returns a notebook and all notebooks below it
+func (s *NoteService) GetNotebookSubtree(id string) ([]*models.Notebook, error) {
+	return s.store.GetNotebookSubtree(id)
+}
+
+// GetNotesInNotebook returns the notes in a notebook, and in the notebooks below it if recursive is set
+func (s *NoteService) GetNotesInNotebook(id string, recursive bool) ([]*models.Note, error) {
+	return s.store.GetNotesInNotebook(id, recursive)
+}
+
+// CreateNotebook adds a notebook inside a parent notebook, or at the top level
+func (s *NoteService) CreateNotebook(name, parentID string) (*models.Notebook, error) {
+	return s.store.CreateNotebook(name, parentID)
+}
+
+// RenameNotebook renames a notebook
+func (s *NoteService) RenameNotebook(id, name string) (*models.Notebook, error) {
+	if strings.TrimSpace(id) == "" {
+		return nil, fmt.Errorf("notebook ID cannot be empty")
+	}
+
+	return s.store.RenameNotebook(id, name)
+}
+
+// MoveNotebook moves a notebook into another one, or to the top level
+func (s *NoteService) MoveNotebook(id, parentID string) (*models.Notebook, error) {
+	if strings.TrimSpace(id) == "" {
+		return nil, fmt.Errorf("notebook ID cannot be empty")
+	}
+
+	return s.store.MoveNotebook(id, parentID)
+}
+
+// MoveNoteToNotebook moves a note into a notebook, or out of its notebook
+func (s *NoteService) MoveNoteToNotebook(noteID, notebookID string, key []byte) (*models.Note, error) {
+	if key == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+
+	if strings.TrimSpace(noteID) == "" {
+		return nil, fmt.Errorf("note ID cannot be empty")
+	}
+
+	return s.store.MoveNoteToNotebook(noteID, notebookID, key)
+}
+
+// MoveNotebookToTrash moves a notebook to trash with everything in it
+func (s *NoteService) MoveNotebookToTrash(id string, key []byte) (int, error) {
+	if key == nil {
+		return 0, fmt.Errorf("authentication required")
+	}
+
+	return s.store.MoveNotebookToTrash(id, key)
+}
+
+// RestoreNotebook takes a notebook out of trash with the notes that went there with it
+func (s *NoteService) RestoreNotebook(id string, key []byte) (int, error) {
+	if key == nil {
+		return 0, fmt.Errorf("authentication required")
+	}
+
+	return s.store.RestoreNotebook(id, key)
+}
+
+// PermanentlyDeleteNotebook deletes a notebook in trash with the notes that went there with it
+func (s *NoteService) PermanentlyDeleteNotebook(id string) (int, error) {
+	if strings.TrimSpace(id) == "" {
+		return 0, fmt.Errorf("notebook ID cannot be empty")
+	}
+
+	return s.store.PermanentlyDeleteNotebook(id)
+}
+
 // MoveToTrash moves a note to trash category
 func (s *NoteService) MoveToTrash(id string, key []byte) (*models.Note, error) {
 	if key == nil {
@@ -146,11 +367,57 @@ func (s *NoteService) SearchNotes(query string) []*models.Note {
 	return s.store.SearchNotes(query)
 }
 
-// SyncFromDisk syncs notes from disk
-func (s *NoteService) SyncFromDisk() error {
+// SyncFromDisk syncs notes from disk and reports what changed
+func (s *NoteService) SyncFromDisk() (*storage.SyncStats, error) {
 	return s.store.RefreshFromDisk()
 }
 
+// ListRevisions returns the stored revisions of a note, newest first
+func (s *NoteService) ListRevisions(id string) ([]*models.Revision, error) {
+	if strings.TrimSpace(id) == "" {
+		return nil, fmt.Errorf("note ID cannot be empty")
+	}
+
+	return s.store.ListRevisions(id)
+}
+
+// GetRevision returns the note as it was in a stored revision
+func (s *NoteService) GetRevision(id, revisionID string) (*models.Note, error) {
+	if strings.TrimSpace(id) == "" || strings.TrimSpace(revisionID) == "" {
+		return nil, fmt.Errorf("note ID and revision ID cannot be empty")
+	}
+
+	return s.store.GetRevision(id, revisionID)
+}
+
+// DiffRevision compares a stored revision with the current content of the note
+func (s *NoteService) DiffRevision(id, revisionID string) ([]utils.DiffLine, error) {
+	revision, err := s.GetRevision(id, revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.store.GetNote(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.DiffLines(revision.Content, current.Content), nil
+}
+
+// RestoreRevision replaces the content of a note with a stored revision
+func (s *NoteService) RestoreRevision(id, revisionID string, key []byte) (*models.Note, error) {
+	if key == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+
+	if strings.TrimSpace(id) == "" || strings.TrimSpace(revisionID) == "" {
+		return nil, fmt.Errorf("note ID and revision ID cannot be empty")
+	}
+
+	return s.store.RestoreRevision(id, revisionID, key)
+}
+
 // RestoreFromTrash restores a note from trash to its original category
 func (s *NoteService) RestoreFromTrash(id string, key []byte) (*models.Note, error) {
 	if key == nil {
@@ -163,3 +430,41 @@ func (s *NoteService) RestoreFromTrash(id string, key []byte) (*models.Note, err
 
 	return s.store.RestoreFromTrash(id, key)
 }
+
+// GetNotesNeedingReview returns notes whose automatic merge left conflict markers
+func (s *NoteService) GetNotesNeedingReview() []*models.Note {
+	return s.store.GetNotesNeedingReview()
+}
+
+// MarkNoteReviewed clears the review flag set by a conflicting merge
+func (s *NoteService) MarkNoteReviewed(id string, key []byte) (*models.Note, error) {
+	if key == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+
+	if strings.TrimSpace(id) == "" {
+		return nil, fmt.Errorf("note ID cannot be empty")
+	}
+
+	return s.store.MarkNoteReviewed(id, key)
+}
+
+// GetNotesWithSyncConflicts returns the notes that have conflicting copies left by a sync client
+func (s *NoteService) GetNotesWithSyncConflicts() []*models.Note {
+	var notes []*models.Note
+	for _, id := range s.store.NotesWithSyncConflicts() {
+		if note, err := s.store.GetNote(id); err == nil {
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}
+
+// DismissSyncConflicts keeps the current version of a note and moves its sync conflict copies into history
+func (s *NoteService) DismissSyncConflicts(id string) error {
+	if strings.TrimSpace(id) == "" {
+		return fmt.Errorf("note ID cannot be empty")
+	}
+
+	return s.store.DismissSyncConflicts(id)
+}
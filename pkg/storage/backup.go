@@ -10,7 +10,8 @@ import (
 	"time"
 )
 
-// BackupNotes creates a zip archive of all notes in the notes directory.
+// BackupNotes creates a zip archive of the vault: notes, images, categories, notebooks,
+// note history, deletion records and the cross-platform config.
 func BackupNotes(notesDir string, _ string) (string, error) {
 	// Ensure notes directory exists
 	if err := os.MkdirAll(notesDir, 0755); err != nil {
@@ -95,13 +96,20 @@ func BackupNotes(notesDir string, _ string) (string, error) {
 		if strings.HasPrefix(base, "backup-") && strings.HasSuffix(base, ".zip") {
 			continue
 		}
+		if base == ".gote_config.json" {
+			continue // Added below
+		}
 		_ = addFile(file, base)
 	}
 
-	// Include images directory, if present
-	imagesDir := filepath.Join(notesDir, "images")
-	if fi, err := os.Stat(imagesDir); err == nil && fi.IsDir() {
-		filepath.Walk(imagesDir, func(path string, info os.FileInfo, err error) error {
+	// Include images and the vault records kept in subdirectories, if present. The staging
+	// area of a key rotation and the per-device note index are left out.
+	for _, dirName := range []string{"images", categoryDirName, notebookDirName, historyDirName, tombstoneDirName} {
+		dir := filepath.Join(notesDir, dirName)
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return nil
 			}
@@ -112,7 +120,7 @@ func BackupNotes(notesDir string, _ string) (string, error) {
 			if relErr != nil {
 				return nil
 			}
-			_ = addFile(path, relPath)
+			_ = addFile(path, filepath.ToSlash(relPath))
 			return nil
 		})
 	}
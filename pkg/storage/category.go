@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+const (
+	categoryDirName = "categories"
+	categoryRecord  = "category"
+
+	maxCategoryNameLength = 50
+)
+
+// categoryColorPattern matches the colours a category can have
+var categoryColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// encodeCategory encrypts a category; everything but its ID is part of the ciphertext
+func encodeCategory(category *models.Category, key []byte) ([]byte, error) {
+	return encodeRecord(categoryRecord, string(category.ID), category, key)
+}
+
+// decodeCategory decrypts a category file whose name gives the expected category ID
+func decodeCategory(data []byte, expectedID string, key []byte) (*models.Category, error) {
+	var category models.Category
+	if err := decodeRecord(categoryRecord, data, expectedID, key, &category); err != nil {
+		return nil, err
+	}
+	category.ID = models.NoteCategory(expectedID)
+	return &category, nil
+}
+
+// isValidCategoryFilename reports whether a file in the categories directory holds a category:
+// user-defined categories have short hash IDs, the default ones keep their old names
+func isValidCategoryFilename(filename string) bool {
+	id := models.NoteCategory(strings.TrimSuffix(filename, ".json"))
+	return filepath.Ext(filename) == ".json" &&
+		(utils.IsValidShortHashFilename(filename) || id == models.CategoryPrivate || id == models.CategoryWork)
+}
+
+// categoryPath returns the file of a category
+func (s *NoteStore) categoryPath(id models.NoteCategory) string {
+	return filepath.Join(s.dataDir, categoryDirName, string(id)+".json")
+}
+
+// writeCategory encrypts a category, writes it to disk and updates the cached categories.
+// The first change of the implicit default categories writes all of them.
+func (s *NoteStore) writeCategory(category *models.Category) error {
+	s.categoryMutex.Lock()
+	defer s.categoryMutex.Unlock()
+
+	if err := s.materializeCategories(category.ID); err != nil {
+		return err
+	}
+	if err := s.writeCategoryFile(category); err != nil {
+		return err
+	}
+	if s.categories != nil {
+		copied := *category
+		s.categories[category.ID] = &copied
+	}
+	return nil
+}
+
+// removeCategory deletes a category from disk and from the cached categories
+func (s *NoteStore) removeCategory(id models.NoteCategory) error {
+	s.categoryMutex.Lock()
+	defer s.categoryMutex.Unlock()
+
+	if err := s.materializeCategories(id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.categoryPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.categories, id)
+	return nil
+}
+
+// materializeCategories writes the implicit default categories, except the one with the
+// given ID, to disk. Must be called with the category mutex held.
+func (s *NoteStore) materializeCategories(except models.NoteCategory) error {
+	if !s.implicitCategories {
+		return nil
+	}
+	for _, category := range s.categories {
+		if category.ID == except {
+			continue
+		}
+		if err := s.writeCategoryFile(category); err != nil {
+			return fmt.Errorf("failed to create default categories: %v", err)
+		}
+	}
+	s.implicitCategories = false
+	return nil
+}
+
+// writeCategoryFile encrypts a category and writes it to disk
+func (s *NoteStore) writeCategoryFile(category *models.Category) error {
+	if s.key == nil {
+		return fmt.Errorf("not authenticated")
+	}
+
+	data, err := encodeCategory(category, s.key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dataDir, categoryDirName), 0755); err != nil {
+		return fmt.Errorf("failed to create category directory: %v", err)
+	}
+	return utils.WriteFileAtomic(s.categoryPath(category.ID), data, 0644)
+}
+
+// InitializeCategories writes the default categories of a new vault. Only first-time setup
+// does this: elsewhere a vault without category files may not be synced completely yet,
+// and its categories would come back after being deleted on another device.
+func (s *NoteStore) InitializeCategories() error {
+	if _, err := s.loadCategories(); err != nil {
+		return err
+	}
+
+	s.categoryMutex.Lock()
+	defer s.categoryMutex.Unlock()
+	return s.materializeCategories("")
+}
+
+// loadCategories returns a copy of all categories, keyed by ID. They are read from disk
+// once, and again after the watcher saw them change. A vault without category files has
+// the default categories, which are only written once one of them changes. Safe to call
+// with the mutex held.
+func (s *NoteStore) loadCategories() (map[models.NoteCategory]*models.Category, error) {
+	if s.key == nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+
+	s.categoryMutex.Lock()
+	defer s.categoryMutex.Unlock()
+
+	if s.categories == nil {
+		categories, err := s.readCategories()
+		if err != nil {
+			return nil, err
+		}
+		s.implicitCategories = len(categories) == 0
+		if s.implicitCategories {
+			for _, category := range models.DefaultCategories() {
+				categories[category.ID] = category
+			}
+		}
+		s.categories = categories
+	}
+
+	categories := make(map[models.NoteCategory]*models.Category, len(s.categories))
+	for id, category := range s.categories {
+		copied := *category
+		categories[id] = &copied
+	}
+	return categories, nil
+}
+
+// readCategories reads and decrypts all category files
+func (s *NoteStore) readCategories() (map[models.NoteCategory]*models.Category, error) {
+	categories := make(map[models.NoteCategory]*models.Category)
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, categoryDirName))
+	if os.IsNotExist(err) {
+		return categories, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read categories: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isValidCategoryFilename(entry.Name()) {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		data, err := os.ReadFile(filepath.Join(s.dataDir, categoryDirName, entry.Name()))
+		if err != nil {
+			log.Printf("Error reading category %s: %v", id, err)
+			continue
+		}
+		category, err := decodeCategory(data, id, s.key)
+		if err != nil {
+			log.Printf("Ignoring category %s: %v", id, err)
+			continue
+		}
+		categories[category.ID] = category
+	}
+	return categories, nil
+}
+
+// invalidateCategories makes the next loadCategories read the categories from disk again
+func (s *NoteStore) invalidateCategories() {
+	s.categoryMutex.Lock()
+	s.categories = nil
+	s.implicitCategories = false
+	s.categoryMutex.Unlock()
+}
+
+// sortCategories returns categories by their sort order, then by name
+func sortCategories(categories map[models.NoteCategory]*models.Category) []*models.Category {
+	sorted := make([]*models.Category, 0, len(categories))
+	for _, category := range categories {
+		sorted = append(sorted, category)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].SortOrder != sorted[j].SortOrder {
+			return sorted[i].SortOrder < sorted[j].SortOrder
+		}
+		return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name)
+	})
+	return sorted
+}
+
+// ListCategories returns the user-defined categories in their sort order.
+// The reserved trash category is not part of the list.
+func (s *NoteStore) ListCategories() ([]*models.Category, error) {
+	categories, err := s.loadCategories()
+	if err != nil {
+		return nil, err
+	}
+	return sortCategories(categories), nil
+}
+
+// IsValidCategory reports whether notes can be put into a category: an existing one, or trash
+func (s *NoteStore) IsValidCategory(id models.NoteCategory) bool {
+	if id == models.CategoryTrash {
+		return true
+	}
+	categories, err := s.loadCategories()
+	if err != nil {
+		return false
+	}
+	_, exists := categories[id]
+	return exists
+}
+
+// DefaultCategory returns the category of notes created without one: the private category
+// while it exists, otherwise the first one. Safe to call with the mutex held.
+func (s *NoteStore) DefaultCategory() models.NoteCategory {
+	categories, err := s.loadCategories()
+	if err != nil {
+		return models.CategoryPrivate
+	}
+	return defaultCategoryOf(categories)
+}
+
+func defaultCategoryOf(categories map[models.NoteCategory]*models.Category) models.NoteCategory {
+	if _, exists := categories[models.CategoryPrivate]; exists || len(categories) == 0 {
+		return models.CategoryPrivate
+	}
+	return sortCategories(categories)[0].ID
+}
+
+// validateCategory checks the name and colour of a category, which must have a name
+// that no other category has. It returns the trimmed name.
+func validateCategory(name, color string, categories map[models.NoteCategory]*models.Category, id models.NoteCategory) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("category name cannot be empty")
+	}
+	if len([]rune(name)) > maxCategoryNameLength {
+		return "", fmt.Errorf("category name cannot be longer than %d characters", maxCategoryNameLength)
+	}
+	if strings.EqualFold(name, string(models.CategoryTrash)) {
+		return "", fmt.Errorf("%q is reserved", name)
+	}
+	if color != "" && !categoryColorPattern.MatchString(color) {
+		return "", fmt.Errorf("invalid colour %q, expected e.g. #4a90d9", color)
+	}
+	for _, other := range categories {
+		if other.ID != id && strings.EqualFold(other.Name, name) {
+			return "", fmt.Errorf("a category named %q already exists", other.Name)
+		}
+	}
+	return name, nil
+}
+
+// CreateCategory adds a category at the end of the sort order
+func (s *NoteStore) CreateCategory(name, color string) (*models.Category, error) {
+	categories, err := s.loadCategories()
+	if err != nil {
+		return nil, err
+	}
+	name, err = validateCategory(name, color, categories, "")
+	if err != nil {
+		return nil, err
+	}
+
+	sortOrder := 0
+	for _, other := range categories {
+		sortOrder = max(sortOrder, other.SortOrder+1)
+	}
+
+	category := &models.Category{
+		ID:        models.NoteCategory(utils.GenerateShortUUID()),
+		Name:      name,
+		Color:     color,
+		SortOrder: sortOrder,
+		UpdatedAt: time.Now(),
+	}
+	if err := s.writeCategory(category); err != nil {
+		return nil, fmt.Errorf("failed to save category: %v", err)
+	}
+	return category, nil
+}
+
+// UpdateCategory renames a category and changes its colour; the notes in it stay where they are
+func (s *NoteStore) UpdateCategory(id models.NoteCategory, name, color string) (*models.Category, error) {
+	categories, err := s.loadCategories()
+	if err != nil {
+		return nil, err
+	}
+	category, exists := categories[id]
+	if !exists {
+		return nil, fmt.Errorf("category not found")
+	}
+	name, err = validateCategory(name, color, categories, id)
+	if err != nil {
+		return nil, err
+	}
+
+	category.Name = name
+	category.Color = color
+	category.UpdatedAt = time.Now()
+	if err := s.writeCategory(category); err != nil {
+		return nil, fmt.Errorf("failed to save category: %v", err)
+	}
+	return category, nil
+}
+
+// SetCategoryOrder sorts the categories in the given order. Categories that are not
+// listed keep their relative order after the listed ones.
+func (s *NoteStore) SetCategoryOrder(ids []models.NoteCategory) error {
+	categories, err := s.loadCategories()
+	if err != nil {
+		return err
+	}
+
+	position := make(map[models.NoteCategory]int, len(ids))
+	for i, id := range ids {
+		if _, exists := categories[id]; !exists {
+			return fmt.Errorf("category %s not found", id)
+		}
+		position[id] = i
+	}
+
+	sorted := sortCategories(categories)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		pi, listedI := position[sorted[i].ID]
+		pj, listedJ := position[sorted[j].ID]
+		if listedI && listedJ {
+			return pi < pj
+		}
+		return listedI && !listedJ
+	})
+
+	for i, category := range sorted {
+		if category.SortOrder == i {
+			continue
+		}
+		category.SortOrder = i
+		category.UpdatedAt = time.Now()
+		if err := s.writeCategory(category); err != nil {
+			return fmt.Errorf("failed to save category: %v", err)
+		}
+	}
+	return nil
+}
+
+// DeleteCategory removes a category after moving its notes to another one. Notes in the
+// trash that came from the category are restored to the other one. At least one category
+// has to remain. It returns the number of notes that were moved.
+func (s *NoteStore) DeleteCategory(id, reassignTo models.NoteCategory, key []byte) (int, error) {
+	categories, err := s.loadCategories()
+	if err != nil {
+		return 0, err
+	}
+	if _, exists := categories[id]; !exists {
+		return 0, fmt.Errorf("category not found")
+	}
+	if len(categories) == 1 {
+		return 0, fmt.Errorf("the last category cannot be deleted")
+	}
+	if reassignTo == "" {
+		delete(categories, id)
+		reassignTo = defaultCategoryOf(categories)
+	}
+	if _, exists := categories[reassignTo]; !exists || reassignTo == id {
+		return 0, fmt.Errorf("notes can only be moved to another existing category")
+	}
+
+	// Move the notes first; if that fails half-way the category is still there
+	moved := 0
+	for _, note := range s.GetAllNotes() {
+		if note.Category != id && note.OriginalCategory != id {
+			continue
+		}
+		if err := s.reassignCategory(note.ID, id, reassignTo, key); err != nil {
+			return moved, fmt.Errorf("failed to move note %s: %v", note.ID, err)
+		}
+		moved++
+	}
+
+	if err := s.removeCategory(id); err != nil {
+		return moved, fmt.Errorf("failed to remove category: %v", err)
+	}
+	return moved, nil
+}
+
+// reassignCategory moves a note, or the category it returns to from the trash, to another category
+func (s *NoteStore) reassignCategory(noteID string, from, to models.NoteCategory, key []byte) error {
+	s.mutex.Lock()
+	note, err := s.editableNote(noteID)
+	if err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	if note.Category == from {
+		note.Category = to
+	}
+	if note.OriginalCategory == from {
+		note.OriginalCategory = to
+	}
+	note.UpdatedAt = time.Now()
+	s.mutex.Unlock()
+
+	if err := s.saveNote(note, key); err != nil {
+		return err
+	}
+	s.notifyNote(ChangeUpdated, note, false)
+	return nil
+}
+
+// categoryOrDefault returns the category a note belongs in: its own if it exists, otherwise
+// the default one. Notes can be in categories deleted on another device.
+func categoryOrDefault(id models.NoteCategory, categories map[models.NoteCategory]*models.Category) models.NoteCategory {
+	if _, exists := categories[id]; exists || id == models.CategoryTrash {
+		return id
+	}
+	return defaultCategoryOf(categories)
+}
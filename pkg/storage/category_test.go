@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+func TestValidateCategory(t *testing.T) {
+	categories := map[models.NoteCategory]*models.Category{
+		models.CategoryWork:    {ID: models.CategoryWork, Name: "Work"},
+		models.CategoryPrivate: {ID: models.CategoryPrivate, Name: "Private"},
+	}
+
+	tests := []struct {
+		name     string
+		category string
+		color    string
+		id       models.NoteCategory
+		want     string
+		wantErr  bool
+	}{
+		{"new", "  Reading  ", "#4a90d9", "", "Reading", false},
+		{"short colour", "Reading", "#abc", "", "Reading", false},
+		{"no colour", "Reading", "", "", "Reading", false},
+		{"same name as itself", "work", "", models.CategoryWork, "work", false},
+		{"empty", "   ", "", "", "", true},
+		{"too long", strings.Repeat("x", maxCategoryNameLength+1), "", "", "", true},
+		{"trash is reserved", "Trash", "", "", "", true},
+		{"taken", "WORK", "", "", "", true},
+		{"taken by another", "Private", "", models.CategoryWork, "", true},
+		{"colour without hash", "Reading", "4a90d9", "", "", true},
+		{"colour name", "Reading", "red", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateCategory(tt.category, tt.color, categories, tt.id)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateCategory() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateCategory() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("validateCategory() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCategoryOrDefault(t *testing.T) {
+	withPrivate := map[models.NoteCategory]*models.Category{
+		models.CategoryPrivate: {ID: models.CategoryPrivate, Name: "Private", SortOrder: 1},
+		"1a2b3c4d":             {ID: "1a2b3c4d", Name: "Reading", SortOrder: 0},
+	}
+	withoutPrivate := map[models.NoteCategory]*models.Category{
+		"2b3c4d5e": {ID: "2b3c4d5e", Name: "Zoo", SortOrder: 1},
+		"1a2b3c4d": {ID: "1a2b3c4d", Name: "Reading", SortOrder: 0},
+	}
+
+	tests := []struct {
+		name       string
+		id         models.NoteCategory
+		categories map[models.NoteCategory]*models.Category
+		want       models.NoteCategory
+	}{
+		{"existing", "1a2b3c4d", withPrivate, "1a2b3c4d"},
+		{"trash", models.CategoryTrash, withPrivate, models.CategoryTrash},
+		{"deleted, private exists", "ffffffff", withPrivate, models.CategoryPrivate},
+		{"deleted, private deleted", "ffffffff", withoutPrivate, "1a2b3c4d"},
+		{"none", "", withoutPrivate, "1a2b3c4d"},
+		{"no categories", "ffffffff", map[models.NoteCategory]*models.Category{}, models.CategoryPrivate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := categoryOrDefault(tt.id, tt.categories); got != tt.want {
+				t.Errorf("categoryOrDefault() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// categoryNames returns the names of the categories in their sort order
+func categoryNames(t *testing.T, store *NoteStore) string {
+	t.Helper()
+	categories, err := store.ListCategories()
+	if err != nil {
+		t.Fatalf("ListCategories() error: %v", err)
+	}
+	names := make([]string, len(categories))
+	for i, category := range categories {
+		names[i] = category.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestCategoryRegistry(t *testing.T) {
+	store, key := testStore(t)
+
+	// A vault without category files has the defaults, without writing them
+	if got := categoryNames(t, store); got != "Work, Private" {
+		t.Errorf("default categories = %q", got)
+	}
+	if dirExists(filepath.Join(store.dataDir, categoryDirName)) {
+		t.Errorf("default categories were written before they changed")
+	}
+
+	reading, err := store.CreateCategory("Reading", "#4a90d9")
+	if err != nil {
+		t.Fatalf("CreateCategory() error: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(store.dataDir, categoryDirName))
+	if err != nil || len(entries) != 3 {
+		t.Errorf("%d category files after the first change, want 3 (%v)", len(entries), err)
+	}
+
+	if err := store.SetCategoryOrder([]models.NoteCategory{reading.ID, models.CategoryPrivate}); err != nil {
+		t.Fatalf("SetCategoryOrder() error: %v", err)
+	}
+	if got := categoryNames(t, store); got != "Reading, Private, Work" {
+		t.Errorf("categories after reordering = %q", got)
+	}
+
+	// Deleting a category moves its notes, including those in trash that came from it
+	inReading, err := store.CreateNoteWithCategory("to read", reading.ID, key)
+	if err != nil {
+		t.Fatalf("CreateNoteWithCategory() error: %v", err)
+	}
+	trashed, err := store.CreateNoteWithCategory("read", reading.ID, key)
+	if err != nil {
+		t.Fatalf("CreateNoteWithCategory() error: %v", err)
+	}
+	if _, err := store.MoveToTrash(trashed.ID, key); err != nil {
+		t.Fatalf("MoveToTrash() error: %v", err)
+	}
+	moved, err := store.DeleteCategory(reading.ID, models.CategoryWork, key)
+	if err != nil {
+		t.Fatalf("DeleteCategory() error: %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("DeleteCategory() moved %d notes, want 2", moved)
+	}
+	if note, _ := store.GetNote(inReading.ID); note == nil || note.Category != models.CategoryWork {
+		t.Errorf("note of the deleted category = %+v, want it in work", note)
+	}
+	if note, _ := store.GetNote(trashed.ID); note == nil || note.OriginalCategory != models.CategoryWork {
+		t.Errorf("trashed note of the deleted category = %+v, want it to return to work", note)
+	}
+	if store.IsValidCategory(reading.ID) {
+		t.Errorf("deleted category is still valid")
+	}
+
+	if _, err := store.DeleteCategory(models.CategoryPrivate, "", key); err != nil {
+		t.Fatalf("DeleteCategory() error: %v", err)
+	}
+	if store.DefaultCategory() != models.CategoryWork {
+		t.Errorf("DefaultCategory() = %q without private, want work", store.DefaultCategory())
+	}
+	if _, err := store.DeleteCategory(models.CategoryWork, "", key); err == nil {
+		t.Errorf("the last category was deleted")
+	}
+}
+
+func TestCategoryChangedElsewhere(t *testing.T) {
+	store, key := testStore(t)
+	store.SetPollingMode(true, time.Hour)
+	if _, err := store.CreateCategory("Reading", ""); err != nil {
+		t.Fatalf("CreateCategory() error: %v", err)
+	}
+
+	// Another device adds a category; the cached categories are read again on reconciliation
+	data, err := encodeCategory(&models.Category{ID: "ffffffff", Name: "Synced", SortOrder: 10}, key)
+	if err != nil {
+		t.Fatalf("encodeCategory() error: %v", err)
+	}
+	if err := utils.WriteFileAtomic(store.categoryPath("ffffffff"), data, 0644); err != nil {
+		t.Fatalf("WriteFileAtomic() error: %v", err)
+	}
+	store.reconcile()
+
+	if got := categoryNames(t, store); got != "Work, Private, Reading, Synced" {
+		t.Errorf("categories after a change elsewhere = %q", got)
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import "gote/pkg/models"
+
+// Kinds of note changes reported to subscribers
+const (
+	ChangeCreated   = "created"
+	ChangeUpdated   = "updated"
+	ChangeDeleted   = "deleted"
+	ChangeConflict  = "conflict"  // Concurrent edits need the user's attention, see Conflict
+	ChangeCorrupted = "corrupted" // A note file can no longer be read
+)
+
+// Change describes a change of a note in the store
+type Change struct {
+	Type     string
+	NoteID   string
+	Note     *models.Note // The note after the change; nil for deletions and corrupted notes
+	Conflict *Conflict    // Set for ChangeConflict
+	External bool         // The change was read from disk rather than made through the store
+}
+
+// Subscribe registers a function that is called after every change of a note.
+// It is called without the store lock held and must not block for long.
+// The returned function removes the subscription.
+func (s *NoteStore) Subscribe(subscriber func(Change)) (unsubscribe func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := s.nextSubscriberID
+	s.nextSubscriberID++
+	s.subscribers[id] = subscriber
+
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+// notify passes a change to all subscribers. Must be called without the mutex held.
+func (s *NoteStore) notify(change Change) {
+	s.mutex.RLock()
+	subscribers := make([]func(Change), 0, len(s.subscribers))
+	for _, subscriber := range s.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	s.mutex.RUnlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(change)
+	}
+}
+
+// notifyNote reports a change that carries the note itself
+func (s *NoteStore) notifyNote(changeType string, note *models.Note, external bool) {
+	s.notify(Change{Type: changeType, NoteID: note.ID, Note: note, External: external})
+}
+
+// notifyID reports a change of a note that is no longer available
+func (s *NoteStore) notifyID(changeType, noteID string, external bool) {
+	s.notify(Change{Type: changeType, NoteID: noteID, External: external})
+}
+
+// notifyConflict reports concurrent edits that could not be combined without the user
+func (s *NoteStore) notifyConflict(conflict Conflict, note *models.Note) {
+	s.notify(Change{Type: ChangeConflict, NoteID: conflict.NoteID, Note: note, Conflict: &conflict, External: true})
+}
@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+// SetDeviceID sets the identifier this device records in the version of every note it saves
+func (s *NoteStore) SetDeviceID(deviceID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.deviceID = deviceID
+}
+
+// Kinds of conflicts reported with ChangeConflict
+const (
+	ConflictCopy     = "copy"      // The local edit was saved as a separate conflict copy note
+	ConflictMerged   = "merged"    // Both edits were merged into the note between conflict markers
+	ConflictSyncFile = "sync_file" // A sync client left a conflicting copy of the note file
+)
+
+// Conflict describes edits of a note on two devices that need the user's attention
+type Conflict struct {
+	NoteID string `json:"note_id"`
+	Kind   string `json:"kind"`
+	CopyID string `json:"copy_id,omitempty"` // Conflict copy note (ConflictCopy) or revision ID of the sync file (ConflictSyncFile)
+}
+
+// bumpVersion records a local edit of the note. Must be called with the mutex held.
+func (s *NoteStore) bumpVersion(note *models.Note) {
+	note.Versions = note.Versions.Increment(s.deviceID)
+}
+
+// mergeBase is the part of a note version needed as the common ancestor of a merge
+type mergeBase struct {
+	Content  string
+	Category models.NoteCategory
+	Notebook string
+	Tags     []string
+	Versions models.VersionVector
+}
+
+func newMergeBase(note *models.Note) *mergeBase {
+	return &mergeBase{Content: note.Content, Category: note.Category, Notebook: note.Notebook, Tags: note.Tags, Versions: note.Versions}
+}
+
+// resolveIncoming decides what to do with a note read from disk while a copy of it is in memory.
+// It returns the note to keep in memory and, if both copies were edited since their common
+// version, the local copy that has to be merged with the one from disk (see resolveConflict).
+// Must be called with the mutex held.
+func (s *NoteStore) resolveIncoming(existing, incoming *models.Note) (keep, conflict *models.Note) {
+	if existing != nil && existing.Partial {
+		return s.compareIncomingLazy(existing, incoming)
+	}
+
+	keep, conflict = s.compareIncoming(existing, incoming)
+	if keep == incoming && conflict == nil && !s.lazy {
+		// The newest version seen from disk is the ancestor of later concurrent edits
+		s.bases[incoming.ID] = newMergeBase(incoming)
+	}
+	return keep, conflict
+}
+
+// compareIncoming orders a note from disk against the copy in memory by their versions
+func (s *NoteStore) compareIncoming(existing, incoming *models.Note) (keep, conflict *models.Note) {
+	if existing == nil {
+		return incoming, nil
+	}
+
+	// Notes saved before versions were tracked can only be compared by time
+	if len(existing.Versions) == 0 || len(incoming.Versions) == 0 {
+		if incoming.UpdatedAt.After(existing.UpdatedAt) {
+			return incoming, nil
+		}
+		return existing, nil
+	}
+
+	switch incoming.Versions.Compare(existing.Versions) {
+	case models.VersionAfter:
+		return incoming, nil
+	case models.VersionConcurrent:
+		if incoming.Content == existing.Content && incoming.Category == existing.Category && incoming.Notebook == existing.Notebook && sameTags(incoming.Tags, existing.Tags) {
+			// Both devices made the same change
+			incoming.Versions = incoming.Versions.Merge(existing.Versions)
+			return incoming, nil
+		}
+		return incoming, existing
+	default:
+		// Equal, or the file is older than what we already have
+		return existing, nil
+	}
+}
+
+// compareIncomingLazy is compareIncoming for a note of which only the metadata is in memory.
+// Merge bases are not kept in lazy mode; merges rely on the note's history instead.
+// Must be called with the mutex held.
+func (s *NoteStore) compareIncomingLazy(existing, incoming *models.Note) (keep, conflict *models.Note) {
+	local, ok := s.localVersion(existing)
+	if !ok {
+		if len(existing.Versions) > 0 && incoming.Versions.Compare(existing.Versions) == models.VersionConcurrent {
+			log.Printf("Warning: Local edit of note %s is no longer in memory; taking the version from disk", incoming.ID)
+			incoming.Versions = incoming.Versions.Merge(existing.Versions)
+			return incoming, nil
+		}
+		local = existing // Only versions and times are compared
+	}
+
+	keep, conflict = s.compareIncoming(local, incoming)
+	if keep == local {
+		keep = existing
+	}
+	return keep, conflict
+}
+
+// resolveConflict combines a local edit with a concurrent edit read from disk. With a known common
+// version the two are merged line by line; overlapping changes are kept between conflict markers and
+// the note is flagged for review. Without a common version the local edit becomes a conflict copy.
+func (s *NoteStore) resolveConflict(local, incoming *models.Note) {
+	base := s.findMergeBase(local, incoming)
+
+	s.mutex.Lock()
+	if !s.lazy {
+		s.bases[incoming.ID] = newMergeBase(incoming)
+	}
+	key := s.key
+	s.mutex.Unlock()
+
+	if base == nil {
+		s.saveConflictCopy(local)
+		return
+	}
+
+	content, clean := utils.Merge3(base.Content, local.Content, incoming.Content)
+
+	merged := *incoming
+	merged.Content = content
+	merged.UpdatedAt = time.Now()
+	merged.Versions = incoming.Versions.Merge(local.Versions)
+	merged.NeedsReview = incoming.NeedsReview || local.NeedsReview || !clean
+	merged.Images = mergeImages(incoming.Images, local.Images)
+	merged.Tags = mergeTags(base.Tags, local.Tags, incoming.Tags)
+	if local.Category != base.Category {
+		// Only this device moved the note, or both did and the local move wins
+		merged.Category = local.Category
+		merged.OriginalCategory = local.OriginalCategory
+		merged.TrashedWith = local.TrashedWith
+	}
+	if local.Notebook != base.Notebook {
+		merged.Notebook = local.Notebook
+	}
+
+	s.mutex.Lock()
+	s.putNote(&merged)
+	s.mutex.Unlock()
+
+	if err := s.saveNote(&merged, key); err != nil {
+		log.Printf("Error saving merged note %s: %v", merged.ID, err)
+		return
+	}
+
+	s.notifyNote(ChangeUpdated, &merged, true)
+	if clean {
+		log.Printf("Merged concurrent edits of note %s", merged.ID)
+		return
+	}
+	log.Printf("Merged concurrent edits of note %s with conflicts; flagged for review", merged.ID)
+	s.notifyConflict(Conflict{NoteID: merged.ID, Kind: ConflictMerged}, &merged)
+}
+
+// findMergeBase returns the most recent known version that both notes were derived from,
+// looking at the last version read from disk and at the note's history
+func (s *NoteStore) findMergeBase(local, incoming *models.Note) *mergeBase {
+	isAncestor := func(candidate models.VersionVector) bool {
+		ofLocal := candidate.Compare(local.Versions)
+		ofIncoming := candidate.Compare(incoming.Versions)
+		return (ofLocal == models.VersionEqual || ofLocal == models.VersionBefore) &&
+			(ofIncoming == models.VersionEqual || ofIncoming == models.VersionBefore)
+	}
+
+	var best *mergeBase
+	consider := func(candidate *mergeBase) {
+		if isAncestor(candidate.Versions) && (best == nil || versionTotal(candidate.Versions) > versionTotal(best.Versions)) {
+			best = candidate
+		}
+	}
+
+	s.mutex.RLock()
+	if base, ok := s.bases[local.ID]; ok {
+		consider(base)
+	}
+	s.mutex.RUnlock()
+
+	files, err := s.revisionFiles(local.ID)
+	if err != nil {
+		log.Printf("Warning: Failed to read history of note %s: %v", local.ID, err)
+	}
+	for _, file := range files {
+		revision, err := s.loadRevision(local.ID, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		consider(newMergeBase(revision))
+	}
+
+	return best
+}
+
+// versionTotal counts the saves recorded in a version, so later versions of the same history rank higher
+func versionTotal(v models.VersionVector) uint64 {
+	var total uint64
+	for _, count := range v {
+		total += count
+	}
+	return total
+}
+
+// mergeImages returns the images referenced by either version of a note
+func mergeImages(a, b []models.Image) []models.Image {
+	merged := append([]models.Image(nil), a...)
+	seen := make(map[string]bool, len(a))
+	for _, image := range a {
+		seen[image.ID] = true
+	}
+	for _, image := range b {
+		if !seen[image.ID] {
+			merged = append(merged, image)
+		}
+	}
+	return merged
+}
+
+// saveConflictCopy stores the local version of a conflicting note as a separate note,
+// so that the copy from disk can take its place without losing either edit
+func (s *NoteStore) saveConflictCopy(local *models.Note) {
+	s.mutex.Lock()
+	conflictCopy := &models.Note{
+		ID:               utils.GenerateShortUUID(),
+		Content:          local.Content,
+		Category:         local.Category,
+		OriginalCategory: local.OriginalCategory,
+		Images:           local.Images,
+		Tags:             local.Tags,
+		Notebook:         local.Notebook,
+		TrashedWith:      local.TrashedWith,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        local.UpdatedAt,
+		ConflictOf:       local.ID,
+	}
+	s.bumpVersion(conflictCopy)
+	s.putNote(conflictCopy)
+	key := s.key
+	s.mutex.Unlock()
+
+	if err := s.writeNote(conflictCopy, key); err != nil {
+		log.Printf("Error saving conflict copy of note %s: %v", local.ID, err)
+		s.mutex.Lock()
+		s.dropNote(conflictCopy.ID)
+		s.mutex.Unlock()
+		return
+	}
+
+	log.Printf("Note %s was changed on another device; local version kept as %s", local.ID, conflictCopy.ID)
+
+	s.mutex.RLock()
+	current := s.notes[local.ID]
+	s.mutex.RUnlock()
+
+	s.notifyNote(ChangeCreated, conflictCopy, true)
+	s.notifyConflict(Conflict{NoteID: local.ID, Kind: ConflictCopy, CopyID: conflictCopy.ID}, current)
+}
+
+// GetNotesNeedingReview returns the notes whose merge left conflict markers, newest first
+func (s *NoteStore) GetNotesNeedingReview() []*models.Note {
+	var notes []*models.Note
+	for _, note := range s.GetAllNotes() {
+		if note.NeedsReview {
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}
+
+// MarkNoteReviewed clears the review flag of a note, keeping its content as it is
+func (s *NoteStore) MarkNoteReviewed(id string, key []byte) (*models.Note, error) {
+	s.mutex.Lock()
+	note, err := s.editableNote(id)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	if !note.NeedsReview {
+		s.mutex.Unlock()
+		return note, nil
+	}
+
+	note.NeedsReview = false
+	note.UpdatedAt = time.Now()
+	s.mutex.Unlock()
+
+	if err := s.saveNote(note, key); err != nil {
+		return nil, err
+	}
+	s.notifyNote(ChangeUpdated, note, false)
+	return note, nil
+}
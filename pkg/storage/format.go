@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gote/pkg/crypto"
+	"gote/pkg/models"
+)
+
+// On-disk image formats
+const (
+	ImageFormatV1 = 1 // Image data encrypted without additional data (also files without a format_version)
+
+	LatestImageFormat = ImageFormatV1
+)
+
+// ErrUnsupportedFormat is returned for files written by a newer version of the app.
+// Such files are left alone instead of being misread or overwritten.
+var ErrUnsupportedFormat = errors.New("file was written by a newer version of Gote")
+
+// noteDecoder turns a parsed note envelope of one format version into a note
+type noteDecoder func(envelope *models.EncryptedNote, key []byte) (*models.Note, error)
+
+// noteDecoders has one decoder per note format version that this build can read
+var noteDecoders = map[int]noteDecoder{
+	models.NoteFormatV1: decodeNoteV1,
+	models.NoteFormatV2: decodeNoteV2,
+	models.NoteFormatV3: decodeNoteV3,
+}
+
+// imageDecoder decrypts the image data of one format version
+type imageDecoder func(envelope *EncryptedImage, key []byte) ([]byte, error)
+
+// imageDecoders has one decoder per image format version that this build can read
+var imageDecoders = map[int]imageDecoder{
+	ImageFormatV1: decodeImageV1,
+}
+
+// notePayload is the part of a note that is encrypted
+type notePayload struct {
+	Content          string               `json:"content"`
+	Category         models.NoteCategory  `json:"category"`
+	OriginalCategory models.NoteCategory  `json:"original_category,omitempty"`
+	Images           []models.Image       `json:"images,omitempty"`
+	Versions         models.VersionVector `json:"versions,omitempty"`
+	ConflictOf       string               `json:"conflict_of,omitempty"`
+	NeedsReview      bool                 `json:"needs_review,omitempty"`
+	Tags             []string             `json:"tags,omitempty"`
+	Notebook         string               `json:"notebook,omitempty"`
+	TrashedWith      string               `json:"trashed_with,omitempty"`
+}
+
+// sealedNotePayload is the encrypted part of a note whose metadata is hidden (NoteFormatV3)
+type sealedNotePayload struct {
+	notePayload
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// sealedNoteEnvelope is the plaintext part of a NoteFormatV3 file; it carries no timestamps
+type sealedNoteEnvelope struct {
+	FormatVersion int    `json:"format_version"`
+	ID            string `json:"id"`
+	EncryptedData string `json:"encrypted_data"`
+}
+
+// encodeNote encrypts a note into the given on-disk format
+func encodeNote(note *models.Note, key []byte, format int) ([]byte, error) {
+	payload := notePayload{
+		Content:          note.Content,
+		Category:         note.Category,
+		OriginalCategory: note.OriginalCategory,
+		Images:           note.Images,
+		Versions:         note.Versions,
+		ConflictOf:       note.ConflictOf,
+		NeedsReview:      note.NeedsReview,
+		Tags:             note.Tags,
+		Notebook:         note.Notebook,
+		TrashedWith:      note.TrashedWith,
+	}
+
+	if format == models.NoteFormatV3 {
+		return encodeSealedNote(note, payload, key)
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedNote := models.EncryptedNote{
+		FormatVersion: models.NoteFormatV2,
+		ID:            note.ID,
+		CreatedAt:     note.CreatedAt,
+		UpdatedAt:     note.UpdatedAt,
+	}
+
+	encryptedNote.EncryptedData, err = crypto.EncryptBytesWithAAD(payloadJSON, key, encryptedNote.AssociatedData())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(encryptedNote, "", "  ")
+}
+
+// encodeSealedNote encrypts the note together with its timestamps, padded to a size bucket
+func encodeSealedNote(note *models.Note, payload notePayload, key []byte) ([]byte, error) {
+	payloadJSON, err := json.Marshal(sealedNotePayload{
+		notePayload: payload,
+		CreatedAt:   note.CreatedAt,
+		UpdatedAt:   note.UpdatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	aad := (&models.EncryptedNote{FormatVersion: models.NoteFormatV3, ID: note.ID}).AssociatedData()
+	encryptedData, err := crypto.EncryptBytesWithAAD(crypto.PadToBucket(payloadJSON), key, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(sealedNoteEnvelope{
+		FormatVersion: models.NoteFormatV3,
+		ID:            note.ID,
+		EncryptedData: encryptedData,
+	}, "", "  ")
+}
+
+// parseNoteEnvelope reads the plaintext part of a note file and checks that this build can decode it
+func parseNoteEnvelope(data []byte) (*models.EncryptedNote, error) {
+	var encryptedNote models.EncryptedNote
+	if err := json.Unmarshal(data, &encryptedNote); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal encrypted note: %v", err)
+	}
+
+	if encryptedNote.Version() > models.LatestNoteFormat {
+		return nil, fmt.Errorf("%w: note format %d", ErrUnsupportedFormat, encryptedNote.Version())
+	}
+	return &encryptedNote, nil
+}
+
+// decodeNote decrypts a note file. For formats that authenticate metadata the note ID must
+// match expectedID (taken from the filename), so ciphertext cannot be moved between files.
+func decodeNote(data []byte, expectedID string, key []byte) (*models.Note, error) {
+	encryptedNote, err := parseNoteEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, ok := noteDecoders[encryptedNote.Version()]
+	if !ok {
+		return nil, fmt.Errorf("unknown note format %d", encryptedNote.Version())
+	}
+
+	if encryptedNote.Version() >= models.NoteFormatV2 && encryptedNote.ID != expectedID {
+		return nil, fmt.Errorf("note ID %s does not match file name %s.json", encryptedNote.ID, expectedID)
+	}
+
+	note, err := decoder(encryptedNote, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure category is set (handle empty category in new format)
+	if note.Category == "" {
+		note.Category = models.CategoryPrivate
+	}
+	return note, nil
+}
+
+// decodeNoteV1 reads notes from before format versions, whose payload may be a plain string
+func decodeNoteV1(envelope *models.EncryptedNote, key []byte) (*models.Note, error) {
+	decrypted, err := crypto.DecryptBytes(envelope.EncryptedData, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt note: %v", err)
+	}
+
+	var payload notePayload
+	if err := json.Unmarshal(decrypted, &payload); err != nil {
+		// Legacy format - content is just a string
+		payload = notePayload{Content: string(decrypted)}
+	}
+	return newNoteFromPayload(envelope.ID, payload, envelope.CreatedAt, envelope.UpdatedAt), nil
+}
+
+// decodeNoteV2 reads notes whose ID and timestamps are authenticated as additional data
+func decodeNoteV2(envelope *models.EncryptedNote, key []byte) (*models.Note, error) {
+	decrypted, err := crypto.DecryptBytesWithAAD(envelope.EncryptedData, key, envelope.AssociatedData())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt note: %v", err)
+	}
+
+	var payload notePayload
+	if err := json.Unmarshal(decrypted, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse note payload: %v", err)
+	}
+	return newNoteFromPayload(envelope.ID, payload, envelope.CreatedAt, envelope.UpdatedAt), nil
+}
+
+// decodeNoteV3 reads notes whose timestamps are encrypted and whose payload is padded
+func decodeNoteV3(envelope *models.EncryptedNote, key []byte) (*models.Note, error) {
+	decrypted, err := crypto.DecryptBytesWithAAD(envelope.EncryptedData, key, envelope.AssociatedData())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt note: %v", err)
+	}
+
+	unpadded, err := crypto.Unpad(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpad note: %v", err)
+	}
+
+	var sealed sealedNotePayload
+	if err := json.Unmarshal(unpadded, &sealed); err != nil {
+		return nil, fmt.Errorf("failed to parse note payload: %v", err)
+	}
+	return newNoteFromPayload(envelope.ID, sealed.notePayload, sealed.CreatedAt, sealed.UpdatedAt), nil
+}
+
+// newNoteFromPayload builds an in-memory note from decoded parts
+func newNoteFromPayload(id string, payload notePayload, createdAt, updatedAt time.Time) *models.Note {
+	return &models.Note{
+		ID:               id,
+		Content:          payload.Content,
+		Category:         payload.Category,
+		OriginalCategory: payload.OriginalCategory,
+		Images:           payload.Images,
+		CreatedAt:        createdAt,
+		UpdatedAt:        updatedAt,
+		Versions:         payload.Versions,
+		ConflictOf:       payload.ConflictOf,
+		NeedsReview:      payload.NeedsReview,
+		Tags:             payload.Tags,
+		Notebook:         payload.Notebook,
+		TrashedWith:      payload.TrashedWith,
+	}
+}
+
+// imageVersion returns the on-disk format of an image, treating files without a version as V1
+func imageVersion(encryptedImage *EncryptedImage) int {
+	if encryptedImage.FormatVersion == 0 {
+		return ImageFormatV1
+	}
+	return encryptedImage.FormatVersion
+}
+
+// decryptImage decrypts image data with the decoder registered for its format version
+func decryptImage(encryptedImage *EncryptedImage, key []byte) ([]byte, error) {
+	decoder, ok := imageDecoders[imageVersion(encryptedImage)]
+	if !ok {
+		return nil, fmt.Errorf("unknown image format %d", imageVersion(encryptedImage))
+	}
+	return decoder(encryptedImage, key)
+}
+
+// decodeImageV1 decrypts image data encrypted without additional data
+func decodeImageV1(envelope *EncryptedImage, key []byte) ([]byte, error) {
+	return crypto.DecryptBytes(envelope.EncryptedData, key)
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"gote/pkg/crypto"
+	"gote/pkg/models"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+	return key
+}
+
+func testNote() *models.Note {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return &models.Note{
+		ID:        "1a2b3c4d",
+		Content:   "# Title\nbody",
+		Category:  models.CategoryWork,
+		CreatedAt: created,
+		UpdatedAt: created.Add(time.Hour),
+		Versions:  models.VersionVector{"device": 2},
+		Tags:      []string{"idea"},
+		Notebook:  "9f8e7d6c",
+	}
+}
+
+// encodeNoteV1 writes a note the way versions without format_version did
+func encodeNoteV1(t *testing.T, id string, payload []byte, key []byte) []byte {
+	t.Helper()
+	encryptedData, err := crypto.EncryptBytes(payload, key)
+	if err != nil {
+		t.Fatalf("EncryptBytes() error: %v", err)
+	}
+	data, err := json.Marshal(models.EncryptedNote{
+		ID:            id,
+		EncryptedData: encryptedData,
+		CreatedAt:     time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:     time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Marshal() error: %v", err)
+	}
+	return data
+}
+
+func TestDecodeNoteRoundTrip(t *testing.T) {
+	key := testKey(t)
+	for _, format := range []int{models.NoteFormatV2, models.NoteFormatV3} {
+		note := testNote()
+		data, err := encodeNote(note, key, format)
+		if err != nil {
+			t.Fatalf("format %d: encodeNote() error: %v", format, err)
+		}
+
+		var envelope models.EncryptedNote
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			t.Fatalf("format %d: envelope is not JSON: %v", format, err)
+		}
+		if envelope.Version() != format {
+			t.Errorf("format %d: envelope has format %d", format, envelope.Version())
+		}
+
+		decoded, err := decodeNote(data, note.ID, key)
+		if err != nil {
+			t.Fatalf("format %d: decodeNote() error: %v", format, err)
+		}
+		if decoded.Content != note.Content || decoded.Category != note.Category ||
+			decoded.Notebook != note.Notebook || !sameTags(decoded.Tags, note.Tags) ||
+			decoded.Versions.Compare(note.Versions) != models.VersionEqual {
+			t.Errorf("format %d: decoded %+v, want %+v", format, decoded, note)
+		}
+		if !decoded.CreatedAt.Equal(note.CreatedAt) || !decoded.UpdatedAt.Equal(note.UpdatedAt) {
+			t.Errorf("format %d: decoded times %v %v, want %v %v", format,
+				decoded.CreatedAt, decoded.UpdatedAt, note.CreatedAt, note.UpdatedAt)
+		}
+	}
+}
+
+func TestDecodeNoteV1(t *testing.T) {
+	key := testKey(t)
+
+	tests := []struct {
+		name         string
+		payload      string
+		wantContent  string
+		wantCategory models.NoteCategory
+	}{
+		{"plain string", "legacy content", "legacy content", models.CategoryPrivate},
+		{"payload", `{"content":"structured","category":"work"}`, "structured", models.CategoryWork},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			note, err := decodeNote(encodeNoteV1(t, "1a2b3c4d", []byte(tt.payload), key), "1a2b3c4d", key)
+			if err != nil {
+				t.Fatalf("decodeNote() error: %v", err)
+			}
+			if note.Content != tt.wantContent || note.Category != tt.wantCategory {
+				t.Errorf("decodeNote() = %q in %q, want %q in %q", note.Content, note.Category, tt.wantContent, tt.wantCategory)
+			}
+		})
+	}
+}
+
+func TestDecodeNoteRejects(t *testing.T) {
+	key := testKey(t)
+	note := testNote()
+
+	v2, err := encodeNote(note, key, models.NoteFormatV2)
+	if err != nil {
+		t.Fatalf("encodeNote() error: %v", err)
+	}
+	v3, err := encodeNote(note, key, models.NoteFormatV3)
+	if err != nil {
+		t.Fatalf("encodeNote() error: %v", err)
+	}
+
+	var tampered models.EncryptedNote
+	if err := json.Unmarshal(v2, &tampered); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	tampered.UpdatedAt = tampered.UpdatedAt.Add(time.Minute)
+	tamperedV2, _ := json.Marshal(tampered)
+
+	var newer models.EncryptedNote
+	if err := json.Unmarshal(v3, &newer); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	newer.FormatVersion = models.LatestNoteFormat + 1
+	newerFormat, _ := json.Marshal(newer)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		expectedID string
+		key        []byte
+	}{
+		{"V2 moved to another file", v2, "ffffffff", key},
+		{"V3 moved to another file", v3, "ffffffff", key},
+		{"V2 with changed timestamp", tamperedV2, note.ID, key},
+		{"V3 with another key", v3, note.ID, testKey(t)},
+		{"newer format", newerFormat, note.ID, key},
+		{"not JSON", []byte("not json"), note.ID, key},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeNote(tt.data, tt.expectedID, tt.key); err == nil {
+				t.Errorf("decodeNote() succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := decodeNote(newerFormat, note.ID, key); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("decodeNote() of a newer format = %v, want ErrUnsupportedFormat", err)
+	}
+}
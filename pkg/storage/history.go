@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+const (
+	historyDirName = "history"
+
+	// DefaultHistoryMaxRevisions is the number of revisions kept per note unless configured otherwise
+	DefaultHistoryMaxRevisions = 50
+
+	// Saves closer together than this (e.g. autosave while typing) share one revision
+	historyMinInterval = 5 * time.Minute
+)
+
+// historyDir returns the directory holding the revisions of a note
+func (s *NoteStore) historyDir(noteID string) string {
+	return filepath.Join(s.dataDir, historyDirName, noteID)
+}
+
+// SetHistoryRetention sets how many revisions are kept per note and for how long.
+// A maxRevisions of 0 keeps the default number; a maxAge of 0 keeps revisions regardless of age.
+func (s *NoteStore) SetHistoryRetention(maxRevisions int, maxAge time.Duration) {
+	if maxRevisions <= 0 {
+		maxRevisions = DefaultHistoryMaxRevisions
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.historyMaxRevisions = maxRevisions
+	s.historyMaxAge = maxAge
+}
+
+// archiveRevision keeps the note file that is about to be overwritten as a revision.
+// The file is copied as is, so revisions stay encrypted exactly like notes.
+// Unless force is set, no revision is added if the last one is more recent than historyMinInterval.
+func (s *NoteStore) archiveRevision(noteID string, force bool) error {
+	data, err := os.ReadFile(filepath.Join(s.dataDir, noteID+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // New note, nothing to keep
+		}
+		return err
+	}
+
+	revisions, err := s.revisionFiles(noteID)
+	if err != nil {
+		return err
+	}
+	if !force && len(revisions) > 0 && time.Since(revisions[0].ModTime()) < historyMinInterval {
+		return nil
+	}
+
+	dir := s.historyDir(noteID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(dir, utils.GenerateShortUUID()+".json"), data, 0644); err != nil {
+		return err
+	}
+
+	return s.pruneHistory(noteID)
+}
+
+// revisionFiles lists the revision files of a note, newest first
+func (s *NoteStore) revisionFiles(noteID string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(s.historyDir(noteID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !utils.IsValidShortHashFilename(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	return files, nil
+}
+
+// pruneHistory removes revisions beyond the configured count or age
+func (s *NoteStore) pruneHistory(noteID string) error {
+	s.mutex.RLock()
+	maxRevisions, maxAge := s.historyMaxRevisions, s.historyMaxAge
+	s.mutex.RUnlock()
+
+	files, err := s.revisionFiles(noteID)
+	if err != nil {
+		return err
+	}
+
+	for i, file := range files {
+		tooMany := i >= maxRevisions
+		tooOld := maxAge > 0 && time.Since(file.ModTime()) > maxAge
+		if tooMany || tooOld {
+			if err := os.Remove(filepath.Join(s.historyDir(noteID), file.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteHistory removes all revisions of a note
+func (s *NoteStore) deleteHistory(noteID string) {
+	if err := os.RemoveAll(s.historyDir(noteID)); err != nil {
+		log.Printf("Warning: Failed to remove history of note %s: %v", noteID, err)
+	}
+}
+
+// ListRevisions returns the stored revisions of a note, newest first
+func (s *NoteStore) ListRevisions(noteID string) ([]*models.Revision, error) {
+	if s.key == nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+
+	files, err := s.revisionFiles(noteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+
+	conflictFiles, err := s.syncConflictFiles(noteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync conflict files: %v", err)
+	}
+
+	revisions := make([]*models.Revision, 0, len(conflictFiles)+len(files))
+
+	// Unresolved copies from the sync client come first; they are what the user has to look at
+	for _, file := range conflictFiles {
+		revisionID := strings.TrimSuffix(file.Name(), ".json")
+		note, err := s.loadRevision(noteID, revisionID)
+		if err != nil {
+			log.Printf("Skipping unreadable sync conflict file %s: %v", file.Name(), err)
+			continue
+		}
+		revisions = append(revisions, &models.Revision{
+			ID:           revisionID,
+			NoteID:       noteID,
+			SavedAt:      file.ModTime(),
+			UpdatedAt:    note.UpdatedAt,
+			Size:         len(note.Content),
+			SyncConflict: true,
+		})
+	}
+
+	for _, file := range files {
+		note, err := s.loadRevision(noteID, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			log.Printf("Skipping unreadable revision %s of note %s: %v", file.Name(), noteID, err)
+			continue
+		}
+		revisions = append(revisions, &models.Revision{
+			ID:        strings.TrimSuffix(file.Name(), ".json"),
+			NoteID:    noteID,
+			SavedAt:   file.ModTime(),
+			UpdatedAt: note.UpdatedAt,
+			Size:      len(note.Content),
+		})
+	}
+	return revisions, nil
+}
+
+// GetRevision decrypts a stored revision of a note
+func (s *NoteStore) GetRevision(noteID, revisionID string) (*models.Note, error) {
+	if s.key == nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	return s.loadRevision(noteID, revisionID)
+}
+
+// loadRevision reads and decrypts one revision file or sync conflict file
+func (s *NoteStore) loadRevision(noteID, revisionID string) (*models.Note, error) {
+	if !utils.IsValidShortHashFilename(noteID) {
+		return nil, fmt.Errorf("invalid revision")
+	}
+
+	var path string
+	switch {
+	case isSyncConflictRevision(noteID, revisionID):
+		path = filepath.Join(s.dataDir, revisionID+".json")
+	case utils.IsValidShortHashFilename(revisionID):
+		path = filepath.Join(s.historyDir(noteID), revisionID+".json")
+	default:
+		return nil, fmt.Errorf("invalid revision")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("revision not found")
+		}
+		return nil, err
+	}
+	return decodeNote(data, noteID, s.key)
+}
+
+// RestoreRevision makes the content of a revision the current content of the note.
+// The version being replaced is kept as a revision, so a restore can be undone.
+// A restored sync conflict file is resolved and moves into the history.
+func (s *NoteStore) RestoreRevision(noteID, revisionID string, key []byte) (*models.Note, error) {
+	revision, err := s.GetRevision(noteID, revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	note, err := s.editableNote(noteID)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+
+	note.Content = revision.Content
+	note.Images = revision.Images
+	note.UpdatedAt = time.Now()
+	s.bumpVersion(note)
+	s.mutex.Unlock()
+
+	if err := s.archiveRevision(noteID, true); err != nil {
+		log.Printf("Warning: Failed to keep revision of note %s: %v", noteID, err)
+	}
+	if err := s.writeNote(note, key); err != nil {
+		return nil, err
+	}
+
+	if isSyncConflictRevision(noteID, revisionID) {
+		if err := s.archiveSyncConflict(noteID, revisionID); err != nil {
+			log.Printf("Warning: Failed to move sync conflict file %s into history: %v", revisionID, err)
+		}
+	}
+
+	s.notifyNote(ChangeUpdated, note, false)
+	return note, nil
+}
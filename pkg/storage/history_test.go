@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStore returns a store of an empty vault, unlocked with a new key
+func testStore(t *testing.T) (*NoteStore, []byte) {
+	t.Helper()
+	store := NewNoteStore(t.TempDir())
+	key := testKey(t)
+	if err := store.LoadNotes(key); err != nil {
+		t.Fatalf("LoadNotes() error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, key
+}
+
+func TestPruneHistory(t *testing.T) {
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name         string
+		maxRevisions int
+		maxAge       time.Duration
+		ages         []time.Duration // Age of each revision
+		want         int
+	}{
+		{"within limits", 5, 0, []time.Duration{time.Hour, day, 2 * day}, 3},
+		{"too many", 2, 0, []time.Duration{time.Hour, day, 2 * day, 3 * day}, 2},
+		{"too old", 10, 36 * time.Hour, []time.Duration{time.Hour, day, 2 * day, 3 * day}, 2},
+		{"too many and too old", 1, 36 * time.Hour, []time.Duration{time.Hour, day, 2 * day}, 1},
+		{"all too old", 10, time.Minute, []time.Duration{time.Hour, day}, 0},
+		{"default count", 0, 0, make([]time.Duration, DefaultHistoryMaxRevisions+3), DefaultHistoryMaxRevisions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, key := testStore(t)
+			note, err := store.CreateNote("content", key)
+			if err != nil {
+				t.Fatalf("CreateNote() error: %v", err)
+			}
+
+			store.SetHistoryRetention(len(tt.ages)+1, 0)
+			for range tt.ages {
+				if err := store.archiveRevision(note.ID, true); err != nil {
+					t.Fatalf("archiveRevision() error: %v", err)
+				}
+			}
+			files, err := store.revisionFiles(note.ID)
+			if err != nil || len(files) != len(tt.ages) {
+				t.Fatalf("revisionFiles() = %d files, %v; want %d", len(files), err, len(tt.ages))
+			}
+			for i, file := range files {
+				modTime := time.Now().Add(-tt.ages[i] - time.Duration(i)*time.Second)
+				if err := os.Chtimes(filepath.Join(store.historyDir(note.ID), file.Name()), modTime, modTime); err != nil {
+					t.Fatalf("Chtimes() error: %v", err)
+				}
+			}
+
+			store.SetHistoryRetention(tt.maxRevisions, tt.maxAge)
+			if err := store.pruneHistory(note.ID); err != nil {
+				t.Fatalf("pruneHistory() error: %v", err)
+			}
+			files, err = store.revisionFiles(note.ID)
+			if err != nil {
+				t.Fatalf("revisionFiles() error: %v", err)
+			}
+			if len(files) != tt.want {
+				t.Errorf("%d revisions kept, want %d", len(files), tt.want)
+			}
+			for i := 1; i < len(files); i++ {
+				if files[i].ModTime().After(files[i-1].ModTime()) {
+					t.Errorf("revisions are not listed newest first")
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreRevision(t *testing.T) {
+	store, key := testStore(t)
+	note, err := store.CreateNote("first", key)
+	if err != nil {
+		t.Fatalf("CreateNote() error: %v", err)
+	}
+	if _, err := store.UpdateNote(note.ID, "second", key); err != nil {
+		t.Fatalf("UpdateNote() error: %v", err)
+	}
+	// Saves in quick succession share the revision of the first one
+	if _, err := store.UpdateNote(note.ID, "third", key); err != nil {
+		t.Fatalf("UpdateNote() error: %v", err)
+	}
+
+	revisions, err := store.ListRevisions(note.ID)
+	if err != nil {
+		t.Fatalf("ListRevisions() error: %v", err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("ListRevisions() = %d revisions, want 1", len(revisions))
+	}
+	revision, err := store.GetRevision(note.ID, revisions[0].ID)
+	if err != nil {
+		t.Fatalf("GetRevision() error: %v", err)
+	}
+	if revision.Content != "first" {
+		t.Errorf("revision content = %q, want %q", revision.Content, "first")
+	}
+
+	restored, err := store.RestoreRevision(note.ID, revisions[0].ID, key)
+	if err != nil {
+		t.Fatalf("RestoreRevision() error: %v", err)
+	}
+	if restored.Content != "first" {
+		t.Errorf("restored content = %q, want %q", restored.Content, "first")
+	}
+	current, err := store.GetNote(note.ID)
+	if err != nil || current.Content != "first" {
+		t.Errorf("GetNote() after restoring = %v, %v; want the restored content", current, err)
+	}
+
+	// The replaced content is kept, so the restore can be undone
+	revisions, err = store.ListRevisions(note.ID)
+	if err != nil {
+		t.Fatalf("ListRevisions() error: %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("ListRevisions() after restoring = %d revisions, want 2", len(revisions))
+	}
+	replaced, err := store.GetRevision(note.ID, revisions[0].ID)
+	if err != nil || replaced.Content != "third" {
+		t.Errorf("newest revision = %v, %v; want the replaced content %q", replaced, err, "third")
+	}
+}
+
+func TestLoadRevisionRejectsPaths(t *testing.T) {
+	store, key := testStore(t)
+	note, err := store.CreateNote("content", key)
+	if err != nil {
+		t.Fatalf("CreateNote() error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		noteID     string
+		revisionID string
+	}{
+		{"note as its own revision", note.ID, "../" + note.ID},
+		{"invalid note ID", "../notes", "1a2b3c4d"},
+		{"missing revision", note.ID, "1a2b3c4d"},
+		{"empty revision", note.ID, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.GetRevision(tt.noteID, tt.revisionID); err == nil {
+				t.Errorf("GetRevision() succeeded, want an error")
+			}
+		})
+	}
+}
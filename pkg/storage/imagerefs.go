@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"sort"
+
+	"gote/pkg/models"
+)
+
+// indexImages records the images a note shows in the image index. Must be called with the
+// mutex held.
+func (s *NoteStore) indexImages(note *models.Note) {
+	s.unindexImages(note.ID)
+
+	ids := note.ImageReferences()
+	for _, id := range ids {
+		if s.imageNotes[id] == nil {
+			s.imageNotes[id] = make(map[string]bool)
+		}
+		s.imageNotes[id][note.ID] = true
+	}
+	if len(ids) > 0 {
+		s.noteImages[note.ID] = ids
+	}
+}
+
+// unindexImages removes a note from the image index. Must be called with the mutex held.
+func (s *NoteStore) unindexImages(id string) {
+	for _, image := range s.noteImages[id] {
+		delete(s.imageNotes[image], id)
+		if len(s.imageNotes[image]) == 0 {
+			delete(s.imageNotes, image)
+		}
+	}
+	delete(s.noteImages, id)
+}
+
+// NotesShowingImage returns the IDs of the notes that embed an image, including notes in
+// the trash, sorted. No note content is decrypted for it.
+func (s *NoteStore) NotesShowingImage(imageID string) []string {
+	s.mutex.RLock()
+	ids := make([]string, 0, len(s.imageNotes[imageID]))
+	for id := range s.imageNotes[imageID] {
+		ids = append(ids, id)
+	}
+	s.mutex.RUnlock()
+
+	sort.Strings(ids)
+	return ids
+}
+
+// ReferencedImages returns the IDs of all images embedded in a note, including notes in the trash
+func (s *NoteStore) ReferencedImages() map[string]bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	images := make(map[string]bool, len(s.imageNotes))
+	for id := range s.imageNotes {
+		images[id] = true
+	}
+	return images
+}
@@ -2,12 +2,16 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+
 	"gote/pkg/crypto"
 	"gote/pkg/models"
 	"gote/pkg/utils"
@@ -15,13 +19,22 @@ import (
 
 // ImageStore manages encrypted image storage
 type ImageStore struct {
-	dataDir string
-	mutex   sync.RWMutex
-	key     []byte
+	dataDir  string
+	mutex    sync.RWMutex
+	key      []byte
+	watcher  *fsnotify.Watcher
+	watching bool // Whether the watcher goroutine is running
+
+	images  map[string]*models.Image // Metadata of the images on disk, by ID
+	indexed bool                     // Whether images has been loaded from disk
+
+	subscribers      map[int]func(ImageChange) // Notified when images appear or disappear, see SubscribeImages
+	nextSubscriberID int
 }
 
 // EncryptedImage represents an encrypted image for storage
 type EncryptedImage struct {
+	FormatVersion int       `json:"format_version,omitempty"`
 	ID            string    `json:"id"`
 	Filename      string    `json:"filename"`
 	ContentType   string    `json:"content_type"`
@@ -41,20 +54,55 @@ func NewImageStore(dataDir string) *ImageStore {
 		fmt.Printf("Warning: Failed to create images directory %s: %v\n", imageDir, err)
 	}
 
-	return &ImageStore{
-		dataDir: imageDir,
+	store := &ImageStore{
+		dataDir:     imageDir,
+		images:      make(map[string]*models.Image),
+		subscribers: make(map[int]func(ImageChange)),
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Warning: Could not create image watcher: %v", err)
+	} else {
+		store.watcher = watcher
+		if err := watcher.Add(imageDir); err != nil {
+			log.Printf("Warning: Could not watch images directory: %v", err)
+		}
 	}
+
+	return store
 }
 
-// SetKey sets the encryption key for the image store
+// SetKey sets the encryption key for the image store.
+// With a key set, the image index is loaded and the images directory is watched for changes.
 func (is *ImageStore) SetKey(key []byte) {
 	is.mutex.Lock()
-	defer is.mutex.Unlock()
 	is.key = key
+	is.mutex.Unlock()
+
+	if key == nil {
+		return
+	}
+
+	is.startWatching()
+	if err := is.syncIndex(); err != nil {
+		log.Printf("Warning: Failed to load image index: %v", err)
+	}
 }
 
 // StoreImage encrypts and stores an image, returning the image metadata
 func (is *ImageStore) StoreImage(imageData []byte, contentType, filename string) (*models.Image, error) {
+	image, err := is.storeImage(imageData, contentType, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	is.notify(ImageChange{Type: ChangeCreated, ImageID: image.ID, Image: image})
+	return image, nil
+}
+
+// storeImage writes a new image and adds it to the index
+func (is *ImageStore) storeImage(imageData []byte, contentType, filename string) (*models.Image, error) {
 	is.mutex.Lock()
 	defer is.mutex.Unlock()
 
@@ -88,6 +136,7 @@ func (is *ImageStore) StoreImage(imageData []byte, contentType, filename string)
 		Size:          image.Size,
 		EncryptedData: encryptedData,
 		CreatedAt:     image.CreatedAt,
+		FormatVersion: LatestImageFormat,
 	}
 
 	// Save encrypted image to disk
@@ -102,6 +151,7 @@ func (is *ImageStore) StoreImage(imageData []byte, contentType, filename string)
 		return nil, fmt.Errorf("failed to save image: %v", err)
 	}
 
+	is.images[image.ID] = image
 	return image, nil
 }
 
@@ -123,71 +173,115 @@ func (is *ImageStore) GetImage(imageID string) ([]byte, *models.Image, error) {
 	}
 
 	// Decrypt image data directly to bytes
-	imageData, err := crypto.DecryptBytes(encryptedImage.EncryptedData, is.key)
+	imageData, err := decryptImage(encryptedImage, is.key)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to decrypt image: %v", err)
 	}
 
-	// Create image metadata
-	image := &models.Image{
-		ID:          encryptedImage.ID,
-		Filename:    encryptedImage.Filename,
-		ContentType: encryptedImage.ContentType,
-		Size:        encryptedImage.Size,
-		CreatedAt:   encryptedImage.CreatedAt,
-	}
-
-	return imageData, image, nil
+	return imageData, imageFromEnvelope(encryptedImage), nil
 }
 
 // DeleteImage removes an image from storage
 func (is *ImageStore) DeleteImage(imageID string) error {
 	is.mutex.Lock()
-	defer is.mutex.Unlock()
-
 	imagePath := filepath.Join(is.dataDir, fmt.Sprintf("%s.json", imageID))
-	if err := os.Remove(imagePath); err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
+	if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+		is.mutex.Unlock()
 		return err
 	}
+	_, known := is.images[imageID]
+	delete(is.images, imageID)
+	is.mutex.Unlock()
+
+	if known {
+		is.notify(ImageChange{Type: ChangeDeleted, ImageID: imageID})
+	}
 	return nil
 }
 
-// ListImages returns a list of all stored images (metadata only)
+// ListImages returns a list of all stored images (metadata only), oldest first
 func (is *ImageStore) ListImages() ([]*models.Image, error) {
+	is.mutex.RLock()
+	indexed := is.indexed
+	is.mutex.RUnlock()
+
+	if !indexed {
+		if err := is.syncIndex(); err != nil {
+			return nil, err
+		}
+	}
+
 	is.mutex.RLock()
 	defer is.mutex.RUnlock()
+	return is.sortedImages(), nil
+}
+
+// SaveImageDirect encrypts image data with the given key and writes it to disk (for key rotation)
+func (is *ImageStore) SaveImageDirect(image *models.Image, imageData []byte, key []byte) error {
+	encryptedData, err := crypto.EncryptBytes(imageData, key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt image: %v", err)
+	}
+
+	encryptedImage := &EncryptedImage{
+		ID:            image.ID,
+		Filename:      image.Filename,
+		ContentType:   image.ContentType,
+		Size:          image.Size,
+		EncryptedData: encryptedData,
+		CreatedAt:     image.CreatedAt,
+		FormatVersion: LatestImageFormat,
+	}
+
+	imagePath := filepath.Join(is.dataDir, fmt.Sprintf("%s.json", image.ID))
+	return is.saveEncryptedImageToDisk(imagePath, encryptedImage)
+}
+
+// MigrateImages rewrites images stored in an older format and returns how many were rewritten.
+// Files written by a newer version are skipped and listed in unsupported.
+func (is *ImageStore) MigrateImages() (migrated int, unsupported []string, err error) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	if is.key == nil {
+		return 0, nil, fmt.Errorf("encryption key not set")
+	}
 
 	files, err := os.ReadDir(is.dataDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read images directory: %v", err)
+		return 0, nil, fmt.Errorf("failed to read images directory: %v", err)
 	}
 
-	var images []*models.Image
 	for _, file := range files {
-		if filepath.Ext(file.Name()) != ".json" {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
 			continue
 		}
 
 		imagePath := filepath.Join(is.dataDir, file.Name())
 		encryptedImage, err := is.loadEncryptedImageFromDisk(imagePath)
 		if err != nil {
-			continue // Skip corrupted files
+			if errors.Is(err, ErrUnsupportedFormat) {
+				unsupported = append(unsupported, filepath.Join("images", file.Name()))
+			}
+			continue
+		}
+		if encryptedImage.FormatVersion == LatestImageFormat {
+			continue
 		}
 
-		image := &models.Image{
-			ID:          encryptedImage.ID,
-			Filename:    encryptedImage.Filename,
-			ContentType: encryptedImage.ContentType,
-			Size:        encryptedImage.Size,
-			CreatedAt:   encryptedImage.CreatedAt,
+		// The encryption itself is unchanged since V1, so only the version is recorded
+		if _, err := decryptImage(encryptedImage, is.key); err != nil {
+			return migrated, unsupported, fmt.Errorf("failed to decrypt image %s: %v", encryptedImage.ID, err)
 		}
-		images = append(images, image)
+		encryptedImage.FormatVersion = LatestImageFormat
+
+		if err := is.saveEncryptedImageToDisk(imagePath, encryptedImage); err != nil {
+			return migrated, unsupported, fmt.Errorf("failed to save image %s: %v", encryptedImage.ID, err)
+		}
+		migrated++
 	}
 
-	return images, nil
+	return migrated, unsupported, nil
 }
 
 // saveEncryptedImageToDisk saves an encrypted image to disk
@@ -196,7 +290,7 @@ func (is *ImageStore) saveEncryptedImageToDisk(path string, encryptedImage *Encr
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	return utils.WriteFileAtomic(path, data, 0644)
 }
 
 // loadEncryptedImageFromDisk loads an encrypted image from disk
@@ -211,5 +305,9 @@ func (is *ImageStore) loadEncryptedImageFromDisk(path string) (*EncryptedImage,
 		return nil, err
 	}
 
+	if imageVersion(&encryptedImage) > LatestImageFormat {
+		return nil, fmt.Errorf("%w: image format %d", ErrUnsupportedFormat, imageVersion(&encryptedImage))
+	}
+
 	return &encryptedImage, nil
 }
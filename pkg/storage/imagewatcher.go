@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+// ImageChange describes an image that appeared in or disappeared from the store
+type ImageChange struct {
+	Type     string // ChangeCreated or ChangeDeleted
+	ImageID  string
+	Image    *models.Image // The image metadata; nil for deletions
+	External bool          // The change was read from disk rather than made through the store
+}
+
+// SubscribeImages registers a function that is called after an image was added or removed.
+// It is called without the store lock held and must not block for long.
+// The returned function removes the subscription.
+func (is *ImageStore) SubscribeImages(subscriber func(ImageChange)) (unsubscribe func()) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	id := is.nextSubscriberID
+	is.nextSubscriberID++
+	is.subscribers[id] = subscriber
+
+	return func() {
+		is.mutex.Lock()
+		defer is.mutex.Unlock()
+		delete(is.subscribers, id)
+	}
+}
+
+// notify passes a change to all subscribers. Must be called without the mutex held.
+func (is *ImageStore) notify(change ImageChange) {
+	is.mutex.RLock()
+	subscribers := make([]func(ImageChange), 0, len(is.subscribers))
+	for _, subscriber := range is.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	is.mutex.RUnlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(change)
+	}
+}
+
+// startWatching starts the watcher goroutine of the images directory
+func (is *ImageStore) startWatching() {
+	is.mutex.Lock()
+	if is.watcher == nil || is.watching {
+		is.mutex.Unlock()
+		return
+	}
+	is.watching = true
+	is.mutex.Unlock()
+
+	go debounceEvents(is.watcher, isImageFile, is.processWatchBatch)
+}
+
+// Close stops watching the images directory
+func (is *ImageStore) Close() error {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	if is.watcher == nil {
+		return nil
+	}
+	err := is.watcher.Close()
+	is.watcher = nil
+	is.watching = false
+	return err
+}
+
+// isImageFile reports whether a path is an image file
+func isImageFile(path string) bool {
+	filename := filepath.Base(path)
+	return strings.HasSuffix(filename, ".json") && utils.IsValidShortHashFilename(filename)
+}
+
+// imageFromEnvelope returns the metadata of an encrypted image, which is stored in plaintext
+func imageFromEnvelope(encryptedImage *EncryptedImage) *models.Image {
+	return &models.Image{
+		ID:          encryptedImage.ID,
+		Filename:    encryptedImage.Filename,
+		ContentType: encryptedImage.ContentType,
+		Size:        encryptedImage.Size,
+		CreatedAt:   encryptedImage.CreatedAt,
+	}
+}
+
+// loadImageMetadata reads the metadata of the image stored at path. The ID inside the
+// file has to match the file name, otherwise the file is not treated as that image.
+func (is *ImageStore) loadImageMetadata(path string) (*models.Image, error) {
+	encryptedImage, err := is.loadEncryptedImageFromDisk(path)
+	if err != nil {
+		return nil, err
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), ".json")
+	if encryptedImage.ID != id {
+		return nil, fmt.Errorf("image ID %s does not match file name %s.json", encryptedImage.ID, id)
+	}
+	return imageFromEnvelope(encryptedImage), nil
+}
+
+// syncIndex rebuilds the metadata index from the images directory.
+// Differences to the previous index are reported as external changes, except on the first load.
+func (is *ImageStore) syncIndex() error {
+	files, err := os.ReadDir(is.dataDir)
+	if err != nil {
+		return fmt.Errorf("failed to read images directory: %v", err)
+	}
+
+	index := make(map[string]*models.Image)
+	for _, file := range files {
+		if file.IsDir() || !isImageFile(file.Name()) {
+			continue
+		}
+		image, err := is.loadImageMetadata(filepath.Join(is.dataDir, file.Name()))
+		if err != nil {
+			log.Printf("Skipping unreadable image %s: %v", file.Name(), err)
+			continue
+		}
+		index[image.ID] = image
+	}
+
+	var changes []ImageChange
+	is.mutex.Lock()
+	if is.indexed {
+		for id, image := range index {
+			if _, exists := is.images[id]; !exists {
+				changes = append(changes, ImageChange{Type: ChangeCreated, ImageID: id, Image: image, External: true})
+			}
+		}
+		for id := range is.images {
+			if _, exists := index[id]; !exists {
+				changes = append(changes, ImageChange{Type: ChangeDeleted, ImageID: id, External: true})
+			}
+		}
+	}
+	is.images = index
+	is.indexed = true
+	is.mutex.Unlock()
+
+	for _, change := range changes {
+		is.notify(change)
+	}
+	return nil
+}
+
+// processWatchBatch brings the index up to date with a set of settled paths
+func (is *ImageStore) processWatchBatch(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+
+	if len(paths) > watchFullSyncThreshold {
+		if err := is.syncIndex(); err != nil {
+			log.Printf("Error syncing changed images: %v", err)
+		}
+		return
+	}
+
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+
+		image, err := is.loadImageMetadata(path)
+		if err != nil && !os.IsNotExist(err) {
+			// Possibly still being written by a sync client; a later event brings it in
+			log.Printf("Error reading image file %s: %v", path, err)
+			continue
+		}
+
+		is.mutex.Lock()
+		_, known := is.images[id]
+		if image != nil {
+			is.images[id] = image
+		} else {
+			delete(is.images, id)
+		}
+		is.mutex.Unlock()
+
+		// Images added or removed through the store are already in the index
+		switch {
+		case image != nil && !known:
+			log.Printf("Image %s appeared on disk", id)
+			is.notify(ImageChange{Type: ChangeCreated, ImageID: id, Image: image, External: true})
+		case image == nil && known:
+			log.Printf("Image %s was removed from disk", id)
+			is.notify(ImageChange{Type: ChangeDeleted, ImageID: id, External: true})
+		}
+	}
+}
+
+// sortedImages returns the indexed images, oldest first. Must be called with the mutex held.
+func (is *ImageStore) sortedImages() []*models.Image {
+	images := make([]*models.Image, 0, len(is.images))
+	for _, image := range is.images {
+		images = append(images, image)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].CreatedAt.Equal(images[j].CreatedAt) {
+			return images[i].ID < images[j].ID
+		}
+		return images[i].CreatedAt.Before(images[j].CreatedAt)
+	})
+	return images
+}
@@ -0,0 +1,556 @@
+package storage
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gote/pkg/crypto"
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+const (
+	// DefaultContentCacheSize is the number of decrypted notes kept in lazy mode unless configured otherwise
+	DefaultContentCacheSize = 200
+
+	indexDirName = "index"
+
+	// IndexFormatV2 is the on-disk format of the metadata index. It added the images each
+	// note shows; an index in another format is ignored and rebuilt, since its entries lack
+	// metadata the store relies on.
+	IndexFormatV2 = 2
+
+	// Changes to the index are written together once they settled for this long
+	indexSaveDelay = 5 * time.Second
+
+	// How often a note whose file keeps changing while it is read is tried again
+	maxContentAttempts = 3
+)
+
+// errNoteChanged is returned when the file of a partial note holds another version than the
+// one in memory, because the change has not been processed yet
+var errNoteChanged = errors.New("note changed on disk")
+
+// contentCache keeps the decrypted contents of recently used notes, evicting the least
+// recently used ones. Pinned contents are kept until they are unpinned and do not count
+// against the capacity.
+type contentCache struct {
+	mutex    sync.Mutex
+	capacity int
+	order    *list.List // Most recently used first; values are note IDs
+	entries  map[string]*list.Element
+	contents map[string]string
+	pinned   map[string]string
+}
+
+func newContentCache(capacity int) *contentCache {
+	return &contentCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		contents: make(map[string]string),
+		pinned:   make(map[string]string),
+	}
+}
+
+// get returns the content of a note if it is cached
+func (c *contentCache) get(id string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if content, ok := c.pinned[id]; ok {
+		return content, true
+	}
+	element, ok := c.entries[id]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return c.contents[id], true
+}
+
+// put caches the content of a note, evicting the least recently used notes beyond the capacity
+func (c *contentCache) put(id, content string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.pinned[id]; ok {
+		c.pinned[id] = content
+		return
+	}
+	if element, ok := c.entries[id]; ok {
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[id] = c.order.PushFront(id)
+	}
+	c.contents[id] = content
+	c.evict()
+}
+
+// pin keeps the content of a note until it is removed
+func (c *contentCache) pin(id, content string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pinned[id] = content
+	c.removeEntry(id)
+}
+
+// remove drops the content of a note, pinned or not
+func (c *contentCache) remove(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.pinned, id)
+	c.removeEntry(id)
+}
+
+// resize changes the capacity, evicting notes if needed
+func (c *contentCache) resize(capacity int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.capacity = capacity
+	c.evict()
+}
+
+// clear drops all contents
+func (c *contentCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.contents = make(map[string]string)
+	c.pinned = make(map[string]string)
+}
+
+// evict removes the least recently used notes beyond the capacity. Must be called with the mutex held.
+func (c *contentCache) evict() {
+	for c.order.Len() > c.capacity {
+		c.removeEntry(c.order.Back().Value.(string))
+	}
+}
+
+// removeEntry removes a note from the bounded part of the cache. Must be called with the mutex held.
+func (c *contentCache) removeEntry(id string) {
+	if element, ok := c.entries[id]; ok {
+		c.order.Remove(element)
+		delete(c.entries, id)
+		delete(c.contents, id)
+	}
+}
+
+// SetLazyLoading selects whether only note metadata is kept in memory. In lazy mode the
+// content of a note is decrypted when it is needed and kept in a cache of cacheSize notes
+// (0 for DefaultContentCacheSize); notes edited on this device stay decrypted until another
+// device's version includes the edit, so concurrent edits can still be merged. The metadata
+// is kept in an encrypted index, so unlocking only decrypts notes that changed since.
+func (s *NoteStore) SetLazyLoading(enabled bool, cacheSize int) {
+	if cacheSize <= 0 {
+		cacheSize = DefaultContentCacheSize
+	}
+	s.contents.resize(cacheSize)
+
+	s.mutex.Lock()
+	if enabled == s.lazy {
+		s.mutex.Unlock()
+		return
+	}
+	s.lazy = enabled
+
+	if enabled {
+		// Only the metadata stays; contents are decrypted again when needed
+		for _, note := range s.notes {
+			s.putNote(stubOf(note))
+		}
+		s.bases = make(map[string]*mergeBase)
+		s.mutex.Unlock()
+		s.scheduleIndexSave()
+		return
+	}
+
+	var stubs []*models.Note
+	for _, note := range s.notes {
+		if note.Partial {
+			stubs = append(stubs, note)
+		}
+	}
+	s.mutex.Unlock()
+
+	// Load every note again, like a vault opened without lazy loading
+	for _, note := range s.fullNotes(stubs) {
+		s.mutex.Lock()
+		if current := s.notes[note.ID]; current != nil && current.Partial {
+			s.putNote(note)
+		}
+		s.mutex.Unlock()
+	}
+	s.contents.clear()
+	if err := os.Remove(s.indexPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to remove note index: %v", err)
+	}
+}
+
+// stubOf returns the metadata of a note, without its content
+func stubOf(note *models.Note) *models.Note {
+	if note.Partial {
+		return note
+	}
+	stub := *note
+	stub.Content = ""
+	stub.Partial = true
+	stub.Title = models.NoteTitle(note.Content)
+	stub.InlineTags = models.ParseHashtags(note.Content)
+	stub.Links = models.ParseWikiLinks(note.Content)
+	stub.ImageRefs = note.ImageReferences()
+	return &stub
+}
+
+// completeStub returns the complete note described by a stub and its content
+func completeStub(stub *models.Note, content string) *models.Note {
+	full := *stub
+	full.Partial = false
+	full.Title = ""
+	full.InlineTags = nil
+	full.Links = nil
+	full.ImageRefs = nil
+	full.Content = content
+	return &full
+}
+
+// withContent returns a note together with its content. A partial note is completed from
+// the cache or by decrypting its file; the note passed in is left as it is.
+func (s *NoteStore) withContent(note *models.Note) (*models.Note, error) {
+	if !note.Partial {
+		return note, nil
+	}
+
+	if content, ok := s.contents.get(note.ID); ok {
+		return completeStub(note, content), nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dataDir, note.ID+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read note %s: %v", note.ID, err)
+	}
+	disk, err := decodeNote(data, note.ID, s.key)
+	if err != nil {
+		return nil, err
+	}
+	if disk.Versions.Compare(note.Versions) != models.VersionEqual {
+		// Using it would mix two versions
+		return nil, fmt.Errorf("%w: %s", errNoteChanged, note.ID)
+	}
+
+	s.contents.put(note.ID, disk.Content)
+	return completeStub(note, disk.Content), nil
+}
+
+// currentContent completes a partial note like withContent. If its file holds a version the
+// watcher has not processed yet, that version is processed first and returned instead.
+// Must be called without the mutex held.
+func (s *NoteStore) currentContent(note *models.Note) (*models.Note, error) {
+	for attempt := 1; ; attempt++ {
+		full, err := s.withContent(note)
+		if !errors.Is(err, errNoteChanged) || attempt == maxContentAttempts {
+			return full, err
+		}
+		s.handleFileWrite(filepath.Join(s.dataDir, note.ID+".json"))
+
+		s.mutex.RLock()
+		current, exists := s.notes[note.ID]
+		s.mutex.RUnlock()
+		if !exists {
+			return nil, fmt.Errorf("note not found")
+		}
+		note = current
+	}
+}
+
+// editableNote returns a note that is about to be changed in place and saved. In lazy mode
+// the stub in memory is replaced by the complete note until it is written (see writeNote).
+// Must be called with the mutex held for writing; it is released while the note is read
+// from disk, so other readers are not held up by decryption.
+func (s *NoteStore) editableNote(id string) (*models.Note, error) {
+	for {
+		note, exists := s.notes[id]
+		if !exists {
+			return nil, fmt.Errorf("note not found")
+		}
+		if !note.Partial {
+			return note, nil
+		}
+
+		s.mutex.Unlock()
+		full, err := s.currentContent(note)
+		s.mutex.Lock()
+		if err != nil {
+			return nil, err
+		}
+
+		// Install the note only if it is still the version that was read
+		current, exists := s.notes[id]
+		if exists && current.Partial && current.Versions.Compare(full.Versions) == models.VersionEqual {
+			full = completeStub(current, full.Content)
+			s.putNote(full)
+			return full, nil
+		}
+	}
+}
+
+// localVersion returns the complete local copy of a note without reading its file, which may
+// already hold another device's version. ok is false if the content is not available.
+func (s *NoteStore) localVersion(note *models.Note) (local *models.Note, ok bool) {
+	if !note.Partial {
+		return note, true
+	}
+	content, ok := s.contents.get(note.ID)
+	if !ok {
+		return nil, false
+	}
+	return completeStub(note, content), true
+}
+
+// keepFromDisk returns what is kept in memory of a note that was read from disk.
+// Must be called with the mutex held.
+func (s *NoteStore) keepFromDisk(note *models.Note) *models.Note {
+	if !s.lazy {
+		return note
+	}
+	// The version from disk replaces any local edit, which no longer has to be held back
+	s.contents.remove(note.ID)
+	return stubOf(note)
+}
+
+// fullNotes completes partial notes, decrypting files in parallel without filling the cache.
+// Notes that cannot be completed are left out.
+func (s *NoteStore) fullNotes(notes []*models.Note) []*models.Note {
+	result := make([]*models.Note, 0, len(notes))
+	var files []noteFile
+	stubs := make(map[string]*models.Note)
+	for _, note := range notes {
+		if local, ok := s.localVersion(note); ok {
+			result = append(result, local)
+			continue
+		}
+		files = append(files, noteFile{path: filepath.Join(s.dataDir, note.ID+".json"), id: note.ID})
+		stubs[note.ID] = note
+	}
+
+	decodeNoteFiles(files, s.key, func(file noteFile, disk *models.Note) {
+		stub := stubs[file.id]
+		if disk == nil || disk.Versions.Compare(stub.Versions) != models.VersionEqual {
+			return
+		}
+		result = append(result, completeStub(stub, disk.Content))
+	})
+	return result
+}
+
+// GetAllNotesWithContent returns all notes with their contents, newest first.
+// In lazy mode this decrypts every note that is not cached.
+func (s *NoteStore) GetAllNotesWithContent() []*models.Note {
+	notes := s.GetAllNotes()
+
+	s.mutex.RLock()
+	lazy := s.lazy
+	s.mutex.RUnlock()
+	if !lazy {
+		return notes
+	}
+
+	full := s.fullNotes(notes)
+	sortNotesByUpdate(full)
+	return full
+}
+
+// indexEntry is what the index keeps of a note: the stamp of its file and its metadata
+type indexEntry struct {
+	ModTime          time.Time            `json:"mod_time"`
+	Size             int64                `json:"size"`
+	Title            string               `json:"title"`
+	Category         models.NoteCategory  `json:"category"`
+	OriginalCategory models.NoteCategory  `json:"original_category,omitempty"`
+	Images           []models.Image       `json:"images,omitempty"`
+	CreatedAt        time.Time            `json:"created_at"`
+	UpdatedAt        time.Time            `json:"updated_at"`
+	Versions         models.VersionVector `json:"versions,omitempty"`
+	ConflictOf       string               `json:"conflict_of,omitempty"`
+	NeedsReview      bool                 `json:"needs_review,omitempty"`
+	Tags             []string             `json:"tags,omitempty"`
+	Notebook         string               `json:"notebook,omitempty"`
+	TrashedWith      string               `json:"trashed_with,omitempty"`
+	InlineTags       []string             `json:"inline_tags,omitempty"`
+	Links            []string             `json:"links,omitempty"`
+	ImageRefs        []string             `json:"image_refs,omitempty"`
+}
+
+// indexEnvelope is the plaintext part of the index file
+type indexEnvelope struct {
+	FormatVersion int    `json:"format_version"`
+	DeviceID      string `json:"device_id"`
+	EncryptedData string `json:"encrypted_data"`
+}
+
+func newIndexEntry(note *models.Note, stamp fileStamp) *indexEntry {
+	stub := stubOf(note)
+	return &indexEntry{
+		ModTime:          stamp.modTime,
+		Size:             stamp.size,
+		Title:            stub.Title,
+		Category:         stub.Category,
+		OriginalCategory: stub.OriginalCategory,
+		Images:           stub.Images,
+		CreatedAt:        stub.CreatedAt,
+		UpdatedAt:        stub.UpdatedAt,
+		Versions:         stub.Versions,
+		ConflictOf:       stub.ConflictOf,
+		NeedsReview:      stub.NeedsReview,
+		Tags:             stub.Tags,
+		Notebook:         stub.Notebook,
+		TrashedWith:      stub.TrashedWith,
+		InlineTags:       stub.InlineTags,
+		Links:            stub.Links,
+		ImageRefs:        stub.ImageRefs,
+	}
+}
+
+func (e *indexEntry) stamp() fileStamp {
+	return fileStamp{modTime: e.ModTime, size: e.Size}
+}
+
+// stub returns the note the entry describes
+func (e *indexEntry) stub(id string) *models.Note {
+	return &models.Note{
+		ID:               id,
+		Category:         e.Category,
+		OriginalCategory: e.OriginalCategory,
+		Images:           e.Images,
+		CreatedAt:        e.CreatedAt,
+		UpdatedAt:        e.UpdatedAt,
+		Versions:         e.Versions,
+		ConflictOf:       e.ConflictOf,
+		NeedsReview:      e.NeedsReview,
+		Tags:             e.Tags,
+		Notebook:         e.Notebook,
+		TrashedWith:      e.TrashedWith,
+		Partial:          true,
+		Title:            e.Title,
+		InlineTags:       e.InlineTags,
+		Links:            e.Links,
+		ImageRefs:        e.ImageRefs,
+	}
+}
+
+// indexPath returns the file of this device's index. File stamps are only meaningful on
+// the device that recorded them, so every device keeps its own index.
+func (s *NoteStore) indexPath() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return filepath.Join(s.dataDir, indexDirName, s.deviceID+".json")
+}
+
+// indexAssociatedData binds the index to the device it belongs to
+func indexAssociatedData(deviceID string) []byte {
+	return []byte(strings.Join([]string{"gote-index", fmt.Sprint(IndexFormatV2), deviceID}, "\x00"))
+}
+
+// loadIndex reads the index written by an earlier session. A missing or unreadable
+// index only means that all notes are decrypted once.
+func (s *NoteStore) loadIndex() map[string]*indexEntry {
+	data, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to read note index: %v", err)
+		}
+		return nil
+	}
+
+	var envelope indexEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.FormatVersion != IndexFormatV2 {
+		log.Printf("Ignoring note index in an unknown format")
+		return nil
+	}
+	decrypted, err := crypto.DecryptBytesWithAAD(envelope.EncryptedData, s.key, indexAssociatedData(envelope.DeviceID))
+	if err != nil {
+		// E.g. written before the vault key was rotated
+		log.Printf("Ignoring note index that cannot be decrypted: %v", err)
+		return nil
+	}
+
+	var entries map[string]*indexEntry
+	if err := json.Unmarshal(decrypted, &entries); err != nil {
+		log.Printf("Ignoring unreadable note index: %v", err)
+		return nil
+	}
+	return entries
+}
+
+// scheduleIndexSave writes the index once changes settled. Does nothing unless in lazy mode.
+func (s *NoteStore) scheduleIndexSave() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.lazy || s.key == nil {
+		return
+	}
+	if s.indexTimer != nil {
+		s.indexTimer.Reset(indexSaveDelay)
+		return
+	}
+	s.indexTimer = time.AfterFunc(indexSaveDelay, func() {
+		if err := s.saveIndex(); err != nil {
+			log.Printf("Warning: Failed to save note index: %v", err)
+		}
+	})
+}
+
+// saveIndex writes the metadata and file stamps of all notes to this device's index
+func (s *NoteStore) saveIndex() error {
+	path := s.indexPath()
+
+	s.mutex.RLock()
+	if !s.lazy || s.key == nil {
+		s.mutex.RUnlock()
+		return nil
+	}
+	entries := make(map[string]*indexEntry, len(s.notes))
+	for id, note := range s.notes {
+		if stamp, ok := s.fileStamps[filepath.Join(s.dataDir, id+".json")]; ok {
+			entries[id] = newIndexEntry(note, stamp)
+		}
+	}
+	key, deviceID := s.key, s.deviceID
+	s.mutex.RUnlock()
+
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	encryptedData, err := crypto.EncryptBytesWithAAD(payload, key, indexAssociatedData(deviceID))
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(indexEnvelope{
+		FormatVersion: IndexFormatV2,
+		DeviceID:      deviceID,
+		EncryptedData: encryptedData,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %v", err)
+	}
+	return utils.WriteFileAtomic(path, data, 0644)
+}
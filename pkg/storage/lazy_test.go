@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentCache(t *testing.T) {
+	type op struct {
+		action string // put, get, pin, remove or resize
+		id     string
+		size   int // Capacity for resize
+	}
+
+	tests := []struct {
+		name     string
+		capacity int
+		ops      []op
+		want     []string // Cached notes afterwards
+	}{
+		{"within capacity", 3, []op{{"put", "a", 0}, {"put", "b", 0}}, []string{"a", "b"}},
+		{"least recently put evicted", 2, []op{{"put", "a", 0}, {"put", "b", 0}, {"put", "c", 0}}, []string{"b", "c"}},
+		{"get keeps a note", 2, []op{{"put", "a", 0}, {"put", "b", 0}, {"get", "a", 0}, {"put", "c", 0}}, []string{"a", "c"}},
+		{"put again keeps a note", 2, []op{{"put", "a", 0}, {"put", "b", 0}, {"put", "a", 0}, {"put", "c", 0}}, []string{"a", "c"}},
+		{"pinned beyond capacity", 1, []op{{"pin", "a", 0}, {"pin", "b", 0}, {"put", "c", 0}}, []string{"a", "b", "c"}},
+		{"pinned not evicted", 1, []op{{"put", "a", 0}, {"pin", "a", 0}, {"put", "b", 0}, {"put", "c", 0}}, []string{"a", "c"}},
+		{"remove pinned", 2, []op{{"pin", "a", 0}, {"put", "b", 0}, {"remove", "a", 0}}, []string{"b"}},
+		{"remove cached", 2, []op{{"put", "a", 0}, {"put", "b", 0}, {"remove", "b", 0}}, []string{"a"}},
+		{"shrink", 3, []op{{"put", "a", 0}, {"put", "b", 0}, {"put", "c", 0}, {"resize", "", 1}}, []string{"c"}},
+		{"shrink keeps pinned", 3, []op{{"pin", "a", 0}, {"put", "b", 0}, {"put", "c", 0}, {"resize", "", 1}}, []string{"a", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newContentCache(tt.capacity)
+			for _, o := range tt.ops {
+				switch o.action {
+				case "put":
+					cache.put(o.id, "content of "+o.id)
+				case "get":
+					cache.get(o.id)
+				case "pin":
+					cache.pin(o.id, "content of "+o.id)
+				case "remove":
+					cache.remove(o.id)
+				case "resize":
+					cache.resize(o.size)
+				}
+			}
+
+			var got []string
+			for _, id := range []string{"a", "b", "c"} {
+				content, ok := cache.get(id)
+				if !ok {
+					continue
+				}
+				if content != "content of "+id {
+					t.Errorf("get(%q) = %q", id, content)
+				}
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("cached %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentCachePinUpdates(t *testing.T) {
+	cache := newContentCache(1)
+	cache.pin("a", "pinned")
+	cache.put("a", "newer")
+	if content, _ := cache.get("a"); content != "newer" {
+		t.Errorf("get() = %q after a put of a pinned note, want %q", content, "newer")
+	}
+	if cache.order.Len() != 0 {
+		t.Errorf("a pinned note takes %d places of the capacity", cache.order.Len())
+	}
+}
+
+func TestLazyLoading(t *testing.T) {
+	writer, key := testStore(t)
+	var ids []string
+	for i := 0; i < 5; i++ {
+		note, err := writer.CreateNote(fmt.Sprintf("note %d", i), key)
+		if err != nil {
+			t.Fatalf("CreateNote() error: %v", err)
+		}
+		ids = append(ids, note.ID)
+	}
+
+	lazy := NewNoteStore(writer.dataDir)
+	t.Cleanup(func() { lazy.Close() })
+	lazy.SetDeviceID("lazy")
+	lazy.SetLazyLoading(true, 2)
+	// Without a watcher changes are only found when a note is read
+	lazy.SetPollingMode(true, time.Hour)
+	if err := lazy.LoadNotes(key); err != nil {
+		t.Fatalf("LoadNotes() error: %v", err)
+	}
+
+	for i, id := range ids {
+		note, err := lazy.GetNote(id)
+		if err != nil {
+			t.Fatalf("GetNote() error: %v", err)
+		}
+		if want := fmt.Sprintf("note %d", i); note.Content != want {
+			t.Errorf("GetNote() = %q, want %q", note.Content, want)
+		}
+	}
+	if cached := lazy.contents.order.Len(); cached > 2 {
+		t.Errorf("%d notes cached, want at most 2", cached)
+	}
+
+	// A change not processed yet is picked up when the note is read
+	if _, err := writer.UpdateNote(ids[0], "changed elsewhere", key); err != nil {
+		t.Fatalf("UpdateNote() error: %v", err)
+	}
+	note, err := lazy.GetNote(ids[0])
+	if err != nil || note.Content != "changed elsewhere" {
+		t.Errorf("GetNote() = %v, %v; want the changed content", note, err)
+	}
+
+	// Edits stay decrypted until another device's version includes them
+	if _, err := lazy.UpdateNote(ids[1], "edited lazily", key); err != nil {
+		t.Fatalf("UpdateNote() error: %v", err)
+	}
+	for _, id := range ids[2:] {
+		if _, err := lazy.GetNote(id); err != nil {
+			t.Fatalf("GetNote() error: %v", err)
+		}
+	}
+	if content, ok := lazy.contents.get(ids[1]); !ok || content != "edited lazily" {
+		t.Errorf("edited note evicted from the cache: %q, %v", content, ok)
+	}
+}
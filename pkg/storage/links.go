@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gote/pkg/models"
+)
+
+// indexLinks records the links and title of a note in the link index. Must be called with
+// the mutex held.
+func (s *NoteStore) indexLinks(note *models.Note) {
+	s.unindexLinks(note.ID)
+
+	targets := note.LinkTargets()
+	for _, target := range targets {
+		if s.linkSources[target] == nil {
+			s.linkSources[target] = make(map[string]bool)
+		}
+		s.linkSources[target][note.ID] = true
+	}
+	if len(targets) > 0 {
+		s.noteLinks[note.ID] = targets
+	}
+
+	if title := models.NormalizeLinkTarget(note.DisplayTitle()); title != "" {
+		if s.titleNotes[title] == nil {
+			s.titleNotes[title] = make(map[string]bool)
+		}
+		s.titleNotes[title][note.ID] = true
+		s.noteTitles[note.ID] = title
+	}
+}
+
+// unindexLinks removes a note from the link index. Must be called with the mutex held.
+func (s *NoteStore) unindexLinks(id string) {
+	for _, target := range s.noteLinks[id] {
+		delete(s.linkSources[target], id)
+		if len(s.linkSources[target]) == 0 {
+			delete(s.linkSources, target)
+		}
+	}
+	delete(s.noteLinks, id)
+
+	if title, exists := s.noteTitles[id]; exists {
+		delete(s.titleNotes[title], id)
+		if len(s.titleNotes[title]) == 0 {
+			delete(s.titleNotes, title)
+		}
+		delete(s.noteTitles, id)
+	}
+}
+
+// resolveLink returns the ID of the note outside the trash a normalized link target refers
+// to, or "" if there is none. A note ID wins over titles; of several notes with the title,
+// the oldest one is the target. Must be called with the mutex held.
+func (s *NoteStore) resolveLink(target string) string {
+	if note, exists := s.notes[target]; exists && note.Category != models.CategoryTrash {
+		return note.ID
+	}
+
+	var resolved *models.Note
+	for id := range s.titleNotes[target] {
+		note := s.notes[id]
+		if note.Category == models.CategoryTrash {
+			continue
+		}
+		if resolved == nil || note.CreatedAt.Before(resolved.CreatedAt) ||
+			(note.CreatedAt.Equal(resolved.CreatedAt) && note.ID < resolved.ID) {
+			resolved = note
+		}
+	}
+	if resolved == nil {
+		return ""
+	}
+	return resolved.ID
+}
+
+// GetBacklinks returns the notes outside the trash that link to a note, newest first
+func (s *NoteStore) GetBacklinks(id string) ([]*models.Note, error) {
+	s.mutex.RLock()
+	if _, exists := s.notes[id]; !exists {
+		s.mutex.RUnlock()
+		return nil, fmt.Errorf("note not found")
+	}
+
+	seen := make(map[string]bool)
+	notes := make([]*models.Note, 0)
+	for _, target := range []string{id, s.noteTitles[id]} {
+		if target == "" || s.resolveLink(target) != id {
+			continue
+		}
+		for source := range s.linkSources[target] {
+			note := s.notes[source]
+			if seen[source] || source == id || note.Category == models.CategoryTrash {
+				continue
+			}
+			seen[source] = true
+			notes = append(notes, note)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sortNotesByUpdate(notes)
+	return notes, nil
+}
+
+// GetNotesLinkingTo returns the notes outside the trash with a [[link]] to target, whether
+// or not it refers to a note, newest first
+func (s *NoteStore) GetNotesLinkingTo(target string) []*models.Note {
+	target = models.NormalizeLinkTarget(target)
+
+	s.mutex.RLock()
+	notes := make([]*models.Note, 0, len(s.linkSources[target]))
+	for source := range s.linkSources[target] {
+		if note := s.notes[source]; note.Category != models.CategoryTrash {
+			notes = append(notes, note)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sortNotesByUpdate(notes)
+	return notes
+}
+
+// GetUnresolvedLinks returns the link targets of notes outside the trash that match no
+// note, sorted by target
+func (s *NoteStore) GetUnresolvedLinks() []models.UnresolvedLink {
+	s.mutex.RLock()
+	unresolved := make([]models.UnresolvedLink, 0)
+	for target, sources := range s.linkSources {
+		if s.resolveLink(target) != "" {
+			continue
+		}
+		var ids []string
+		for source := range sources {
+			if s.notes[source].Category != models.CategoryTrash {
+				ids = append(ids, source)
+			}
+		}
+		if len(ids) > 0 {
+			sort.Strings(ids)
+			unresolved = append(unresolved, models.UnresolvedLink{Target: target, NoteIDs: ids})
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(unresolved, func(i, j int) bool {
+		return unresolved[i].Target < unresolved[j].Target
+	})
+	return unresolved
+}
+
+// GetNoteGraph returns the notes outside the trash as nodes and the resolved links between
+// them as edges
+func (s *NoteStore) GetNoteGraph() models.NoteGraph {
+	graph := models.NoteGraph{Nodes: []models.GraphNode{}, Edges: []models.GraphEdge{}}
+
+	s.mutex.RLock()
+	for _, note := range s.notes {
+		if note.Category == models.CategoryTrash {
+			continue
+		}
+		graph.Nodes = append(graph.Nodes, models.GraphNode{
+			ID:       note.ID,
+			Title:    note.DisplayTitle(),
+			Category: note.Category,
+		})
+
+		linked := make(map[string]bool)
+		for _, target := range s.noteLinks[note.ID] {
+			resolved := s.resolveLink(target)
+			if resolved == "" || resolved == note.ID || linked[resolved] {
+				continue
+			}
+			linked[resolved] = true
+			graph.Edges = append(graph.Edges, models.GraphEdge{Source: note.ID, Target: resolved})
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Source != graph.Edges[j].Source {
+			return graph.Edges[i].Source < graph.Edges[j].Source
+		}
+		return graph.Edges[i].Target < graph.Edges[j].Target
+	})
+	return graph
+}
+
+// RenameLinks points every [[from]] link to to instead, for instance after the note it
+// referred to got a new title. Labels of the links are kept. It returns the number of
+// notes changed.
+func (s *NoteStore) RenameLinks(from, to string, key []byte) (int, error) {
+	source := models.NormalizeLinkTarget(from)
+	to = strings.TrimSpace(to)
+	if source == "" {
+		return 0, fmt.Errorf("link target cannot be empty")
+	}
+	if to == "" || strings.ContainsAny(to, "[]|\n") {
+		return 0, fmt.Errorf("invalid link target %q", to)
+	}
+
+	s.mutex.RLock()
+	ids := make([]string, 0, len(s.linkSources[source]))
+	for id := range s.linkSources[source] {
+		ids = append(ids, id)
+	}
+	s.mutex.RUnlock()
+
+	changed := 0
+	for _, id := range ids {
+		note, err := s.replaceLinks(id, source, to, key)
+		if err != nil {
+			return changed, fmt.Errorf("failed to update note %s: %v", id, err)
+		}
+		if note != nil {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// replaceLinks replaces the target of links in one note and saves it. It returns nil if the
+// note did not change.
+func (s *NoteStore) replaceLinks(id, source, to string, key []byte) (*models.Note, error) {
+	s.mutex.Lock()
+	note, err := s.editableNote(id)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+
+	content := models.RewriteWikiLinks(note.Content, func(target string) (string, bool) {
+		return to, models.NormalizeLinkTarget(target) == source
+	})
+	if content == note.Content {
+		s.mutex.Unlock()
+		return nil, nil
+	}
+
+	note.Content = content
+	note.UpdatedAt = time.Now()
+	s.mutex.Unlock()
+
+	if err := s.saveNote(note, key); err != nil {
+		return nil, err
+	}
+
+	s.notifyNote(ChangeUpdated, note, false)
+	return note, nil
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gote/pkg/models"
+)
+
+func TestResolveLink(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	notes := []*models.Note{
+		{ID: "1a2b3c4d", Content: "# Meeting\nnewer", CreatedAt: created.Add(time.Hour)},
+		{ID: "2b3c4d5e", Content: "# Meeting\noldest", CreatedAt: created},
+		{ID: "3c4d5e6f", Content: "# Plans\nsame time, higher ID", CreatedAt: created},
+		{ID: "0a1b2c3d", Content: "# Plans\nsame time, lower ID", CreatedAt: created},
+		{ID: "4d5e6f7a", Content: "# Old\nin trash", CreatedAt: created, Category: models.CategoryTrash},
+		{ID: "5e6f7a8b", Content: "# 1a2b3c4d\na title that is another note's ID", CreatedAt: created},
+		{ID: "6f7a8b9c", Content: "# Draft\nin trash", CreatedAt: created, Category: models.CategoryTrash},
+		{ID: "7a8b9c0d", Content: "# Draft\noutside the trash", CreatedAt: created.Add(time.Hour)},
+	}
+
+	store := NewNoteStore(t.TempDir())
+	store.mutex.Lock()
+	for _, note := range notes {
+		if note.Category == "" {
+			note.Category = models.CategoryPrivate
+		}
+		store.putNote(note)
+	}
+	store.mutex.Unlock()
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"title", "meeting", "2b3c4d5e"},
+		{"title tie on creation time", "plans", "0a1b2c3d"},
+		{"note ID", "3c4d5e6f", "3c4d5e6f"},
+		{"note ID before title", "1a2b3c4d", "1a2b3c4d"},
+		{"only in trash", "old", ""},
+		{"note in trash by ID", "4d5e6f7a", ""},
+		{"trash skipped", "draft", "7a8b9c0d"},
+		{"unknown", "nothing", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.mutex.RLock()
+			got := store.resolveLink(tt.target)
+			store.mutex.RUnlock()
+			if got != tt.want {
+				t.Errorf("resolveLink(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+// noteContents returns the contents of notes, sorted
+func noteContents(notes []*models.Note) string {
+	contents := make([]string, len(notes))
+	for i, note := range notes {
+		contents[i] = note.Content
+	}
+	sort.Strings(contents)
+	return strings.Join(contents, " | ")
+}
+
+func TestRenameLinks(t *testing.T) {
+	store, key := testStore(t)
+	target, err := store.CreateNote("# Roadmap\nplans", key)
+	if err != nil {
+		t.Fatalf("CreateNote() error: %v", err)
+	}
+	for _, content := range []string{"see [[Roadmap]]", "see [[roadmap|the plan]]", "see [[Other]]"} {
+		if _, err := store.CreateNote(content, key); err != nil {
+			t.Fatalf("CreateNote() error: %v", err)
+		}
+	}
+	if _, err := store.CreateNote("by ID [["+target.ID+"]]", key); err != nil {
+		t.Fatalf("CreateNote() error: %v", err)
+	}
+
+	backlinks, err := store.GetBacklinks(target.ID)
+	if err != nil {
+		t.Fatalf("GetBacklinks() error: %v", err)
+	}
+	if got, want := noteContents(backlinks), "by ID [["+target.ID+"]] | see [[Roadmap]] | see [[roadmap|the plan]]"; got != want {
+		t.Errorf("GetBacklinks() = %q, want %q", got, want)
+	}
+
+	if _, err := store.UpdateNote(target.ID, "# Plan 2025\nplans", key); err != nil {
+		t.Fatalf("UpdateNote() error: %v", err)
+	}
+	changed, err := store.RenameLinks("Roadmap", "Plan 2025", key)
+	if err != nil {
+		t.Fatalf("RenameLinks() error: %v", err)
+	}
+	if changed != 2 {
+		t.Errorf("RenameLinks() changed %d notes, want 2", changed)
+	}
+	if got, want := noteContents(store.GetNotesLinkingTo("plan 2025")), "see [[Plan 2025]] | see [[Plan 2025|the plan]]"; got != want {
+		t.Errorf("GetNotesLinkingTo() = %q, want %q", got, want)
+	}
+	backlinks, err = store.GetBacklinks(target.ID)
+	if err != nil || len(backlinks) != 3 {
+		t.Errorf("GetBacklinks() after renaming = %d notes, %v; want 3", len(backlinks), err)
+	}
+
+	if _, err := store.RenameLinks("Other", "a|b", key); err == nil {
+		t.Errorf("RenameLinks() to an invalid target succeeded")
+	}
+}
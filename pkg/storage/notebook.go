@@ -0,0 +1,646 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+const (
+	notebookDirName = "notebooks"
+	notebookRecord  = "notebook"
+
+	maxNotebookNameLength = 100
+)
+
+// encodeNotebook encrypts a notebook; everything but its ID is part of the ciphertext
+func encodeNotebook(notebook *models.Notebook, key []byte) ([]byte, error) {
+	return encodeRecord(notebookRecord, notebook.ID, notebook, key)
+}
+
+// decodeNotebook decrypts a notebook file whose name gives the expected notebook ID
+func decodeNotebook(data []byte, expectedID string, key []byte) (*models.Notebook, error) {
+	var notebook models.Notebook
+	if err := decodeRecord(notebookRecord, data, expectedID, key, &notebook); err != nil {
+		return nil, err
+	}
+	notebook.ID = expectedID
+	return &notebook, nil
+}
+
+// isValidNotebookFilename reports whether a file in the notebooks directory holds a notebook
+func isValidNotebookFilename(filename string) bool {
+	return filepath.Ext(filename) == ".json" && utils.IsValidShortHashFilename(filename)
+}
+
+// notebookPath returns the file of a notebook
+func (s *NoteStore) notebookPath(id string) string {
+	return filepath.Join(s.dataDir, notebookDirName, id+".json")
+}
+
+// writeNotebook encrypts a notebook, writes it to disk and updates the cached notebooks.
+// Must be called with the notebook mutex held.
+func (s *NoteStore) writeNotebook(notebook *models.Notebook) error {
+	if s.key == nil {
+		return fmt.Errorf("not authenticated")
+	}
+
+	data, err := encodeNotebook(notebook, s.key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dataDir, notebookDirName), 0755); err != nil {
+		return fmt.Errorf("failed to create notebook directory: %v", err)
+	}
+	if err := utils.WriteFileAtomic(s.notebookPath(notebook.ID), data, 0644); err != nil {
+		return fmt.Errorf("failed to save notebook: %v", err)
+	}
+	if s.notebooks != nil {
+		copied := *notebook
+		s.notebooks[notebook.ID] = &copied
+	}
+	return nil
+}
+
+// removeNotebook deletes a notebook from disk and from the cached notebooks.
+// Must be called with the notebook mutex held.
+func (s *NoteStore) removeNotebook(id string) error {
+	if err := os.Remove(s.notebookPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.notebooks, id)
+	return nil
+}
+
+// loadNotebooks returns a copy of all notebooks, keyed by ID. They are read from disk
+// once, and again after the watcher saw them change. Safe to call with the mutex held.
+func (s *NoteStore) loadNotebooks() (map[string]*models.Notebook, error) {
+	s.notebookMutex.Lock()
+	defer s.notebookMutex.Unlock()
+	return s.cachedNotebooks()
+}
+
+// cachedNotebooks is loadNotebooks for callers that hold the notebook mutex, so that what
+// they check cannot change before they write
+func (s *NoteStore) cachedNotebooks() (map[string]*models.Notebook, error) {
+	if s.key == nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+
+	if s.notebooks == nil {
+		notebooks, err := s.readNotebooks()
+		if err != nil {
+			return nil, err
+		}
+		repairNotebookTree(notebooks)
+		s.notebooks = notebooks
+	}
+
+	notebooks := make(map[string]*models.Notebook, len(s.notebooks))
+	for id, notebook := range s.notebooks {
+		copied := *notebook
+		notebooks[id] = &copied
+	}
+	return notebooks, nil
+}
+
+// readNotebooks reads and decrypts all notebook files
+func (s *NoteStore) readNotebooks() (map[string]*models.Notebook, error) {
+	notebooks := make(map[string]*models.Notebook)
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, notebookDirName))
+	if os.IsNotExist(err) {
+		return notebooks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notebooks: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isValidNotebookFilename(entry.Name()) {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		data, err := os.ReadFile(filepath.Join(s.dataDir, notebookDirName, entry.Name()))
+		if err != nil {
+			log.Printf("Error reading notebook %s: %v", id, err)
+			continue
+		}
+		notebook, err := decodeNotebook(data, id, s.key)
+		if err != nil {
+			log.Printf("Ignoring notebook %s: %v", id, err)
+			continue
+		}
+		notebooks[notebook.ID] = notebook
+	}
+	return notebooks, nil
+}
+
+// invalidateNotebooks makes the next loadNotebooks read the notebooks from disk again
+func (s *NoteStore) invalidateNotebooks() {
+	s.notebookMutex.Lock()
+	s.notebooks = nil
+	s.notebookMutex.Unlock()
+}
+
+// repairNotebookTree makes notebooks whose parent is gone top-level, and breaks cycles that
+// moves on two devices can create. The repair is not saved; the next move of such a
+// notebook saves it.
+func repairNotebookTree(notebooks map[string]*models.Notebook) {
+	ids := make([]string, 0, len(notebooks))
+	for id := range notebooks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if _, exists := notebooks[notebooks[id].ParentID]; !exists {
+			notebooks[id].ParentID = ""
+		}
+	}
+
+	for _, id := range ids {
+		seen := map[string]bool{id: true}
+		for parent := notebooks[id].ParentID; parent != ""; parent = notebooks[parent].ParentID {
+			if parent == id {
+				notebooks[id].ParentID = ""
+				break
+			}
+			if seen[parent] {
+				break // A cycle above this notebook, broken at one of its own notebooks
+			}
+			seen[parent] = true
+		}
+	}
+}
+
+// notebookInTrash reports whether a notebook or one of the notebooks above it is in trash
+func notebookInTrash(id string, notebooks map[string]*models.Notebook) bool {
+	for notebook := notebooks[id]; notebook != nil; notebook = notebooks[notebook.ParentID] {
+		if notebook.Trashed {
+			return true
+		}
+	}
+	return false
+}
+
+// notebookOrNone returns the notebook a note belongs in: its own if it exists and is not
+// in trash, otherwise none. Notes can be in notebooks deleted on another device.
+func notebookOrNone(id string, notebooks map[string]*models.Notebook) string {
+	if _, exists := notebooks[id]; !exists || notebookInTrash(id, notebooks) {
+		return ""
+	}
+	return id
+}
+
+// notebookSubtree returns the IDs of a notebook and all notebooks below it
+func notebookSubtree(id string, notebooks map[string]*models.Notebook) map[string]bool {
+	children := make(map[string][]string)
+	for _, notebook := range notebooks {
+		children[notebook.ParentID] = append(children[notebook.ParentID], notebook.ID)
+	}
+
+	subtree := map[string]bool{id: true}
+	pending := []string{id}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, child := range children[current] {
+			subtree[child] = true
+			pending = append(pending, child)
+		}
+	}
+	return subtree
+}
+
+// sortNotebooks sorts notebooks so that parents come before their children, and siblings
+// by their sort order, then by name
+func sortNotebooks(notebooks map[string]*models.Notebook, include func(*models.Notebook) bool) []*models.Notebook {
+	children := make(map[string][]*models.Notebook)
+	for _, notebook := range notebooks {
+		children[notebook.ParentID] = append(children[notebook.ParentID], notebook)
+	}
+	for _, siblings := range children {
+		sort.Slice(siblings, func(i, j int) bool {
+			if siblings[i].SortOrder != siblings[j].SortOrder {
+				return siblings[i].SortOrder < siblings[j].SortOrder
+			}
+			return strings.ToLower(siblings[i].Name) < strings.ToLower(siblings[j].Name)
+		})
+	}
+
+	sorted := make([]*models.Notebook, 0, len(notebooks))
+	var walk func(parentID string)
+	walk = func(parentID string) {
+		for _, notebook := range children[parentID] {
+			if include(notebook) {
+				sorted = append(sorted, notebook)
+			}
+			walk(notebook.ID)
+		}
+	}
+	walk("")
+	return sorted
+}
+
+// ListNotebooks returns the notebooks outside the trash, parents before their children
+func (s *NoteStore) ListNotebooks() ([]*models.Notebook, error) {
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	return sortNotebooks(notebooks, func(notebook *models.Notebook) bool {
+		return !notebookInTrash(notebook.ID, notebooks)
+	}), nil
+}
+
+// ListTrashedNotebooks returns the notebooks that were moved to trash. Notebooks that are
+// in trash because a notebook above them is are not listed.
+func (s *NoteStore) ListTrashedNotebooks() ([]*models.Notebook, error) {
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	return sortNotebooks(notebooks, func(notebook *models.Notebook) bool {
+		return notebook.Trashed
+	}), nil
+}
+
+// GetNotebookSubtree returns a notebook and all notebooks below it, parents before their children
+func (s *NoteStore) GetNotebookSubtree(id string) ([]*models.Notebook, error) {
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := notebooks[id]; !exists {
+		return nil, fmt.Errorf("notebook not found")
+	}
+
+	subtree := notebookSubtree(id, notebooks)
+	return sortNotebooks(notebooks, func(notebook *models.Notebook) bool {
+		return subtree[notebook.ID]
+	}), nil
+}
+
+// GetNotesInNotebook returns the notes outside the trash in a notebook, newest first. With
+// recursive set the notes of the notebooks below it are included. An empty ID stands for
+// the notes without a notebook.
+func (s *NoteStore) GetNotesInNotebook(id string, recursive bool) ([]*models.Note, error) {
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{id: true}
+	if id != "" {
+		if _, exists := notebooks[id]; !exists {
+			return nil, fmt.Errorf("notebook not found")
+		}
+		if recursive {
+			wanted = notebookSubtree(id, notebooks)
+		}
+	}
+
+	s.mutex.RLock()
+	notes := make([]*models.Note, 0)
+	for _, note := range s.notes {
+		if note.Category == models.CategoryTrash {
+			continue
+		}
+		if (id == "" && recursive) || wanted[notebookOrNone(note.Notebook, notebooks)] {
+			notes = append(notes, note)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sortNotesByUpdate(notes)
+	return notes, nil
+}
+
+// validateNotebookName checks the name of a notebook, which no other notebook in the same
+// parent may have. It returns the trimmed name.
+func validateNotebookName(name, parentID string, notebooks map[string]*models.Notebook, id string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("notebook name cannot be empty")
+	}
+	if len([]rune(name)) > maxNotebookNameLength {
+		return "", fmt.Errorf("notebook name cannot be longer than %d characters", maxNotebookNameLength)
+	}
+	for _, other := range notebooks {
+		if other.ID != id && other.ParentID == parentID && !other.Trashed && strings.EqualFold(other.Name, name) {
+			return "", fmt.Errorf("a notebook named %q already exists here", other.Name)
+		}
+	}
+	return name, nil
+}
+
+// checkNotebookParent checks that a notebook can be put into parentID: an existing
+// notebook outside the trash, or none for the top level
+func checkNotebookParent(parentID string, notebooks map[string]*models.Notebook) error {
+	if parentID == "" {
+		return nil
+	}
+	if _, exists := notebooks[parentID]; !exists {
+		return fmt.Errorf("parent notebook not found")
+	}
+	if notebookInTrash(parentID, notebooks) {
+		return fmt.Errorf("parent notebook is in trash")
+	}
+	return nil
+}
+
+// nextNotebookSortOrder returns the sort order that puts a notebook after its siblings
+func nextNotebookSortOrder(parentID string, notebooks map[string]*models.Notebook) int {
+	sortOrder := 0
+	for _, other := range notebooks {
+		if other.ParentID == parentID {
+			sortOrder = max(sortOrder, other.SortOrder+1)
+		}
+	}
+	return sortOrder
+}
+
+// CreateNotebook adds a notebook at the end of a parent notebook, or of the top level if
+// parentID is empty
+func (s *NoteStore) CreateNotebook(name, parentID string) (*models.Notebook, error) {
+	s.notebookMutex.Lock()
+	defer s.notebookMutex.Unlock()
+
+	notebooks, err := s.cachedNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNotebookParent(parentID, notebooks); err != nil {
+		return nil, err
+	}
+	name, err = validateNotebookName(name, parentID, notebooks, "")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notebook := &models.Notebook{
+		ID:        utils.GenerateShortUUID(),
+		Name:      name,
+		ParentID:  parentID,
+		SortOrder: nextNotebookSortOrder(parentID, notebooks),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.writeNotebook(notebook); err != nil {
+		return nil, err
+	}
+	return notebook, nil
+}
+
+// RenameNotebook renames a notebook
+func (s *NoteStore) RenameNotebook(id, name string) (*models.Notebook, error) {
+	s.notebookMutex.Lock()
+	defer s.notebookMutex.Unlock()
+
+	notebooks, err := s.cachedNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	notebook, exists := notebooks[id]
+	if !exists {
+		return nil, fmt.Errorf("notebook not found")
+	}
+	name, err = validateNotebookName(name, notebook.ParentID, notebooks, id)
+	if err != nil {
+		return nil, err
+	}
+
+	notebook.Name = name
+	notebook.UpdatedAt = time.Now()
+	if err := s.writeNotebook(notebook); err != nil {
+		return nil, err
+	}
+	return notebook, nil
+}
+
+// MoveNotebook moves a notebook, with everything in it, to the end of another notebook,
+// or of the top level if parentID is empty
+func (s *NoteStore) MoveNotebook(id, parentID string) (*models.Notebook, error) {
+	s.notebookMutex.Lock()
+	defer s.notebookMutex.Unlock()
+
+	notebooks, err := s.cachedNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	notebook, exists := notebooks[id]
+	if !exists {
+		return nil, fmt.Errorf("notebook not found")
+	}
+	if notebookInTrash(id, notebooks) {
+		return nil, fmt.Errorf("notebook is in trash")
+	}
+	if err := checkNotebookParent(parentID, notebooks); err != nil {
+		return nil, err
+	}
+	if parentID != "" && notebookSubtree(id, notebooks)[parentID] {
+		return nil, fmt.Errorf("a notebook cannot be moved into itself")
+	}
+	if _, err := validateNotebookName(notebook.Name, parentID, notebooks, id); err != nil {
+		return nil, err
+	}
+	if notebook.ParentID == parentID {
+		return notebook, nil
+	}
+
+	notebook.ParentID = parentID
+	notebook.SortOrder = nextNotebookSortOrder(parentID, notebooks)
+	notebook.UpdatedAt = time.Now()
+	if err := s.writeNotebook(notebook); err != nil {
+		return nil, err
+	}
+	return notebook, nil
+}
+
+// MoveNoteToNotebook moves a note into a notebook, or out of any notebook if notebookID is empty
+func (s *NoteStore) MoveNoteToNotebook(noteID, notebookID string, key []byte) (*models.Note, error) {
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	if notebookID != "" {
+		if _, exists := notebooks[notebookID]; !exists {
+			return nil, fmt.Errorf("notebook not found")
+		}
+		if notebookInTrash(notebookID, notebooks) {
+			return nil, fmt.Errorf("notebook is in trash")
+		}
+	}
+
+	s.mutex.Lock()
+	note, err := s.editableNote(noteID)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	if note.Category == models.CategoryTrash {
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("note is in trash")
+	}
+
+	note.Notebook = notebookID
+	note.UpdatedAt = time.Now()
+	s.mutex.Unlock()
+
+	if err := s.saveNote(note, key); err != nil {
+		return nil, err
+	}
+
+	s.notifyNote(ChangeUpdated, note, false)
+	return note, nil
+}
+
+// updateNotebook applies change to the current version of a notebook and saves it. Notes
+// are moved before their notebook, which may have changed in the meantime.
+func (s *NoteStore) updateNotebook(id string, change func(notebook *models.Notebook, notebooks map[string]*models.Notebook)) error {
+	s.notebookMutex.Lock()
+	defer s.notebookMutex.Unlock()
+
+	notebooks, err := s.cachedNotebooks()
+	if err != nil {
+		return err
+	}
+	notebook, exists := notebooks[id]
+	if !exists {
+		return fmt.Errorf("notebook not found")
+	}
+
+	change(notebook, notebooks)
+	notebook.UpdatedAt = time.Now()
+	return s.writeNotebook(notebook)
+}
+
+// MoveNotebookToTrash moves a notebook to trash together with the notebooks below it and
+// all notes in them. It returns the number of notes moved to trash.
+func (s *NoteStore) MoveNotebookToTrash(id string, key []byte) (int, error) {
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return 0, err
+	}
+	if _, exists := notebooks[id]; !exists {
+		return 0, fmt.Errorf("notebook not found")
+	}
+	if notebookInTrash(id, notebooks) {
+		return 0, fmt.Errorf("notebook is already in trash")
+	}
+
+	// Move the notes first; if that fails half-way the notebook is still there
+	subtree := notebookSubtree(id, notebooks)
+	trashed := 0
+	for _, note := range s.GetAllNotes() {
+		if note.Category == models.CategoryTrash || !subtree[note.Notebook] {
+			continue
+		}
+		if _, err := s.moveToTrash(note.ID, id, key); err != nil {
+			return trashed, fmt.Errorf("failed to move note %s to trash: %v", note.ID, err)
+		}
+		trashed++
+	}
+
+	err = s.updateNotebook(id, func(notebook *models.Notebook, notebooks map[string]*models.Notebook) {
+		notebook.Trashed = true
+	})
+	if err != nil {
+		return trashed, err
+	}
+	return trashed, nil
+}
+
+// RestoreNotebook takes a notebook out of trash together with the notes that went to trash
+// with it. A notebook whose parent is still in trash is restored to the top level.
+// It returns the number of notes restored.
+func (s *NoteStore) RestoreNotebook(id string, key []byte) (int, error) {
+	categories, err := s.loadCategories()
+	if err != nil {
+		return 0, err
+	}
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return 0, err
+	}
+	notebook, exists := notebooks[id]
+	if !exists {
+		return 0, fmt.Errorf("notebook not found")
+	}
+	if !notebook.Trashed {
+		return 0, fmt.Errorf("notebook is not in trash")
+	}
+
+	restore := func(notebook *models.Notebook, notebooks map[string]*models.Notebook) {
+		notebook.Trashed = false
+		if notebookInTrash(notebook.ParentID, notebooks) {
+			notebook.SortOrder = nextNotebookSortOrder("", notebooks)
+			notebook.ParentID = ""
+		}
+	}
+	restore(notebook, notebooks)
+
+	// Restore the notes first; if that fails half-way the notebook is still in trash
+	restored := 0
+	for _, note := range s.GetNotesByCategory(models.CategoryTrash) {
+		if note.TrashedWith != id {
+			continue
+		}
+		if _, err := s.restoreFromTrash(note.ID, categories, notebooks, key); err != nil {
+			return restored, fmt.Errorf("failed to restore note %s: %v", note.ID, err)
+		}
+		restored++
+	}
+
+	if err := s.updateNotebook(id, restore); err != nil {
+		return restored, err
+	}
+	return restored, nil
+}
+
+// PermanentlyDeleteNotebook deletes a notebook in trash, the notebooks below it and the
+// notes that went to trash with them. Notes that were put in trash on their own stay
+// there. It returns the number of notes deleted.
+func (s *NoteStore) PermanentlyDeleteNotebook(id string) (int, error) {
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return 0, err
+	}
+	notebook, exists := notebooks[id]
+	if !exists {
+		return 0, fmt.Errorf("notebook not found")
+	}
+	if !notebook.Trashed {
+		return 0, fmt.Errorf("notebook must be in trash to be permanently deleted")
+	}
+
+	subtree := notebookSubtree(id, notebooks)
+	deleted := 0
+	for _, note := range s.GetNotesByCategory(models.CategoryTrash) {
+		if note.TrashedWith == "" || !subtree[note.TrashedWith] {
+			continue
+		}
+		if err := s.PermanentlyDeleteNote(note.ID); err != nil {
+			return deleted, fmt.Errorf("failed to delete note %s: %v", note.ID, err)
+		}
+		deleted++
+	}
+
+	s.notebookMutex.Lock()
+	defer s.notebookMutex.Unlock()
+	for notebookID := range subtree {
+		if err := s.removeNotebook(notebookID); err != nil {
+			return deleted, fmt.Errorf("failed to remove notebook: %v", err)
+		}
+	}
+	return deleted, nil
+}
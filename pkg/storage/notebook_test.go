@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"gote/pkg/models"
+)
+
+func TestRepairNotebookTree(t *testing.T) {
+	tests := []struct {
+		name    string
+		parents map[string]string // Parent of each notebook before the repair
+		want    map[string]string
+	}{
+		{
+			name:    "tree",
+			parents: map[string]string{"a": "", "b": "a", "c": "b"},
+			want:    map[string]string{"a": "", "b": "a", "c": "b"},
+		},
+		{
+			name:    "missing parent",
+			parents: map[string]string{"a": "gone", "b": "a"},
+			want:    map[string]string{"a": "", "b": "a"},
+		},
+		{
+			name:    "own parent",
+			parents: map[string]string{"a": "a", "b": "a"},
+			want:    map[string]string{"a": "", "b": "a"},
+		},
+		{
+			name:    "two notebooks in each other",
+			parents: map[string]string{"a": "b", "b": "a"},
+			want:    map[string]string{"a": "", "b": "a"},
+		},
+		{
+			name:    "cycle of three",
+			parents: map[string]string{"a": "c", "b": "a", "c": "b"},
+			want:    map[string]string{"a": "", "b": "a", "c": "b"},
+		},
+		{
+			name:    "notebooks below a cycle",
+			parents: map[string]string{"a": "x", "b": "y", "x": "y", "y": "x"},
+			want:    map[string]string{"a": "x", "b": "y", "x": "", "y": "x"},
+		},
+		{
+			name:    "two cycles",
+			parents: map[string]string{"a": "b", "b": "a", "c": "d", "d": "c"},
+			want:    map[string]string{"a": "", "b": "a", "c": "", "d": "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notebooks := make(map[string]*models.Notebook, len(tt.parents))
+			for id, parentID := range tt.parents {
+				notebooks[id] = &models.Notebook{ID: id, ParentID: parentID}
+			}
+
+			repairNotebookTree(notebooks)
+
+			for id, want := range tt.want {
+				if got := notebooks[id].ParentID; got != want {
+					t.Errorf("parent of %s = %q, want %q", id, got, want)
+				}
+			}
+			// Every notebook reaches the top level
+			for id := range notebooks {
+				steps := 0
+				for parent := notebooks[id].ParentID; parent != ""; parent = notebooks[parent].ParentID {
+					if steps++; steps > len(notebooks) {
+						t.Fatalf("notebook %s is still in a cycle", id)
+					}
+				}
+			}
+		})
+	}
+}
+
+// notebookNames returns the names of the notebooks outside the trash, parents first
+func notebookNames(t *testing.T, store *NoteStore) string {
+	t.Helper()
+	notebooks, err := store.ListNotebooks()
+	if err != nil {
+		t.Fatalf("ListNotebooks() error: %v", err)
+	}
+	names := make([]string, len(notebooks))
+	for i, notebook := range notebooks {
+		names[i] = notebook.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestNotebookChecks(t *testing.T) {
+	store, key := testStore(t)
+	projects, err := store.CreateNotebook("Projects", "")
+	if err != nil {
+		t.Fatalf("CreateNotebook() error: %v", err)
+	}
+	gote, err := store.CreateNotebook("Gote", projects.ID)
+	if err != nil {
+		t.Fatalf("CreateNotebook() error: %v", err)
+	}
+	docs, err := store.CreateNotebook("Docs", gote.ID)
+	if err != nil {
+		t.Fatalf("CreateNotebook() error: %v", err)
+	}
+	archive, err := store.CreateNotebook("Archive", "")
+	if err != nil {
+		t.Fatalf("CreateNotebook() error: %v", err)
+	}
+	if _, err := store.MoveNotebookToTrash(archive.ID, key); err != nil {
+		t.Fatalf("MoveNotebookToTrash() error: %v", err)
+	}
+	if got := notebookNames(t, store); got != "Projects, Gote, Docs" {
+		t.Errorf("ListNotebooks() = %q", got)
+	}
+
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{"same name in the same notebook", func() error {
+			_, err := store.CreateNotebook("gote", projects.ID)
+			return err
+		}},
+		{"empty name", func() error {
+			_, err := store.CreateNotebook("  ", "")
+			return err
+		}},
+		{"into a notebook in trash", func() error {
+			_, err := store.CreateNotebook("Old", archive.ID)
+			return err
+		}},
+		{"into a missing notebook", func() error {
+			_, err := store.CreateNotebook("Old", "ffffffff")
+			return err
+		}},
+		{"rename to a sibling's name", func() error {
+			_, err := store.RenameNotebook(archive.ID, "projects")
+			return err
+		}},
+		{"move into itself", func() error {
+			_, err := store.MoveNotebook(projects.ID, projects.ID)
+			return err
+		}},
+		{"move into a notebook below it", func() error {
+			_, err := store.MoveNotebook(projects.ID, docs.ID)
+			return err
+		}},
+		{"move a notebook in trash", func() error {
+			_, err := store.MoveNotebook(archive.ID, "")
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.change(); err == nil {
+				t.Errorf("change succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := store.MoveNotebook(docs.ID, ""); err != nil {
+		t.Fatalf("MoveNotebook() error: %v", err)
+	}
+	if _, err := store.RenameNotebook(gote.ID, "Gote app"); err != nil {
+		t.Fatalf("RenameNotebook() error: %v", err)
+	}
+	if got := notebookNames(t, store); got != "Projects, Gote app, Docs" {
+		t.Errorf("ListNotebooks() after moving and renaming = %q", got)
+	}
+}
+
+func TestCreateNotebookConcurrently(t *testing.T) {
+	store, _ := testStore(t)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	created := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.CreateNotebook("Inbox", ""); err == nil {
+				mutex.Lock()
+				created++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("%d notebooks with the same name created, want 1", created)
+	}
+}
+
+func TestNotebookTrash(t *testing.T) {
+	store, key := testStore(t)
+	projects, err := store.CreateNotebook("Projects", "")
+	if err != nil {
+		t.Fatalf("CreateNotebook() error: %v", err)
+	}
+	gote, err := store.CreateNotebook("Gote", projects.ID)
+	if err != nil {
+		t.Fatalf("CreateNotebook() error: %v", err)
+	}
+	docs, err := store.CreateNotebook("Docs", gote.ID)
+	if err != nil {
+		t.Fatalf("CreateNotebook() error: %v", err)
+	}
+
+	notes := make(map[string]*models.Note)
+	for name, notebookID := range map[string]string{"plan": projects.ID, "readme": docs.ID, "todo": gote.ID, "alone": gote.ID} {
+		note, err := store.CreateNote(name, key)
+		if err != nil {
+			t.Fatalf("CreateNote() error: %v", err)
+		}
+		if _, err := store.MoveNoteToNotebook(note.ID, notebookID, key); err != nil {
+			t.Fatalf("MoveNoteToNotebook() error: %v", err)
+		}
+		notes[name] = note
+	}
+	// A note put in trash on its own does not leave it with its notebook
+	if _, err := store.MoveToTrash(notes["alone"].ID, key); err != nil {
+		t.Fatalf("MoveToTrash() error: %v", err)
+	}
+
+	trashed, err := store.MoveNotebookToTrash(gote.ID, key)
+	if err != nil {
+		t.Fatalf("MoveNotebookToTrash() error: %v", err)
+	}
+	if trashed != 2 {
+		t.Errorf("MoveNotebookToTrash() moved %d notes, want 2", trashed)
+	}
+	if got := notebookNames(t, store); got != "Projects" {
+		t.Errorf("ListNotebooks() after moving to trash = %q", got)
+	}
+	if _, err := store.MoveNoteToNotebook(notes["plan"].ID, docs.ID, key); err == nil {
+		t.Errorf("a note was moved into a notebook in trash")
+	}
+
+	// With its parent in trash too, a restored notebook goes to the top level
+	if _, err := store.MoveNotebookToTrash(projects.ID, key); err != nil {
+		t.Fatalf("MoveNotebookToTrash() error: %v", err)
+	}
+	restored, err := store.RestoreNotebook(gote.ID, key)
+	if err != nil {
+		t.Fatalf("RestoreNotebook() error: %v", err)
+	}
+	if restored != 2 {
+		t.Errorf("RestoreNotebook() restored %d notes, want 2", restored)
+	}
+	if got := notebookNames(t, store); got != "Gote, Docs" {
+		t.Errorf("ListNotebooks() after restoring = %q", got)
+	}
+	if note, _ := store.GetNote(notes["alone"].ID); note == nil || note.Category != models.CategoryTrash {
+		t.Errorf("a note trashed on its own was restored with its notebook: %+v", note)
+	}
+	inGote, err := store.GetNotesInNotebook(gote.ID, true)
+	if err != nil {
+		t.Fatalf("GetNotesInNotebook() error: %v", err)
+	}
+	var names []string
+	for _, note := range inGote {
+		names = append(names, note.Content)
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "readme todo" {
+		t.Errorf("GetNotesInNotebook() = %v after restoring", names)
+	}
+
+	deleted, err := store.PermanentlyDeleteNotebook(projects.ID)
+	if err != nil {
+		t.Fatalf("PermanentlyDeleteNotebook() error: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("PermanentlyDeleteNotebook() deleted %d notes, want 1", deleted)
+	}
+	if _, err := store.GetNote(notes["plan"].ID); err == nil {
+		t.Errorf("a note of the deleted notebook still exists")
+	}
+	if _, err := store.PermanentlyDeleteNotebook(gote.ID); err == nil {
+		t.Errorf("a notebook outside the trash was permanently deleted")
+	}
+}
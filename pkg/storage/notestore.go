@@ -1,7 +1,8 @@
 package storage
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -13,7 +14,6 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 
-	"gote/pkg/crypto"
 	"gote/pkg/models"
 	"gote/pkg/utils"
 )
@@ -26,17 +26,79 @@ type NoteStore struct {
 	watcher          *fsnotify.Watcher
 	key              []byte
 	lastSync         time.Time
-	fileModTimes     map[string]time.Time
-	pendingDeletions map[string]bool // Track app-initiated deletions
+	fileStamps       map[string]fileStamp // Modification time and size of each note file as last read or written
+	pendingDeletions map[string]bool      // Track app-initiated deletions
+	watching         bool                 // Whether the watcher goroutine is running
+	suspended        bool                 // Changes on disk are ignored until notes are loaded again, see SuspendSync
+	encryptMetadata  bool                 // Whether notes are written with their metadata inside the ciphertext
+	deviceID         string               // Recorded in the version of every note saved here
+
+	bases             map[string]*mergeBase // Last version of each note read from disk, the ancestor for merges
+	syncConflictsSeen map[string]bool       // Sync conflict files the user was already told about
+
+	subscribers      map[int]func(Change) // Notified after every change, see Subscribe
+	nextSubscriberID int
+
+	pollingOnly   bool          // Find changes on disk by polling only, see SetPollingMode
+	pollInterval  time.Duration // Time between two reconciliations when polling
+	reconciling   bool          // Whether the reconciliation goroutine is running
+	reconcileWake chan struct{} // Restarts the wait for the next reconciliation
+	stopReconcile chan struct{} // Closed by Close
+
+	historyMaxRevisions int           // Revisions kept per note
+	historyMaxAge       time.Duration // Revisions older than this are removed; 0 keeps them
+	tombstoneRetention  time.Duration // Deletion records older than this are removed
+
+	lazy       bool                   // Keep only note metadata in memory, see SetLazyLoading
+	contents   *contentCache          // Decrypted contents of notes in lazy mode
+	index      map[string]*indexEntry // Index read when unlocking, until the first sync used it
+	indexTimer *time.Timer            // Pending write of the index
+
+	tagNotes map[string]map[string]bool // IDs of the notes with each tag, see putNote
+	noteTags map[string][]string        // Tags of each note as last indexed
+
+	linkSources map[string]map[string]bool // IDs of the notes linking to each normalized target, see putNote
+	noteLinks   map[string][]string        // Link targets of each note as last indexed
+	titleNotes  map[string]map[string]bool // IDs of the notes with each normalized title
+	noteTitles  map[string]string          // Normalized title of each note as last indexed
+
+	imageNotes map[string]map[string]bool // IDs of the notes showing each image, see putNote
+	noteImages map[string][]string        // Images of each note as last indexed
+
+	categoryMutex      sync.Mutex                               // Guards the cached categories; taken after the mutex
+	categories         map[models.NoteCategory]*models.Category // Categories as last read or written, nil until read, see loadCategories
+	implicitCategories bool                                     // The cached categories are the defaults of a vault without category files
+
+	notebookMutex sync.Mutex                  // Serialises notebook changes and guards the cached notebooks; taken after the mutex
+	notebooks     map[string]*models.Notebook // Notebooks as last read or written, nil until read, see loadNotebooks
 }
 
 // NewNoteStore creates a new note store instance
 func NewNoteStore(dataDir string) *NoteStore {
 	store := &NoteStore{
-		dataDir:          dataDir,
-		notes:            make(map[string]*models.Note),
-		fileModTimes:     make(map[string]time.Time),
-		pendingDeletions: make(map[string]bool),
+		dataDir:           dataDir,
+		notes:             make(map[string]*models.Note),
+		tagNotes:          make(map[string]map[string]bool),
+		noteTags:          make(map[string][]string),
+		linkSources:       make(map[string]map[string]bool),
+		noteLinks:         make(map[string][]string),
+		titleNotes:        make(map[string]map[string]bool),
+		noteTitles:        make(map[string]string),
+		imageNotes:        make(map[string]map[string]bool),
+		noteImages:        make(map[string][]string),
+		fileStamps:        make(map[string]fileStamp),
+		pendingDeletions:  make(map[string]bool),
+		bases:             make(map[string]*mergeBase),
+		syncConflictsSeen: make(map[string]bool),
+		subscribers:       make(map[int]func(Change)),
+		deviceID:          utils.GenerateShortUUID(),
+
+		pollInterval:        DefaultPollInterval,
+		reconcileWake:       make(chan struct{}, 1),
+		stopReconcile:       make(chan struct{}),
+		historyMaxRevisions: DefaultHistoryMaxRevisions,
+		tombstoneRetention:  DefaultTombstoneRetention,
+		contents:            newContentCache(DefaultContentCacheSize),
 	}
 
 	// Create data directory if it doesn't exist
@@ -45,90 +107,121 @@ func NewNoteStore(dataDir string) *NoteStore {
 	}
 
 	// Initialize file system watcher
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Printf("Warning: Could not create file watcher: %v", err)
-	} else {
-		store.watcher = watcher
-
-		// Add data directory to watcher
-		if err := watcher.Add(dataDir); err != nil {
-			log.Printf("Warning: Could not watch data directory: %v", err)
-		}
-	}
+	store.watcher = newWatcher(dataDir)
 
 	return store
 }
 
+// putNote keeps a note in memory and updates the indexes. Must be called with the mutex held.
+func (s *NoteStore) putNote(note *models.Note) {
+	s.notes[note.ID] = note
+	s.indexTags(note)
+	s.indexLinks(note)
+	s.indexImages(note)
+}
+
+// dropNote removes a note from memory and from the indexes. Must be called with the mutex held.
+func (s *NoteStore) dropNote(id string) {
+	delete(s.notes, id)
+	s.unindexTags(id)
+	s.unindexLinks(id)
+	s.unindexImages(id)
+}
+
 // GetDataDir returns the data directory path
 func (s *NoteStore) GetDataDir() string {
 	return s.dataDir
 }
 
+// SetMetadataEncryption selects whether notes are saved with their timestamps inside the padded ciphertext.
+// Existing files keep their format until they are rewritten.
+func (s *NoteStore) SetMetadataEncryption(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.encryptMetadata = enabled
+}
+
+// noteFormat returns the on-disk format used for saving notes
+func (s *NoteStore) noteFormat() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.encryptMetadata {
+		return models.NoteFormatV3
+	}
+	return models.NoteFormatV2
+}
+
 // LoadNotes loads notes from disk with the provided encryption key
 func (s *NoteStore) LoadNotes(key []byte) error {
+	return s.LoadNotesWithProgress(key, nil)
+}
+
+// LoadNotesWithProgress loads notes like LoadNotes and calls progress with the number of
+// notes decrypted so far. Notes can be read from the store while it is still loading.
+func (s *NoteStore) LoadNotesWithProgress(key []byte, progress func(loaded, total int)) error {
 	s.mutex.Lock()
+	if s.key != nil && !bytes.Equal(s.key, key) {
+		// Files read under the old key have to be read again, even if they look unchanged
+		s.fileStamps = make(map[string]fileStamp)
+	}
 	s.key = key
+	s.suspended = false
+	lazy := s.lazy
 	s.mutex.Unlock()
+	s.invalidateRecords()
+
+	if lazy {
+		index := s.loadIndex()
+		s.mutex.Lock()
+		s.index = index
+		s.mutex.Unlock()
+	}
 
-	// Start file watching
+	// Start file watching, and reconciling for changes the watcher misses
 	s.startWatching()
+	s.startReconciling()
 
 	// Load notes from disk
-	return s.syncFromDisk()
+	_, err := s.syncFromDisk(progress)
+	return err
+}
+
+// SuspendSync stops processing changes on disk until notes are loaded again. Used while
+// the vault is re-encrypted, when files under the new key appear before the store has it.
+func (s *NoteStore) SuspendSync() {
+	s.mutex.Lock()
+	s.suspended = true
+	s.mutex.Unlock()
+}
+
+// ResumeSync processes changes on disk again after SuspendSync, without a new key. Changes
+// made in the meantime are picked up by a reconciliation.
+func (s *NoteStore) ResumeSync() {
+	s.mutex.Lock()
+	s.suspended = false
+	s.mutex.Unlock()
+	go s.reconcile()
+}
+
+// syncSuspended reports whether changes on disk are currently ignored
+func (s *NoteStore) syncSuspended() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.suspended
 }
 
 // startWatching starts the file system watcher goroutine
 func (s *NoteStore) startWatching() {
-	if s.watcher == nil {
+	s.mutex.Lock()
+	if s.watcher == nil || s.watching {
+		s.mutex.Unlock()
 		return
 	}
+	s.watching = true
+	watcher := s.watcher
+	s.mutex.Unlock()
 
-	go func() {
-		for {
-			select {
-			case event, ok := <-s.watcher.Events:
-				if !ok {
-					return
-				}
-
-				// Only process .json files with valid short hash names
-				if !strings.HasSuffix(event.Name, ".json") {
-					continue
-				}
-
-				filename := filepath.Base(event.Name)
-				if !utils.IsValidShortHashFilename(filename) {
-					log.Printf("Ignoring file with invalid name pattern: %s", filename)
-					continue
-				}
-
-				log.Printf("File event: %s %s", event.Op, event.Name)
-
-				switch {
-				case event.Op&fsnotify.Create == fsnotify.Create:
-					s.handleFileCreate(event.Name)
-				case event.Op&fsnotify.Write == fsnotify.Write:
-					s.handleFileWrite(event.Name)
-				case event.Op&fsnotify.Remove == fsnotify.Remove:
-					s.handleFileRemove(event.Name)
-				case event.Op&fsnotify.Rename == fsnotify.Rename:
-					s.handleFileRemove(event.Name)
-				}
-
-			case err, ok := <-s.watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Printf("Watcher error: %v", err)
-			}
-		}
-	}()
-}
-
-// handleFileCreate handles new file creation
-func (s *NoteStore) handleFileCreate(filePath string) {
-	s.handleFileWrite(filePath)
+	go s.watchLoop(watcher)
 }
 
 // handleFileWrite handles file modifications
@@ -146,16 +239,16 @@ func (s *NoteStore) handleFileWrite(filePath string) {
 
 	// Check if this is a change we need to process
 	s.mutex.Lock()
-	lastModTime, exists := s.fileModTimes[filePath]
-	currentModTime := fileInfo.ModTime()
+	lastStamp, exists := s.fileStamps[filePath]
+	currentStamp := stampOf(fileInfo)
 
-	// If we already have this modification time, skip (probably our own write)
-	if exists && !currentModTime.After(lastModTime) {
+	// If the file looks the same as when it was last read or written, skip (probably our own write)
+	if exists && lastStamp.matches(currentStamp) {
 		s.mutex.Unlock()
 		return
 	}
 
-	s.fileModTimes[filePath] = currentModTime
+	s.fileStamps[filePath] = currentStamp
 	s.mutex.Unlock()
 
 	// Load the file
@@ -165,60 +258,60 @@ func (s *NoteStore) handleFileWrite(filePath string) {
 		return
 	}
 
-	var encryptedNote models.EncryptedNote
-	if err := json.Unmarshal(data, &encryptedNote); err != nil {
-		log.Printf("Error unmarshalling changed file %s: %v", filePath, err)
-		return
-	}
-
-	// Decrypt the note content
-	decryptedContent, err := crypto.Decrypt(encryptedNote.EncryptedData, s.key)
+	noteID := strings.TrimSuffix(filepath.Base(filePath), ".json")
+	note, err := decodeNote(data, noteID, s.key)
 	if err != nil {
-		log.Printf("Error decrypting changed file %s: %v", filePath, err)
+		log.Printf("Error decoding changed file %s: %v", filePath, err)
+		if errors.Is(err, ErrUnsupportedFormat) {
+			// Drop the stale copy so saving it cannot overwrite the newer file
+			s.mutex.Lock()
+			_, existed := s.notes[noteID]
+			s.dropNote(noteID)
+			s.mutex.Unlock()
+			if existed {
+				s.notifyID(ChangeDeleted, noteID, true)
+			}
+			return
+		}
+		s.notifyID(ChangeCorrupted, noteID, true)
 		return
 	}
 
-	note := &models.Note{
-		ID:        encryptedNote.ID,
-		CreatedAt: encryptedNote.CreatedAt,
-		UpdatedAt: encryptedNote.UpdatedAt,
-	}
-
-	// Try to parse as new JSON format first
-	var noteData struct {
-		Content  string              `json:"content"`
-		Category models.NoteCategory `json:"category"`
-		Images   []models.Image      `json:"images,omitempty"`
-	}
-
-	if err := json.Unmarshal([]byte(decryptedContent), &noteData); err == nil {
-		// New format - use parsed data
-		note.Content = noteData.Content
-		note.Category = noteData.Category
-		note.Images = noteData.Images
-
-		// Ensure category is set (handle empty category in new format)
-		if note.Category == "" {
-			note.Category = models.CategoryPrivate
+	if t := s.loadTombstone(note.ID); t != nil {
+		if t.covers(note) {
+			// A device that missed the deletion wrote its old copy back
+			log.Printf("Removing note %s again - it was deleted on %s", note.ID, t.DeletedAt.Format(time.RFC3339))
+			s.removeDeletedNote(note.ID)
+			return
 		}
-	} else {
-		// Legacy format - content is just a string
-		note.Content = decryptedContent
-		note.Category = models.CategoryPrivate
-		note.Images = nil
+		// Edited after the deletion; the edit wins
+		s.removeTombstone(note.ID)
 	}
 
 	s.mutex.Lock()
-	existingNote, exists := s.notes[note.ID]
-
-	// Only update if the external file is newer than what we have in memory
-	if !exists || note.UpdatedAt.After(existingNote.UpdatedAt) {
-		s.notes[note.ID] = note
-		log.Printf("Updated note %s from external file change", note.ID)
+	existing := s.notes[note.ID]
+	keep, conflict := s.resolveIncoming(existing, note)
+	if keep == note {
+		s.putNote(s.keepFromDisk(note))
 	} else {
-		log.Printf("Skipped updating note %s - in-memory version is newer", note.ID)
+		s.putNote(keep)
 	}
 	s.mutex.Unlock()
+
+	if keep != note {
+		log.Printf("Skipped updating note %s - in-memory version is newer", note.ID)
+		return
+	}
+	log.Printf("Updated note %s from external file change", note.ID)
+
+	switch {
+	case conflict != nil:
+		s.resolveConflict(conflict, note)
+	case existing == nil:
+		s.notifyNote(ChangeCreated, note, true)
+	default:
+		s.notifyNote(ChangeUpdated, note, true)
+	}
 }
 
 // handleFileRemove handles file deletion
@@ -240,37 +333,81 @@ func (s *NoteStore) handleFileRemove(filePath string) {
 	// Check if this was an app-initiated deletion
 	wasAppDeleted := s.pendingDeletions[noteID]
 	delete(s.pendingDeletions, noteID) // Clean up the tracking
-	delete(s.notes, noteID)
-	delete(s.fileModTimes, filePath)
+	delete(s.fileStamps, filePath)
+	note := s.notes[noteID]
 	s.mutex.Unlock()
+	s.contents.remove(noteID)
 
 	if wasAppDeleted {
 		log.Printf("Note %s deleted successfully", noteID)
-	} else {
-		log.Printf("Removed note %s due to external file deletion", noteID)
+		return
+	}
+	if note == nil {
+		return
 	}
+
+	// Only a deletion record makes an external removal a deletion; a file that merely
+	// went missing (e.g. half-way through a sync) is written back on the next save or sync
+	if t := s.loadTombstone(noteID); t == nil || !t.covers(note) {
+		log.Printf("File of note %s disappeared without a deletion record; keeping the note", noteID)
+		return
+	}
+
+	s.mutex.Lock()
+	s.dropNote(noteID)
+	delete(s.bases, noteID)
+	s.mutex.Unlock()
+	log.Printf("Removed note %s due to external file deletion", noteID)
+	s.notifyID(ChangeDeleted, noteID, true)
 }
 
-// syncFromDisk performs a full sync from disk
-func (s *NoteStore) syncFromDisk() error {
+// removeDeletedNote removes a note file that a deletion record says should not exist
+func (s *NoteStore) removeDeletedNote(noteID string) {
+	filename := filepath.Join(s.dataDir, noteID+".json")
+
+	s.mutex.Lock()
+	s.pendingDeletions[noteID] = true
+	_, existed := s.notes[noteID]
+	s.dropNote(noteID)
+	delete(s.bases, noteID)
+	delete(s.fileStamps, filename)
+	s.mutex.Unlock()
+	s.contents.remove(noteID)
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing deleted note %s: %v", noteID, err)
+	}
+	if existed {
+		s.notifyID(ChangeDeleted, noteID, true)
+	}
+}
+
+// syncFromDisk brings the store up to date with the notes directory. Files whose
+// modification time and size did not change since they were last read or written are
+// skipped; the others are decrypted in parallel, newest first, and each note is available
+// as soon as it is decrypted. progress, if not nil, is called after each decrypted file.
+func (s *NoteStore) syncFromDisk(progress func(loaded, total int)) (*SyncStats, error) {
 	if s.key == nil {
-		return fmt.Errorf("not authenticated")
+		return nil, fmt.Errorf("not authenticated")
 	}
 
 	files, err := filepath.Glob(filepath.Join(s.dataDir, "*.json"))
 	if err != nil {
-		return fmt.Errorf("error reading data directory: %v", err)
+		return nil, fmt.Errorf("error reading data directory: %v", err)
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	// Track which notes exist on disk
-	diskNotes := make(map[string]bool)
+	tombstones := s.loadTombstones()
+	s.collectTombstones(tombstones)
 
+	var noteFiles []noteFile
+	var syncConflictFiles []string
 	for _, file := range files {
 		// Only process files with valid short hash names
 		filename := filepath.Base(file)
+		if _, ok := utils.ParseSyncConflictFilename(filename); ok {
+			syncConflictFiles = append(syncConflictFiles, file)
+			continue
+		}
 		if !utils.IsValidShortHashFilename(filename) {
 			log.Printf("Ignoring file with invalid name pattern during sync: %s", filename)
 			continue
@@ -281,195 +418,303 @@ func (s *NoteStore) syncFromDisk() error {
 			log.Printf("Error getting file info for %s: %v", file, err)
 			continue
 		}
+		noteFiles = append(noteFiles, noteFile{
+			path:  file,
+			id:    strings.TrimSuffix(filename, ".json"),
+			stamp: stampOf(fileInfo),
+		})
+	}
 
-		data, err := os.ReadFile(file)
-		if err != nil {
-			log.Printf("Error reading file %s: %v", file, err)
-			continue
-		}
+	stats := &SyncStats{}
+	var changes []Change
+
+	// Track which notes exist on disk
+	diskNotes := make(map[string]bool)
+	diskFiles := make(map[string]bool)    // Note files present, readable or not
+	deletedNotes := make(map[string]bool) // Stale copies of deleted notes
+	var undeletedNotes []string           // Notes edited after their deletion
+	var conflicts [][2]*models.Note       // Local and disk version of each note edited concurrently
 
-		var encryptedNote models.EncryptedNote
-		if err := json.Unmarshal(data, &encryptedNote); err != nil {
-			log.Printf("Error unmarshalling encrypted note from %s: %v", file, err)
+	s.mutex.Lock()
+
+	// The first sync loads the notes; later ones report what changed
+	initial := s.lastSync.IsZero()
+
+	// Files that look the same as when they were last read or written are not read again
+	var changedFiles []noteFile
+	for _, file := range noteFiles {
+		diskFiles[file.id] = true
+		if entry := s.index[file.id]; entry != nil && s.notes[file.id] == nil && entry.stamp().matches(file.stamp) {
+			// Unchanged since the index was written, so its metadata is current
+			s.putNote(entry.stub(file.id))
+			s.fileStamps[file.path] = file.stamp
+		}
+		if stamp, known := s.fileStamps[file.path]; !known || !stamp.matches(file.stamp) {
+			changedFiles = append(changedFiles, file)
 			continue
 		}
 
-		// Decrypt the note content
-		decryptedContent, err := crypto.Decrypt(encryptedNote.EncryptedData, s.key)
-		if err != nil {
-			log.Printf("Error decrypting note from %s: %v", file, err)
-			continue
+		// Unchanged on disk, so the note in memory is current
+		stats.Skipped++
+		existing := s.notes[file.id]
+		if existing == nil {
+			continue // A file that could not be read before
 		}
+		if t := tombstones[file.id]; t != nil {
+			if t.covers(existing) {
+				deletedNotes[file.id] = true
+				stats.Removed++
+				continue
+			}
+			undeletedNotes = append(undeletedNotes, file.id)
+		}
+		diskNotes[file.id] = true
+	}
+	s.index = nil
+	s.mutex.Unlock()
 
-		note := &models.Note{
-			ID:        encryptedNote.ID,
-			CreatedAt: encryptedNote.CreatedAt,
-			UpdatedAt: encryptedNote.UpdatedAt,
+	// The most recently changed notes are decrypted first, so they can be shown
+	// while the rest of a large vault is still loading
+	sort.Slice(changedFiles, func(i, j int) bool {
+		return changedFiles[i].stamp.modTime.After(changedFiles[j].stamp.modTime)
+	})
+	if progress != nil && len(changedFiles) > 0 {
+		progress(0, len(changedFiles))
+	}
+
+	loaded := 0
+	decodeNoteFiles(changedFiles, s.key, func(file noteFile, note *models.Note) {
+		s.mutex.Lock()
+		defer func() {
+			s.mutex.Unlock()
+			loaded++
+			if progress != nil {
+				progress(loaded, len(changedFiles))
+			}
+		}()
+
+		s.fileStamps[file.path] = file.stamp
+		if note == nil {
+			return // Could not be read or decrypted
 		}
 
-		// Try to parse as new JSON format first
-		var noteData struct {
-			Content          string              `json:"content"`
-			Category         models.NoteCategory `json:"category"`
-			OriginalCategory models.NoteCategory `json:"original_category,omitempty"`
-			Images           []models.Image      `json:"images,omitempty"`
+		existing := s.notes[note.ID]
+		if t := tombstones[note.ID]; t != nil {
+			if t.covers(note) {
+				deletedNotes[note.ID] = true
+				if existing != nil {
+					stats.Removed++
+				}
+				return
+			}
+			undeletedNotes = append(undeletedNotes, note.ID)
 		}
 
-		if err := json.Unmarshal([]byte(decryptedContent), &noteData); err == nil {
-			// New format - use parsed data
-			note.Content = noteData.Content
-			note.Category = noteData.Category
-			note.OriginalCategory = noteData.OriginalCategory
-			note.Images = noteData.Images
+		diskNotes[note.ID] = true
 
-			// Ensure category is set (handle empty category in new format)
-			if note.Category == "" {
-				note.Category = models.CategoryPrivate
-			}
+		// Take the note from disk unless the copy in memory is newer
+		keep, conflict := s.resolveIncoming(existing, note)
+		if keep == note {
+			s.putNote(s.keepFromDisk(note))
 		} else {
-			// Legacy format - content is just a string
-			note.Content = decryptedContent
-			note.Category = models.CategoryPrivate
-			note.OriginalCategory = ""
-			note.Images = nil
+			s.putNote(keep)
 		}
+		switch {
+		case conflict != nil:
+			conflicts = append(conflicts, [2]*models.Note{conflict, note})
+			stats.Updated++
+		case keep == existing:
+			// Nothing changed
+		case existing == nil:
+			stats.Added++
+			if !initial {
+				changes = append(changes, Change{Type: ChangeCreated, NoteID: note.ID, Note: note, External: true})
+			}
+		default:
+			stats.Updated++
+			if !initial {
+				changes = append(changes, Change{Type: ChangeUpdated, NoteID: note.ID, Note: note, External: true})
+			}
+		}
+	})
 
-		diskNotes[note.ID] = true
-		s.fileModTimes[file] = fileInfo.ModTime()
+	s.mutex.Lock()
 
-		// Update note if it's newer or doesn't exist in memory
-		existingNote, exists := s.notes[note.ID]
-		if !exists || note.UpdatedAt.After(existingNote.UpdatedAt) {
-			s.notes[note.ID] = note
+	// Remove notes that no longer exist on disk, unless the file is merely missing:
+	// without a deletion record such notes are written back
+	var missingNotes []*models.Note
+	for noteID, note := range s.notes {
+		if diskNotes[noteID] || deletedNotes[noteID] {
+			continue // Deleted notes are removed with their files below
 		}
-	}
+		if t := tombstones[noteID]; !diskFiles[noteID] && (t == nil || !t.covers(note)) {
+			missingNotes = append(missingNotes, note)
+			continue
+		}
+		s.dropNote(noteID)
+		delete(s.bases, noteID)
 
-	// Remove notes that no longer exist on disk
-	for noteID := range s.notes {
-		if !diskNotes[noteID] {
-			delete(s.notes, noteID)
+		change := Change{Type: ChangeDeleted, NoteID: noteID, External: true}
+		if diskFiles[noteID] {
+			change.Type = ChangeCorrupted // The file is there but could not be read
 		}
+		changes = append(changes, change)
+		stats.Removed++
 	}
 
 	s.lastSync = time.Now()
-	return nil
-}
+	key := s.key
+	s.mutex.Unlock()
 
-// saveNote saves a note to disk
-func (s *NoteStore) saveNote(note *models.Note, key []byte) error {
-	// Create a structure to encrypt that includes all note data
-	noteData := struct {
-		Content          string              `json:"content"`
-		Category         models.NoteCategory `json:"category"`
-		OriginalCategory models.NoteCategory `json:"original_category,omitempty"`
-		Images           []models.Image      `json:"images,omitempty"`
-	}{
-		Content:          note.Content,
-		Category:         note.Category,
-		OriginalCategory: note.OriginalCategory,
-		Images:           note.Images,
-	}
-
-	// Marshal the note data to JSON
-	noteJSON, err := json.Marshal(noteData)
-	if err != nil {
-		return err
+	if stats.Added+stats.Updated+stats.Removed > 0 {
+		log.Printf("Synced notes from disk: %d added, %d updated, %d removed, %d unchanged",
+			stats.Added, stats.Updated, stats.Removed, stats.Skipped)
 	}
 
-	// Encrypt the JSON content
-	encryptedContent, err := crypto.Encrypt(string(noteJSON), key)
-	if err != nil {
-		return err
+	for _, change := range changes {
+		s.notify(change)
 	}
-
-	encryptedNote := models.EncryptedNote{
-		ID:            note.ID,
-		EncryptedData: encryptedContent,
-		CreatedAt:     note.CreatedAt,
-		UpdatedAt:     note.UpdatedAt,
+	for noteID := range deletedNotes {
+		log.Printf("Removing note %s again - it was deleted on another device", noteID)
+		s.removeDeletedNote(noteID)
+	}
+	for _, noteID := range undeletedNotes {
+		s.removeTombstone(noteID)
+	}
+	for _, note := range missingNotes {
+		if _, err := os.Stat(filepath.Join(s.dataDir, note.ID+".json")); err == nil {
+			continue // Saved since the directory was listed
+		}
+		log.Printf("Writing back note %s - its file is missing but it was never deleted", note.ID)
+		s.removeTombstone(note.ID)
+		if err := s.writeNote(note, key); err != nil {
+			log.Printf("Error writing back note %s: %v", note.ID, err)
+		}
 	}
 
-	filename := filepath.Join(s.dataDir, fmt.Sprintf("%s.json", note.ID))
-	data, err := json.MarshalIndent(encryptedNote, "", "  ")
-	if err != nil {
-		return err
+	// Merges and conflict copies are written once the lock is released
+	for _, conflict := range conflicts {
+		s.resolveConflict(conflict[0], conflict[1])
+	}
+	for _, file := range syncConflictFiles {
+		s.handleSyncConflictFile(file)
 	}
+	s.scheduleIndexSave()
+	return stats, nil
+}
+
+// saveNote records a local edit in the note's version and saves it to disk,
+// keeping the version it replaces as a revision
+func (s *NoteStore) saveNote(note *models.Note, key []byte) error {
+	s.mutex.Lock()
+	s.bumpVersion(note)
+	s.mutex.Unlock()
 
-	// Write the file
-	if err := os.WriteFile(filename, data, 0644); err != nil {
+	if err := s.archiveRevision(note.ID, false); err != nil {
+		// The edit itself is more important than its history
+		log.Printf("Warning: Failed to keep revision of note %s: %v", note.ID, err)
+	}
+	if err := s.writeNote(note, key); err != nil {
 		return err
 	}
 
-	// Update our modification time tracking to prevent processing our own write
-	if fileInfo, err := os.Stat(filename); err == nil {
-		s.mutex.Lock()
-		s.fileModTimes[filename] = fileInfo.ModTime()
-		s.mutex.Unlock()
+	s.mutex.RLock()
+	lazy := s.lazy
+	s.mutex.RUnlock()
+	if lazy {
+		// Needed to merge the edit if another device changed the note concurrently
+		s.contents.pin(note.ID, note.Content)
 	}
-
 	return nil
 }
 
-// SaveNoteDirect saves a note to disk, bypassing in-memory update (for password change)
-func (s *NoteStore) SaveNoteDirect(note *models.Note, key []byte) error {
-	// Create a structure to encrypt that includes all note data
-	noteData := struct {
-		Content          string              `json:"content"`
-		Category         models.NoteCategory `json:"category"`
-		OriginalCategory models.NoteCategory `json:"original_category,omitempty"`
-		Images           []models.Image      `json:"images,omitempty"`
-	}{
-		Content:          note.Content,
-		Category:         note.Category,
-		OriginalCategory: note.OriginalCategory,
-		Images:           note.Images,
-	}
-
-	// Marshal the note data to JSON
-	noteJSON, err := json.Marshal(noteData)
+// writeNote encrypts a note and writes it to disk. In lazy mode a complete note in memory
+// is replaced by its metadata once it is written.
+func (s *NoteStore) writeNote(note *models.Note, key []byte) error {
+	full, err := s.withContent(note)
+	if err != nil {
+		return err
+	}
+	data, err := encodeNote(full, key, s.noteFormat())
 	if err != nil {
 		return err
 	}
 
-	// Encrypt the JSON content
-	encryptedContent, err := crypto.Encrypt(string(noteJSON), key)
+	filename := filepath.Join(s.dataDir, fmt.Sprintf("%s.json", note.ID))
+
+	// Write the file atomically; the modification time is recorded before the file appears
+	// so the watcher always recognises it as our own write
+	err = utils.WriteFileAtomicFunc(filename, data, 0644, func(fileInfo os.FileInfo) {
+		s.mutex.Lock()
+		s.fileStamps[filename] = stampOf(fileInfo)
+		s.mutex.Unlock()
+	})
 	if err != nil {
 		return err
 	}
 
-	encryptedNote := models.EncryptedNote{
-		ID:            note.ID,
-		EncryptedData: encryptedContent,
-		CreatedAt:     note.CreatedAt,
-		UpdatedAt:     note.UpdatedAt,
+	s.mutex.Lock()
+	if s.notes[note.ID] == note && !note.Partial {
+		// Notes edited in place are indexed again once they are saved
+		if s.lazy {
+			s.putNote(stubOf(note))
+			s.contents.put(note.ID, note.Content)
+		} else {
+			s.putNote(note)
+		}
 	}
+	s.mutex.Unlock()
+	s.scheduleIndexSave()
+	return nil
+}
 
-	filename := filepath.Join(s.dataDir, note.ID+".json")
-	data, err := json.MarshalIndent(encryptedNote, "", "  ")
+// SaveNoteDirect saves a note to disk, bypassing in-memory update (for password change)
+func (s *NoteStore) SaveNoteDirect(note *models.Note, key []byte) error {
+	data, err := encodeNote(note, key, s.noteFormat())
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filename, data, 0644)
+	filename := filepath.Join(s.dataDir, note.ID+".json")
+	return utils.WriteFileAtomic(filename, data, 0644)
 }
 
 // deleteNote removes a note from disk
 func (s *NoteStore) deleteNote(id string) error {
 	filename := filepath.Join(s.dataDir, fmt.Sprintf("%s.json", id))
 
+	// Record the deletion first so other devices do not bring the note back
+	s.mutex.RLock()
+	var versions models.VersionVector
+	if note, exists := s.notes[id]; exists {
+		versions = note.Versions
+	}
+	s.mutex.RUnlock()
+	if err := s.writeTombstone(id, versions); err != nil {
+		return fmt.Errorf("failed to record deletion: %v", err)
+	}
+
 	s.mutex.Lock()
 	// Mark this deletion as app-initiated
 	s.pendingDeletions[id] = true
-	delete(s.notes, id)
-	delete(s.fileModTimes, filename)
+	s.dropNote(id)
+	delete(s.bases, id)
+	delete(s.fileStamps, filename)
 	s.mutex.Unlock()
+	s.contents.remove(id)
 
-	return os.Remove(filename)
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	s.deleteHistory(id)
+	s.scheduleIndexSave()
+	s.notifyID(ChangeDeleted, id, false)
+	return nil
 }
 
-// CreateNote creates a new note with default category (private)
+// CreateNote creates a new note in the default category
 func (s *NoteStore) CreateNote(content string, key []byte) (*models.Note, error) {
-	return s.CreateNoteWithCategory(content, models.CategoryPrivate, key)
+	return s.CreateNoteWithCategory(content, s.DefaultCategory(), key)
 }
 
 // CreateNoteWithCategory creates a new note with specified category
@@ -483,46 +728,52 @@ func (s *NoteStore) CreateNoteWithCategory(content string, category models.NoteC
 	}
 
 	s.mutex.Lock()
-	s.notes[note.ID] = note
+	s.putNote(note)
 	s.mutex.Unlock()
 
 	if err := s.saveNote(note, key); err != nil {
 		s.mutex.Lock()
-		delete(s.notes, note.ID)
+		s.dropNote(note.ID)
 		s.mutex.Unlock()
 		return nil, err
 	}
 
+	s.notifyNote(ChangeCreated, note, false)
 	return note, nil
 }
 
 // UpdateNote updates an existing note
 func (s *NoteStore) UpdateNote(id string, content string, key []byte) (*models.Note, error) {
 	s.mutex.Lock()
-	note, exists := s.notes[id]
-	if !exists {
+	note, err := s.editableNote(id)
+	if err != nil {
 		s.mutex.Unlock()
-		return nil, fmt.Errorf("note not found")
+		return nil, err
 	}
 
 	note.Content = content
 	note.UpdatedAt = time.Now()
+	if note.NeedsReview && !strings.Contains(content, utils.MergeMarkerOurs) {
+		// The conflict markers of a merge were resolved by editing the note
+		note.NeedsReview = false
+	}
 	s.mutex.Unlock()
 
 	if err := s.saveNote(note, key); err != nil {
 		return nil, err
 	}
 
+	s.notifyNote(ChangeUpdated, note, false)
 	return note, nil
 }
 
 // UpdateNoteCategory updates the category of an existing note
 func (s *NoteStore) UpdateNoteCategory(id string, category models.NoteCategory, key []byte) (*models.Note, error) {
 	s.mutex.Lock()
-	note, exists := s.notes[id]
-	if !exists {
+	note, err := s.editableNote(id)
+	if err != nil {
 		s.mutex.Unlock()
-		return nil, fmt.Errorf("note not found")
+		return nil, err
 	}
 
 	note.Category = category
@@ -533,10 +784,11 @@ func (s *NoteStore) UpdateNoteCategory(id string, category models.NoteCategory,
 		return nil, err
 	}
 
+	s.notifyNote(ChangeUpdated, note, false)
 	return note, nil
 }
 
-// GetNote retrieves a note by ID
+// GetNote retrieves a note by ID, together with its content
 func (s *NoteStore) GetNote(id string) (*models.Note, error) {
 	s.mutex.RLock()
 	note, exists := s.notes[id]
@@ -545,7 +797,7 @@ func (s *NoteStore) GetNote(id string) (*models.Note, error) {
 	if !exists {
 		return nil, fmt.Errorf("note not found")
 	}
-	return note, nil
+	return s.currentContent(note)
 }
 
 // GetAllNotes returns all notes sorted by update time
@@ -557,61 +809,64 @@ func (s *NoteStore) GetAllNotes() []*models.Note {
 	}
 	s.mutex.RUnlock()
 
-	// Sort by updated time, newest first
+	sortNotesByUpdate(notes)
+	return notes
+}
+
+// sortNotesByUpdate sorts notes by updated time, newest first
+func sortNotesByUpdate(notes []*models.Note) {
 	sort.Slice(notes, func(i, j int) bool {
 		return notes[i].UpdatedAt.After(notes[j].UpdatedAt)
 	})
-
-	return notes
 }
 
-// GetNotesByCategory returns all notes in a specific category
+// GetNotesByCategory returns all notes in a specific category. Notes whose category
+// no longer exists are listed in the default category.
 func (s *NoteStore) GetNotesByCategory(category models.NoteCategory) []*models.Note {
+	categories, err := s.loadCategories()
+	if err != nil {
+		log.Printf("Warning: Failed to load categories: %v", err)
+	}
+
 	s.mutex.RLock()
 	notes := make([]*models.Note, 0)
 	for _, note := range s.notes {
-		if note.Category == category {
+		if note.Category == category || (categories != nil && categoryOrDefault(note.Category, categories) == category) {
 			notes = append(notes, note)
 		}
 	}
 	s.mutex.RUnlock()
 
-	// Sort by update time (newest first)
-	sort.Slice(notes, func(i, j int) bool {
-		return notes[i].UpdatedAt.After(notes[j].UpdatedAt)
-	})
-
+	sortNotesByUpdate(notes)
 	return notes
 }
 
-// SearchNotes searches for notes containing the query string
+// SearchNotes searches for notes containing the query string.
+// In lazy mode the notes that are not cached are decrypted for the search.
 func (s *NoteStore) SearchNotes(query string) []*models.Note {
 	var results []*models.Note
 	query = strings.ToLower(query)
 
-	s.mutex.RLock()
-	for _, note := range s.notes {
+	for _, note := range s.GetAllNotesWithContent() {
 		if strings.Contains(strings.ToLower(note.Content), query) {
 			results = append(results, note)
 		}
 	}
-	s.mutex.RUnlock()
-
-	// Sort by updated time, newest first
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].UpdatedAt.After(results[j].UpdatedAt)
-	})
-
 	return results
 }
 
 // MoveToTrash moves a note to trash category, preserving the original category
 func (s *NoteStore) MoveToTrash(id string, key []byte) (*models.Note, error) {
+	return s.moveToTrash(id, "", key)
+}
+
+// moveToTrash moves a note to trash; trashedWith is the notebook being trashed with it, if any
+func (s *NoteStore) moveToTrash(id, trashedWith string, key []byte) (*models.Note, error) {
 	s.mutex.Lock()
-	note, exists := s.notes[id]
-	if !exists {
+	note, err := s.editableNote(id)
+	if err != nil {
 		s.mutex.Unlock()
-		return nil, fmt.Errorf("note not found")
+		return nil, err
 	}
 
 	// Store the current category as original category before moving to trash
@@ -619,6 +874,7 @@ func (s *NoteStore) MoveToTrash(id string, key []byte) (*models.Note, error) {
 		note.OriginalCategory = note.Category
 	}
 	note.Category = models.CategoryTrash
+	note.TrashedWith = trashedWith
 	note.UpdatedAt = time.Now()
 	s.mutex.Unlock()
 
@@ -626,6 +882,7 @@ func (s *NoteStore) MoveToTrash(id string, key []byte) (*models.Note, error) {
 		return nil, err
 	}
 
+	s.notifyNote(ChangeUpdated, note, false)
 	return note, nil
 }
 
@@ -643,18 +900,34 @@ func (s *NoteStore) PermanentlyDeleteNote(id string) error {
 		s.mutex.Unlock()
 		return fmt.Errorf("note must be in trash to be permanently deleted")
 	}
+	versions := note.Versions
+	s.mutex.Unlock()
+
+	// Record the deletion first so other devices do not bring the note back
+	if err := s.writeTombstone(id, versions); err != nil {
+		return fmt.Errorf("failed to record deletion: %v", err)
+	}
 
+	s.mutex.Lock()
 	// Mark this deletion as app-initiated
 	s.pendingDeletions[id] = true
-	delete(s.notes, id)
+	s.dropNote(id)
+	delete(s.bases, id)
 
-	// Clean up file mod times
+	// Clean up file stamps
 	filename := filepath.Join(s.dataDir, id+".json")
-	delete(s.fileModTimes, filename)
+	delete(s.fileStamps, filename)
 	s.mutex.Unlock()
+	s.contents.remove(id)
 
-	// Delete the file
-	return os.Remove(filename)
+	// Delete the file together with its history
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	s.deleteHistory(id)
+	s.scheduleIndexSave()
+	s.notifyID(ChangeDeleted, id, false)
+	return nil
 }
 
 // DeleteNote deletes a note by ID
@@ -669,17 +942,99 @@ func (s *NoteStore) DeleteNote(id string) error {
 	return s.deleteNote(id)
 }
 
-// Close cleans up the file watcher
+// Close stops reconciling, writes pending index changes and cleans up the file watcher
 func (s *NoteStore) Close() error {
+	s.mutex.Lock()
+	pendingIndex := s.indexTimer != nil && s.indexTimer.Stop()
+	s.mutex.Unlock()
+	if pendingIndex {
+		if err := s.saveIndex(); err != nil {
+			log.Printf("Warning: Failed to save note index: %v", err)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopReconcile != nil {
+		close(s.stopReconcile)
+		s.stopReconcile = nil
+	}
 	if s.watcher != nil {
 		return s.watcher.Close()
 	}
 	return nil
 }
 
-// RefreshFromDisk forces a full refresh from disk
-func (s *NoteStore) RefreshFromDisk() error {
-	return s.syncFromDisk()
+// RefreshFromDisk reads the notes that changed on disk and reports what changed in the store
+func (s *NoteStore) RefreshFromDisk() (*SyncStats, error) {
+	return s.syncFromDisk(nil)
+}
+
+// MigrateNotes rewrites note files stored in an older format than the one used for saving.
+// Files written by a newer version are skipped and listed in unsupported.
+func (s *NoteStore) MigrateNotes(key []byte) (migrated int, unsupported []string, err error) {
+	if _, err := s.syncFromDisk(nil); err != nil {
+		return 0, nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.dataDir, "*.json"))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to list note files: %v", err)
+	}
+
+	target := s.noteFormat()
+	for _, file := range files {
+		filename := filepath.Base(file)
+		if !utils.IsValidShortHashFilename(filename) {
+			continue
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return migrated, unsupported, fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+
+		envelope, err := parseNoteEnvelope(data)
+		if err != nil {
+			if errors.Is(err, ErrUnsupportedFormat) {
+				unsupported = append(unsupported, filename)
+			}
+			continue
+		}
+		if envelope.Version() >= target {
+			continue
+		}
+
+		// Files that could not be decrypted during the sync are left untouched
+		note, err := s.GetNote(strings.TrimSuffix(filename, ".json"))
+		if err != nil {
+			continue
+		}
+
+		if err := s.writeNote(note, key); err != nil {
+			return migrated, unsupported, fmt.Errorf("failed to rewrite note %s: %v", note.ID, err)
+		}
+		migrated++
+	}
+
+	return migrated, unsupported, nil
+}
+
+// RewriteAllNotes saves every note again in the current format and returns how many were written
+func (s *NoteStore) RewriteAllNotes(key []byte) (int, error) {
+	if _, err := s.syncFromDisk(nil); err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for _, note := range s.GetAllNotes() {
+		if err := s.writeNote(note, key); err != nil {
+			return rewritten, fmt.Errorf("failed to rewrite note %s: %v", note.ID, err)
+		}
+		rewritten++
+	}
+	return rewritten, nil
 }
 
 // MoveNoteToCorrupted moves a note file to the corrupted folder
@@ -695,9 +1050,12 @@ func (s *NoteStore) MoveNoteToCorrupted(noteID string) error {
 	}
 	// Remove from in-memory store
 	s.mutex.Lock()
-	delete(s.notes, noteID)
-	delete(s.fileModTimes, oldPath)
+	s.dropNote(noteID)
+	delete(s.fileStamps, oldPath)
 	s.mutex.Unlock()
+	s.contents.remove(noteID)
+
+	s.notifyID(ChangeCorrupted, noteID, false)
 	return nil
 }
 
@@ -718,20 +1076,63 @@ func (s *NoteStore) ClearAllNotes() error {
 		}
 	}
 
+	if err := os.RemoveAll(filepath.Join(s.dataDir, historyDirName)); err != nil {
+		log.Printf("Failed to remove note history: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.dataDir, tombstoneDirName)); err != nil {
+		log.Printf("Failed to remove deletion records: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.dataDir, indexDirName)); err != nil {
+		log.Printf("Failed to remove note index: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.dataDir, categoryDirName)); err != nil {
+		log.Printf("Failed to remove categories: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.dataDir, notebookDirName)); err != nil {
+		log.Printf("Failed to remove notebooks: %v", err)
+	}
+
 	// Clear in-memory storage
 	s.notes = make(map[string]*models.Note)
-	s.fileModTimes = make(map[string]time.Time)
+	s.tagNotes = make(map[string]map[string]bool)
+	s.noteTags = make(map[string][]string)
+	s.linkSources = make(map[string]map[string]bool)
+	s.noteLinks = make(map[string][]string)
+	s.titleNotes = make(map[string]map[string]bool)
+	s.noteTitles = make(map[string]string)
+	s.imageNotes = make(map[string]map[string]bool)
+	s.noteImages = make(map[string][]string)
+	s.bases = make(map[string]*mergeBase)
+	s.syncConflictsSeen = make(map[string]bool)
+	s.fileStamps = make(map[string]fileStamp)
+	s.contents.clear()
+	s.invalidateRecords()
 
 	return nil
 }
 
-// RestoreFromTrash restores a note from trash to its original category
+// RestoreFromTrash restores a note from trash to its original category, or to the
+// default category if that no longer exists. A note whose notebook is in trash or gone
+// is restored without a notebook.
 func (s *NoteStore) RestoreFromTrash(id string, key []byte) (*models.Note, error) {
+	categories, err := s.loadCategories()
+	if err != nil {
+		return nil, err
+	}
+	notebooks, err := s.loadNotebooks()
+	if err != nil {
+		return nil, err
+	}
+	return s.restoreFromTrash(id, categories, notebooks, key)
+}
+
+// restoreFromTrash restores a note given the categories and notebooks it can return to
+func (s *NoteStore) restoreFromTrash(id string, categories map[models.NoteCategory]*models.Category, notebooks map[string]*models.Notebook, key []byte) (*models.Note, error) {
 	s.mutex.Lock()
-	note, exists := s.notes[id]
-	if !exists {
+	note, err := s.editableNote(id)
+	if err != nil {
 		s.mutex.Unlock()
-		return nil, fmt.Errorf("note not found")
+		return nil, err
 	}
 
 	// Only allow restoring from trash
@@ -740,15 +1141,16 @@ func (s *NoteStore) RestoreFromTrash(id string, key []byte) (*models.Note, error
 		return nil, fmt.Errorf("note is not in trash")
 	}
 
-	// Restore to original category, or default to private if no original category
-	if note.OriginalCategory != "" {
-		note.Category = note.OriginalCategory
-	} else {
-		note.Category = models.CategoryPrivate // Default fallback
+	// Restore to original category, or to the default one if it has none
+	note.Category = categoryOrDefault(note.OriginalCategory, categories)
+	if note.Category == models.CategoryTrash {
+		note.Category = defaultCategoryOf(categories)
 	}
 
 	// Clear the original category since it's been restored
 	note.OriginalCategory = ""
+	note.TrashedWith = ""
+	note.Notebook = notebookOrNone(note.Notebook, notebooks)
 	note.UpdatedAt = time.Now()
 	s.mutex.Unlock()
 
@@ -756,5 +1158,6 @@ func (s *NoteStore) RestoreFromTrash(id string, key []byte) (*models.Note, error
 		return nil, err
 	}
 
+	s.notifyNote(ChangeUpdated, note, false)
 	return note, nil
 }
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"gote/pkg/utils"
+)
+
+const (
+	// DefaultReconcileInterval is how often the notes directory is compared with the store
+	// while the file watcher runs, to pick up changes whose events were dropped
+	DefaultReconcileInterval = 5 * time.Minute
+
+	// DefaultPollInterval is how often the notes directory is compared in polling-only mode
+	DefaultPollInterval = 10 * time.Second
+)
+
+// fileStamp is what is known about a note file without reading it
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// stampOf returns the stamp of a file
+func stampOf(fileInfo os.FileInfo) fileStamp {
+	return fileStamp{modTime: fileInfo.ModTime(), size: fileInfo.Size()}
+}
+
+// matches reports whether two stamps describe the same file contents, as far as can be told
+func (f fileStamp) matches(other fileStamp) bool {
+	return f.modTime.Equal(other.modTime) && f.size == other.size
+}
+
+// newWatcher creates a watcher of the notes directory and its record directories, or returns nil
+// if the file system cannot be watched; changes are then only found by polling
+func newWatcher(dir string) *fsnotify.Watcher {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Warning: Could not create file watcher, polling for changes instead: %v", err)
+		return nil
+	}
+	if err := watcher.Add(dir); err != nil {
+		log.Printf("Warning: Could not watch %s, polling for changes instead: %v", dir, err)
+		watcher.Close()
+		return nil
+	}
+	for _, dirName := range recordDirNames {
+		if recordDir := filepath.Join(dir, dirName); dirExists(recordDir) {
+			if err := watcher.Add(recordDir); err != nil {
+				log.Printf("Warning: Could not watch %s: %v", recordDir, err)
+			}
+		}
+	}
+	return watcher
+}
+
+// dirExists reports whether a directory exists
+func dirExists(dir string) bool {
+	fileInfo, err := os.Stat(dir)
+	return err == nil && fileInfo.IsDir()
+}
+
+// SetPollingMode selects whether changes on disk are found by polling only, instead of
+// file system events. Polling is meant for network file systems (e.g. SMB or sshfs mounts)
+// that do not report changes made by other machines. An interval of 0 polls every
+// DefaultPollInterval. Polling is also used whenever the directory cannot be watched.
+func (s *NoteStore) SetPollingMode(pollingOnly bool, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	s.mutex.Lock()
+	s.pollingOnly = pollingOnly
+	s.pollInterval = interval
+
+	switch {
+	case pollingOnly && s.watcher != nil:
+		// Closing the watcher ends its goroutine
+		s.watcher.Close()
+		s.watcher = nil
+		s.watching = false
+	case !pollingOnly && s.watcher == nil:
+		s.watcher = newWatcher(s.dataDir)
+	}
+	loaded := s.key != nil
+	s.mutex.Unlock()
+
+	if loaded {
+		s.startWatching()
+	}
+
+	// Let a running reconciler pick up the new interval
+	select {
+	case s.reconcileWake <- struct{}{}:
+	default:
+	}
+}
+
+// reconcileInterval returns how long to wait between two reconciliations
+func (s *NoteStore) reconcileInterval() time.Duration {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.pollingOnly || s.watcher == nil {
+		return s.pollInterval
+	}
+	return DefaultReconcileInterval
+}
+
+// startReconciling starts the background reconciliation goroutine
+func (s *NoteStore) startReconciling() {
+	s.mutex.Lock()
+	if s.reconciling || s.stopReconcile == nil {
+		s.mutex.Unlock()
+		return
+	}
+	s.reconciling = true
+	stop := s.stopReconcile
+	s.mutex.Unlock()
+
+	go s.reconcileLoop(stop)
+}
+
+// reconcileLoop reconciles the store with the notes directory until stop is closed
+func (s *NoteStore) reconcileLoop(stop <-chan struct{}) {
+	for {
+		timer := time.NewTimer(s.reconcileInterval())
+		select {
+		case <-timer.C:
+			s.reconcile()
+		case <-s.reconcileWake:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// reconcile compares the notes directory with the files the store last read or wrote.
+// Only files whose modification time or size differ, and files that appeared or
+// disappeared, are processed, the same way as paths reported by the watcher.
+func (s *NoteStore) reconcile() {
+	s.mutex.RLock()
+	loaded := s.key != nil && !s.suspended
+	s.mutex.RUnlock()
+	if !loaded {
+		return
+	}
+
+	// Categories and notebooks are few; they are read again instead of being compared file by file
+	s.watchRecords()
+	s.invalidateRecords()
+
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		log.Printf("Error reading data directory for reconciliation: %v", err)
+		return
+	}
+
+	onDisk := make(map[string]bool)
+	var changed []string
+
+	s.mutex.RLock()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		path := filepath.Join(s.dataDir, name)
+		onDisk[path] = true
+
+		if _, ok := utils.ParseSyncConflictFilename(name); ok {
+			if !s.syncConflictsSeen[name] {
+				changed = append(changed, path)
+			}
+			continue
+		}
+		if !utils.IsValidShortHashFilename(name) {
+			continue
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue // Removed since the listing
+		}
+		if stamp, known := s.fileStamps[path]; !known || !stamp.matches(stampOf(fileInfo)) {
+			changed = append(changed, path)
+		}
+	}
+
+	for path := range s.fileStamps {
+		if !onDisk[path] {
+			changed = append(changed, path)
+		}
+	}
+	for name := range s.syncConflictsSeen {
+		if path := filepath.Join(s.dataDir, name); !onDisk[path] {
+			changed = append(changed, path)
+		}
+	}
+	s.mutex.RUnlock()
+
+	if len(changed) > 0 {
+		log.Printf("Reconciliation found %d changed files", len(changed))
+		s.processWatchBatch(changed)
+	}
+}
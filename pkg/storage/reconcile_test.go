@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+// writeNoteFile writes a note file the way another device would
+func writeNoteFile(t *testing.T, store *NoteStore, note *models.Note, key []byte) {
+	t.Helper()
+	data, err := encodeNote(note, key, models.NoteFormatV3)
+	if err != nil {
+		t.Fatalf("encodeNote() error: %v", err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(store.dataDir, note.ID+".json"), data, 0644); err != nil {
+		t.Fatalf("WriteFileAtomic() error: %v", err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name        string
+		change      func(t *testing.T, store *NoteStore, note *models.Note, key []byte)
+		noteID      string // Note to check; the changed note if empty
+		wantContent string // Empty if the note must be gone
+	}{
+		{
+			name:        "unchanged",
+			change:      func(t *testing.T, store *NoteStore, note *models.Note, key []byte) {},
+			wantContent: "original",
+		},
+		{
+			name: "added",
+			change: func(t *testing.T, store *NoteStore, note *models.Note, key []byte) {
+				added := testNote()
+				added.ID = "ffffffff"
+				added.Content = "added elsewhere"
+				writeNoteFile(t, store, added, key)
+			},
+			noteID:      "ffffffff",
+			wantContent: "added elsewhere",
+		},
+		{
+			name: "modified",
+			change: func(t *testing.T, store *NoteStore, note *models.Note, key []byte) {
+				note.Content = "edited elsewhere"
+				note.Versions = note.Versions.Increment("phone")
+				writeNoteFile(t, store, note, key)
+			},
+			wantContent: "edited elsewhere",
+		},
+		{
+			name: "removed without a deletion record",
+			change: func(t *testing.T, store *NoteStore, note *models.Note, key []byte) {
+				if err := os.Remove(filepath.Join(store.dataDir, note.ID+".json")); err != nil {
+					t.Fatalf("Remove() error: %v", err)
+				}
+			},
+			wantContent: "original",
+		},
+		{
+			name: "deleted elsewhere",
+			change: func(t *testing.T, store *NoteStore, note *models.Note, key []byte) {
+				deletion := &tombstone{NoteID: note.ID, DeletedAt: time.Now(), Versions: note.Versions.Increment("phone")}
+				data, err := encodeTombstone(deletion, key)
+				if err != nil {
+					t.Fatalf("encodeTombstone() error: %v", err)
+				}
+				if err := os.MkdirAll(filepath.Join(store.dataDir, tombstoneDirName), 0755); err != nil {
+					t.Fatalf("MkdirAll() error: %v", err)
+				}
+				if err := utils.WriteFileAtomic(store.tombstonePath(note.ID), data, 0644); err != nil {
+					t.Fatalf("WriteFileAtomic() error: %v", err)
+				}
+				if err := os.Remove(filepath.Join(store.dataDir, note.ID+".json")); err != nil {
+					t.Fatalf("Remove() error: %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, key := testStore(t)
+			// Without a watcher only reconciliation finds the change
+			store.SetPollingMode(true, time.Hour)
+			note, err := store.CreateNote("original", key)
+			if err != nil {
+				t.Fatalf("CreateNote() error: %v", err)
+			}
+
+			changed := *note
+			tt.change(t, store, &changed, key)
+			store.reconcile()
+
+			id := tt.noteID
+			if id == "" {
+				id = note.ID
+			}
+			got, err := store.GetNote(id)
+			if tt.wantContent == "" {
+				if err == nil {
+					t.Errorf("GetNote() = %q, want the note to be gone", got.Content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetNote() error: %v", err)
+			}
+			if got.Content != tt.wantContent {
+				t.Errorf("GetNote() = %q, want %q", got.Content, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestPollingMode(t *testing.T) {
+	store, key := testStore(t)
+	store.SetPollingMode(true, 20*time.Millisecond)
+	if got := store.reconcileInterval(); got != 20*time.Millisecond {
+		t.Errorf("reconcileInterval() in polling mode = %v, want %v", got, 20*time.Millisecond)
+	}
+
+	added := testNote()
+	added.Content = "added elsewhere"
+	writeNoteFile(t, store, added, key)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if note, err := store.GetNote(added.ID); err == nil {
+			if note.Content != added.Content {
+				t.Errorf("GetNote() = %q, want %q", note.Content, added.Content)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("polling did not pick up a new note file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	store.SetPollingMode(false, 0)
+	want := DefaultReconcileInterval
+	if store.watcher == nil {
+		want = DefaultPollInterval // The file system cannot be watched here
+	}
+	if got := store.reconcileInterval(); got != want {
+		t.Errorf("reconcileInterval() with a watcher = %v, want %v", got, want)
+	}
+}
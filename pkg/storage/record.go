@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gote/pkg/crypto"
+	"gote/pkg/utils"
+)
+
+// RecordFormatV1 is the only format of small vault records, such as categories and deletion records, so far
+const RecordFormatV1 = 1
+
+// recordDirNames are the directories of records that are cached in memory and read again
+// when the watcher sees them change
+var recordDirNames = []string{categoryDirName, notebookDirName}
+
+// recordEnvelope is the plaintext part of a record file: a category, notebook and so on
+type recordEnvelope struct {
+	FormatVersion int    `json:"format_version"`
+	ID            string `json:"id"`
+	EncryptedData string `json:"encrypted_data"`
+}
+
+// recordAssociatedData binds a record to its kind and ID
+func recordAssociatedData(kind string, version int, id string) []byte {
+	return []byte(strings.Join([]string{"gote-" + kind, strconv.Itoa(version), id}, "\x00"))
+}
+
+// encodeRecord encrypts a record of the given kind; everything but its ID is part of the ciphertext
+func encodeRecord(kind, id string, record any, key []byte) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedData, err := crypto.EncryptBytesWithAAD(payload, key, recordAssociatedData(kind, RecordFormatV1, id))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(recordEnvelope{
+		FormatVersion: RecordFormatV1,
+		ID:            id,
+		EncryptedData: encryptedData,
+	}, "", "  ")
+}
+
+// decodeRecord decrypts a record file whose name gives the expected ID into record
+func decodeRecord(kind string, data []byte, expectedID string, key []byte, record any) error {
+	var envelope recordEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %v", kind, err)
+	}
+	if envelope.FormatVersion > RecordFormatV1 {
+		return fmt.Errorf("%w: %s format %d", ErrUnsupportedFormat, kind, envelope.FormatVersion)
+	}
+	if envelope.ID != expectedID {
+		return fmt.Errorf("%s ID %s does not match file name %s.json", kind, envelope.ID, expectedID)
+	}
+
+	payload, err := crypto.DecryptBytesWithAAD(envelope.EncryptedData, key, recordAssociatedData(kind, envelope.FormatVersion, envelope.ID))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %v", kind, err)
+	}
+	if err := json.Unmarshal(payload, record); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", kind, err)
+	}
+	return nil
+}
+
+// stageRecords re-encrypts the records in dirName into the staging directory and returns
+// their relative paths
+func stageRecords(kind, dirName string, isValidFilename func(string) bool, dataDir, stagingDir string, oldKey, newKey []byte) ([]string, error) {
+	files, err := os.ReadDir(filepath.Join(dataDir, dirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list %s records: %v", kind, err)
+	}
+
+	var staged []string
+	for _, file := range files {
+		if file.IsDir() || !isValidFilename(file.Name()) {
+			continue
+		}
+
+		rel := filepath.Join(dirName, file.Name())
+		data, err := os.ReadFile(filepath.Join(dataDir, rel))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s %s: %v", kind, rel, err)
+		}
+
+		id := strings.TrimSuffix(file.Name(), ".json")
+		var record json.RawMessage
+		if err := decodeRecord(kind, data, id, oldKey, &record); err != nil {
+			return nil, unreadableFileError(rel, err)
+		}
+
+		encoded, err := encodeRecord(kind, id, record, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt %s %s: %v", kind, rel, err)
+		}
+
+		dst := filepath.Join(stagingDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, err
+		}
+		if err := utils.WriteFileAtomic(dst, encoded, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s %s: %v", kind, rel, err)
+		}
+		staged = append(staged, rel)
+	}
+	return staged, nil
+}
+
+// isRecordPath reports whether a path is a cached record directory or a file in one
+func (s *NoteStore) isRecordPath(path string) bool {
+	for _, dirName := range recordDirNames {
+		dir := filepath.Join(s.dataDir, dirName)
+		if path == dir || filepath.Dir(path) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// watchRecords adds the record directories to the watcher once they exist; they may
+// only appear when another device syncs its first category or notebook
+func (s *NoteStore) watchRecords() {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.watcher == nil {
+		return
+	}
+	for _, dirName := range recordDirNames {
+		dir := filepath.Join(s.dataDir, dirName)
+		if !dirExists(dir) {
+			continue
+		}
+		if err := s.watcher.Add(dir); err != nil {
+			log.Printf("Warning: Could not watch %s: %v", dir, err)
+		}
+	}
+}
+
+// invalidateRecords makes the next read of the categories and notebooks go to disk again
+func (s *NoteStore) invalidateRecords() {
+	s.invalidateCategories()
+	s.invalidateNotebooks()
+}
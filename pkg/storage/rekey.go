@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+const (
+	rekeyDirName     = ".rekey"
+	rekeyJournalName = "journal.json"
+
+	// RekeyStateStaging means re-encrypted files are still being written to the staging directory
+	RekeyStateStaging = "staging"
+	// RekeyStateCommitted means every file has been staged and the change must be rolled forward
+	RekeyStateCommitted = "committed"
+)
+
+// RekeyJournal records the progress of a vault re-encryption so it can be resumed after a crash
+type RekeyJournal struct {
+	State     string          `json:"state"`
+	StartedAt time.Time       `json:"started_at"`
+	KeyConfig json.RawMessage `json:"key_config"` // Key configuration to install for the new key
+	Files     []string        `json:"files"`      // Staged files, relative to the notes directory
+}
+
+// rekeyDir returns the staging directory used while re-encrypting the vault
+func rekeyDir(dataDir string) string {
+	return filepath.Join(dataDir, rekeyDirName)
+}
+
+// LoadRekeyJournal returns the journal of an interrupted re-encryption, or nil if there is none
+func LoadRekeyJournal(dataDir string) (*RekeyJournal, error) {
+	data, err := os.ReadFile(filepath.Join(rekeyDir(dataDir), rekeyJournalName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var journal RekeyJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("failed to parse rekey journal: %v", err)
+	}
+	return &journal, nil
+}
+
+// saveRekeyJournal writes the journal atomically so it is never left half-written
+func saveRekeyJournal(dataDir string, journal *RekeyJournal) error {
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(filepath.Join(rekeyDir(dataDir), rekeyJournalName), data, 0600)
+}
+
+// StageRekey re-encrypts every note and image with newKey into a staging directory.
+// The live vault is not touched; call FinishRekey once the new key configuration has been installed.
+func StageRekey(notes *NoteStore, images *ImageStore, oldKey, newKey []byte, keyConfig []byte) (*RekeyJournal, error) {
+	dataDir := notes.GetDataDir()
+	stagingDir := rekeyDir(dataDir)
+
+	// Start from a clean staging area
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return nil, fmt.Errorf("failed to clear staging directory: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(stagingDir, "images"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+
+	journal := &RekeyJournal{
+		State:     RekeyStateStaging,
+		StartedAt: time.Now(),
+		KeyConfig: keyConfig,
+	}
+	if err := saveRekeyJournal(dataDir, journal); err != nil {
+		return nil, fmt.Errorf("failed to write rekey journal: %v", err)
+	}
+
+	// Make sure the in-memory notes reflect everything on disk
+	if _, err := notes.RefreshFromDisk(); err != nil {
+		return nil, fmt.Errorf("failed to refresh notes: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dataDir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list note files: %v", err)
+	}
+
+	stagedNotes := &NoteStore{dataDir: stagingDir, encryptMetadata: notes.noteFormat() == models.NoteFormatV3}
+	for _, file := range files {
+		filename := filepath.Base(file)
+		if noteID, ok := utils.ParseSyncConflictFilename(filename); ok {
+			staged, err := stageSyncConflict(dataDir, filename, noteID, stagedNotes, oldKey, newKey)
+			if err != nil {
+				return nil, err
+			}
+			if staged {
+				journal.Files = append(journal.Files, filename)
+			}
+			continue
+		}
+		if !utils.IsValidShortHashFilename(filename) {
+			continue
+		}
+
+		// Every note file must be readable with the old key, otherwise the vault would end up mixed
+		note, err := notes.GetNote(strings.TrimSuffix(filename, ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("note file %s cannot be decrypted with the current key; move it aside before rotating the key", filename)
+		}
+
+		if err := stagedNotes.SaveNoteDirect(note, newKey); err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt note %s: %v", note.ID, err)
+		}
+		journal.Files = append(journal.Files, filename)
+	}
+
+	historyFiles, err := stageHistory(dataDir, stagedNotes, oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+	journal.Files = append(journal.Files, historyFiles...)
+
+	tombstoneFiles, err := stageRecords(tombstoneRecord, tombstoneDirName, utils.IsValidShortHashFilename, dataDir, stagedNotes.dataDir, oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+	journal.Files = append(journal.Files, tombstoneFiles...)
+
+	categoryFiles, err := stageRecords(categoryRecord, categoryDirName, isValidCategoryFilename, dataDir, stagedNotes.dataDir, oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+	journal.Files = append(journal.Files, categoryFiles...)
+
+	notebookFiles, err := stageRecords(notebookRecord, notebookDirName, isValidNotebookFilename, dataDir, stagedNotes.dataDir, oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+	journal.Files = append(journal.Files, notebookFiles...)
+
+	imageFiles, err := os.ReadDir(images.dataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+
+	stagedImages := &ImageStore{dataDir: filepath.Join(stagingDir, "images")}
+	for _, file := range imageFiles {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		encryptedImage, err := images.loadEncryptedImageFromDisk(filepath.Join(images.dataDir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image %s: %v", file.Name(), err)
+		}
+
+		imageData, err := decryptImage(encryptedImage, oldKey)
+		if err != nil {
+			return nil, fmt.Errorf("image %s cannot be decrypted with the current key", file.Name())
+		}
+
+		image := &models.Image{
+			ID:          encryptedImage.ID,
+			Filename:    encryptedImage.Filename,
+			ContentType: encryptedImage.ContentType,
+			Size:        encryptedImage.Size,
+			CreatedAt:   encryptedImage.CreatedAt,
+		}
+		if file.Name() != image.ID+".json" {
+			return nil, fmt.Errorf("image file %s does not match its ID %s", file.Name(), image.ID)
+		}
+		if err := stagedImages.SaveImageDirect(image, imageData, newKey); err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt image %s: %v", image.ID, err)
+		}
+		journal.Files = append(journal.Files, filepath.Join("images", file.Name()))
+	}
+
+	// Everything is staged - from here on the change is rolled forward, never back
+	journal.State = RekeyStateCommitted
+	if err := saveRekeyJournal(dataDir, journal); err != nil {
+		return nil, fmt.Errorf("failed to commit rekey journal: %v", err)
+	}
+
+	return journal, nil
+}
+
+// unreadableFileError is the reason a rotation is aborted: a file that cannot be read with the
+// old key cannot be re-encrypted, and leaving it would mix the two keys in one vault
+func unreadableFileError(rel string, err error) error {
+	return fmt.Errorf("%s cannot be decrypted with the current key (%v); move it aside before rotating the key", rel, err)
+}
+
+// stageSyncConflict re-encrypts a sync-client conflict file into the staging directory under its own name
+func stageSyncConflict(dataDir, filename, noteID string, stagedNotes *NoteStore, oldKey, newKey []byte) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, filename))
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+
+	note, err := decodeNote(data, noteID, oldKey)
+	if err != nil {
+		return false, unreadableFileError(filename, err)
+	}
+
+	encoded, err := encodeNote(note, newKey, stagedNotes.noteFormat())
+	if err != nil {
+		return false, fmt.Errorf("failed to re-encrypt %s: %v", filename, err)
+	}
+	dst := filepath.Join(stagedNotes.dataDir, filename)
+	if err := utils.WriteFileAtomic(dst, encoded, 0644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %v", filename, err)
+	}
+
+	// Keep the time the sync client wrote it, which orders the alternatives shown to the user
+	if info, err := os.Stat(filepath.Join(dataDir, filename)); err == nil {
+		if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// stageHistory re-encrypts the note revisions into the staging directory and returns their relative paths.
+// Revisions keep their modification time, which orders them and drives retention.
+func stageHistory(dataDir string, stagedNotes *NoteStore, oldKey, newKey []byte) ([]string, error) {
+	noteDirs, err := os.ReadDir(filepath.Join(dataDir, historyDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list note history: %v", err)
+	}
+
+	var staged []string
+	for _, noteDir := range noteDirs {
+		noteID := noteDir.Name()
+		if !noteDir.IsDir() || !utils.IsValidShortHashFilename(noteID) {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(dataDir, historyDirName, noteID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list history of note %s: %v", noteID, err)
+		}
+
+		for _, file := range files {
+			if file.IsDir() || !utils.IsValidShortHashFilename(file.Name()) {
+				continue
+			}
+
+			rel := filepath.Join(historyDirName, noteID, file.Name())
+			data, err := os.ReadFile(filepath.Join(dataDir, rel))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read revision %s: %v", rel, err)
+			}
+			info, err := file.Info()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read revision %s: %v", rel, err)
+			}
+
+			revision, err := decodeNote(data, noteID, oldKey)
+			if err != nil {
+				return nil, unreadableFileError(rel, err)
+			}
+
+			encoded, err := encodeNote(revision, newKey, stagedNotes.noteFormat())
+			if err != nil {
+				return nil, fmt.Errorf("failed to re-encrypt revision %s: %v", rel, err)
+			}
+
+			dst := filepath.Join(stagedNotes.dataDir, rel)
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return nil, err
+			}
+			if err := utils.WriteFileAtomic(dst, encoded, 0644); err != nil {
+				return nil, fmt.Errorf("failed to write revision %s: %v", rel, err)
+			}
+			if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+				return nil, err
+			}
+			staged = append(staged, rel)
+		}
+	}
+	return staged, nil
+}
+
+// FinishRekey moves the staged files of a committed journal into place and removes the staging directory.
+// It is idempotent so an interrupted run can simply be repeated.
+func FinishRekey(dataDir string, journal *RekeyJournal) error {
+	if journal.State != RekeyStateCommitted {
+		return fmt.Errorf("rekey journal is not committed")
+	}
+
+	stagingDir := rekeyDir(dataDir)
+	for _, rel := range journal.Files {
+		src := filepath.Join(stagingDir, rel)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue // Already moved by a previous run
+		}
+
+		dst := filepath.Join(dataDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return fmt.Errorf("failed to move %s into place: %v", rel, err)
+		}
+	}
+
+	// Make the renames durable before the staging directory and journal disappear
+	synced := make(map[string]bool)
+	for _, rel := range journal.Files {
+		dir := filepath.Dir(filepath.Join(dataDir, rel))
+		if synced[dir] {
+			continue
+		}
+		synced[dir] = true
+		if err := utils.SyncDir(dir); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to sync %s: %v", dir, err)
+		}
+	}
+
+	return os.RemoveAll(stagingDir)
+}
+
+// AbortRekey discards a re-encryption that never reached the committed state
+func AbortRekey(dataDir string) error {
+	return os.RemoveAll(rekeyDir(dataDir))
+}
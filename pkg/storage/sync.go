@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"sync"
+
+	"gote/pkg/models"
+)
+
+// syncWorkers is the number of note files decrypted at the same time during a sync
+var syncWorkers = runtime.NumCPU()
+
+// SyncStats reports what a sync from disk changed in the store
+type SyncStats struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+	Skipped int `json:"skipped"` // Files not read again because they did not change
+}
+
+// noteFile is a note file found during a sync
+type noteFile struct {
+	path  string
+	id    string
+	stamp fileStamp
+}
+
+// decodeNoteFiles reads and decrypts note files in parallel, starting them in the given order.
+// handle is called for each file as soon as it is decoded, one file at a time; the note is nil
+// if the file could not be read or decrypted.
+func decodeNoteFiles(files []noteFile, key []byte, handle func(file noteFile, note *models.Note)) {
+	type result struct {
+		file noteFile
+		note *models.Note
+	}
+	jobs := make(chan noteFile)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for range min(syncWorkers, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				results <- result{file: file, note: readNoteFile(file, key)}
+			}
+		}()
+	}
+	go func() {
+		for _, file := range files {
+			jobs <- file
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		handle(result.file, result.note)
+	}
+}
+
+// readNoteFile reads and decrypts one note file, or returns nil if that fails
+func readNoteFile(file noteFile, key []byte) *models.Note {
+	data, err := os.ReadFile(file.path)
+	if err != nil {
+		log.Printf("Error reading file %s: %v", file.path, err)
+		return nil
+	}
+	note, err := decodeNote(data, file.id, key)
+	if err != nil {
+		log.Printf("Error decoding note from %s: %v", file.path, err)
+		return nil
+	}
+	return note
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gote/pkg/utils"
+)
+
+// Sync clients that cannot merge two versions of a file keep one of them under a conflict name
+// (see utils.ParseSyncConflictFilename). Such files are offered as alternative revisions of their
+// note and moved into its history once the user has dealt with them.
+
+// syncConflictFiles lists the sync-client conflict files of a note, newest first
+func (s *NoteStore) syncConflictFiles(noteID string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if id, ok := utils.ParseSyncConflictFilename(entry.Name()); !ok || id != noteID {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	return files, nil
+}
+
+// isSyncConflictRevision reports whether a revision ID names a sync-client conflict file of the note
+func isSyncConflictRevision(noteID, revisionID string) bool {
+	id, ok := utils.ParseSyncConflictFilename(revisionID + ".json")
+	return ok && id == noteID
+}
+
+// handleSyncConflictFile deals with a conflict file that appeared in the notes directory.
+// If the note itself is gone the conflict file takes its place, otherwise the user is told
+// about it once per file.
+func (s *NoteStore) handleSyncConflictFile(filePath string) {
+	if s.key == nil {
+		return // Not authenticated yet
+	}
+
+	filename := filepath.Base(filePath)
+	noteID, ok := utils.ParseSyncConflictFilename(filename)
+	if !ok {
+		return
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) { // Already resolved or adopted
+			log.Printf("Error reading sync conflict file %s: %v", filename, err)
+		}
+		return
+	}
+	conflictCopy, err := decodeNote(data, noteID, s.key)
+	if err != nil {
+		log.Printf("Ignoring sync conflict file %s: %v", filename, err)
+		return
+	}
+
+	notePath := filepath.Join(s.dataDir, noteID+".json")
+	if _, err := os.Stat(notePath); os.IsNotExist(err) {
+		if t := s.loadTombstone(noteID); t != nil && t.covers(conflictCopy) {
+			// An old copy of a note that has since been deleted
+			log.Printf("Removing sync conflict file %s of deleted note %s", filename, noteID)
+			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing sync conflict file %s: %v", filename, err)
+			}
+			return
+		}
+
+		// The only remaining copy of the note
+		if err := os.Rename(filePath, notePath); err != nil {
+			log.Printf("Error restoring note %s from sync conflict file: %v", noteID, err)
+			return
+		}
+		log.Printf("Restored note %s from sync conflict file %s", noteID, filename)
+		s.handleFileWrite(notePath)
+		return
+	}
+
+	s.mutex.Lock()
+	notified := s.syncConflictsSeen[filename]
+	s.syncConflictsSeen[filename] = true
+	current := s.notes[noteID]
+	s.mutex.Unlock()
+
+	if notified {
+		return
+	}
+	log.Printf("Found sync conflict file %s for note %s", filename, noteID)
+	s.notifyConflict(Conflict{NoteID: noteID, Kind: ConflictSyncFile, CopyID: strings.TrimSuffix(filename, ".json")}, current)
+}
+
+// archiveSyncConflict moves a sync-client conflict file into the history of its note
+func (s *NoteStore) archiveSyncConflict(noteID, revisionID string) error {
+	dir := s.historyDir(noteID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	filename := revisionID + ".json"
+	if err := os.Rename(filepath.Join(s.dataDir, filename), filepath.Join(dir, utils.GenerateShortUUID()+".json")); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	delete(s.syncConflictsSeen, filename)
+	s.mutex.Unlock()
+
+	return s.pruneHistory(noteID)
+}
+
+// DismissSyncConflicts keeps the current version of a note and moves all of its
+// sync-client conflict files into the note's history
+func (s *NoteStore) DismissSyncConflicts(noteID string) error {
+	if !utils.IsValidShortHashFilename(noteID) {
+		return fmt.Errorf("invalid note ID")
+	}
+
+	files, err := s.syncConflictFiles(noteID)
+	if err != nil {
+		return fmt.Errorf("failed to list sync conflict files: %v", err)
+	}
+
+	for _, file := range files {
+		if err := s.archiveSyncConflict(noteID, strings.TrimSuffix(file.Name(), ".json")); err != nil {
+			return fmt.Errorf("failed to move %s into history: %v", file.Name(), err)
+		}
+	}
+	return nil
+}
+
+// NotesWithSyncConflicts returns the IDs of notes that have sync-client conflict files
+func (s *NoteStore) NotesWithSyncConflicts() []string {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var noteIDs []string
+	for _, entry := range entries {
+		noteID, ok := utils.ParseSyncConflictFilename(entry.Name())
+		if !ok || entry.IsDir() || seen[noteID] {
+			continue
+		}
+		seen[noteID] = true
+		noteIDs = append(noteIDs, noteID)
+	}
+	return noteIDs
+}
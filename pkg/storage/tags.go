@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gote/pkg/models"
+)
+
+// indexTags records the tags of a note in the tag index. Must be called with the mutex held.
+func (s *NoteStore) indexTags(note *models.Note) {
+	s.unindexTags(note.ID)
+
+	tags := note.AllTags()
+	for _, tag := range tags {
+		if s.tagNotes[tag] == nil {
+			s.tagNotes[tag] = make(map[string]bool)
+		}
+		s.tagNotes[tag][note.ID] = true
+	}
+	if len(tags) > 0 {
+		s.noteTags[note.ID] = tags
+	}
+}
+
+// unindexTags removes a note from the tag index. Must be called with the mutex held.
+func (s *NoteStore) unindexTags(id string) {
+	for _, tag := range s.noteTags[id] {
+		delete(s.tagNotes[tag], id)
+		if len(s.tagNotes[tag]) == 0 {
+			delete(s.tagNotes, tag)
+		}
+	}
+	delete(s.noteTags, id)
+}
+
+// ListTags returns all tags of notes outside the trash with the number of notes that have
+// them, most used first
+func (s *NoteStore) ListTags() []models.TagCount {
+	s.mutex.RLock()
+	tags := make([]models.TagCount, 0, len(s.tagNotes))
+	for tag, ids := range s.tagNotes {
+		count := 0
+		for id := range ids {
+			if s.notes[id].Category != models.CategoryTrash {
+				count++
+			}
+		}
+		if count > 0 {
+			tags = append(tags, models.TagCount{Tag: tag, Count: count})
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags
+}
+
+// GetNotesByTags returns the notes outside the trash that have any of the tags, or all of
+// them if matchAll is set, newest first
+func (s *NoteStore) GetNotesByTags(tags []string, matchAll bool) []*models.Note {
+	wanted := models.UniqueTags(tags)
+	if len(wanted) == 0 {
+		return []*models.Note{}
+	}
+
+	s.mutex.RLock()
+	matches := make(map[string]int)
+	for _, tag := range wanted {
+		for id := range s.tagNotes[tag] {
+			matches[id]++
+		}
+	}
+
+	notes := make([]*models.Note, 0, len(matches))
+	for id, count := range matches {
+		note := s.notes[id]
+		if note.Category == models.CategoryTrash || (matchAll && count < len(wanted)) {
+			continue
+		}
+		notes = append(notes, note)
+	}
+	s.mutex.RUnlock()
+
+	sortNotesByUpdate(notes)
+	return notes
+}
+
+// normalizeTags checks and normalizes tags given by the user
+func normalizeTags(tags []string) ([]string, error) {
+	for _, tag := range tags {
+		if _, ok := models.NormalizeTag(tag); !ok {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+	return models.UniqueTags(tags), nil
+}
+
+// SetNoteTags replaces the tags of a note. #tags in its content are kept regardless.
+func (s *NoteStore) SetNoteTags(id string, tags []string, key []byte) (*models.Note, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	note, err := s.editableNote(id)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+
+	note.Tags = tags
+	note.UpdatedAt = time.Now()
+	s.mutex.Unlock()
+
+	if err := s.saveNote(note, key); err != nil {
+		return nil, err
+	}
+
+	s.notifyNote(ChangeUpdated, note, false)
+	return note, nil
+}
+
+// RenameTag renames a tag in every note, both in the note's tags and as #tag in its content.
+// Renaming to an existing tag merges the two. It returns the number of notes changed.
+func (s *NoteStore) RenameTag(from, to string, key []byte) (int, error) {
+	return s.MergeTags([]string{from}, to, key)
+}
+
+// MergeTags replaces several tags by one in every note, both in the note's tags and as #tags
+// in its content. It returns the number of notes changed.
+func (s *NoteStore) MergeTags(from []string, to string, key []byte) (int, error) {
+	target, ok := models.NormalizeTag(to)
+	if !ok {
+		return 0, fmt.Errorf("invalid tag %q", to)
+	}
+	sources, err := normalizeTags(from)
+	if err != nil {
+		return 0, err
+	}
+
+	replaced := make(map[string]bool, len(sources))
+	var ids []string
+	s.mutex.RLock()
+	for _, tag := range sources {
+		if tag == target {
+			continue
+		}
+		replaced[tag] = true
+		for id := range s.tagNotes[tag] {
+			ids = append(ids, id)
+		}
+	}
+	s.mutex.RUnlock()
+
+	changed := 0
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue // Had several of the tags
+		}
+		seen[id] = true
+
+		note, err := s.replaceTags(id, replaced, target, key)
+		if err != nil {
+			return changed, fmt.Errorf("failed to update note %s: %v", id, err)
+		}
+		if note != nil {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// replaceTags replaces tags in one note and saves it. It returns nil if the note did not change.
+func (s *NoteStore) replaceTags(id string, replaced map[string]bool, target string, key []byte) (*models.Note, error) {
+	s.mutex.Lock()
+	note, err := s.editableNote(id)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+
+	content := models.RewriteHashtags(note.Content, func(tag string) (string, bool) {
+		return target, replaced[tag]
+	})
+	tags := make([]string, 0, len(note.Tags))
+	for _, tag := range note.Tags {
+		if replaced[tag] {
+			tag = target
+		}
+		tags = append(tags, tag)
+	}
+	tags = models.UniqueTags(tags)
+
+	if content == note.Content && sameTags(tags, note.Tags) {
+		s.mutex.Unlock()
+		return nil, nil
+	}
+
+	note.Content = content
+	note.Tags = tags
+	note.UpdatedAt = time.Now()
+	s.mutex.Unlock()
+
+	if err := s.saveNote(note, key); err != nil {
+		return nil, err
+	}
+
+	s.notifyNote(ChangeUpdated, note, false)
+	return note, nil
+}
+
+// mergeTags merges the tags of two versions of a note against their common base: tags
+// removed on either side stay removed, tags added on either side are kept
+func mergeTags(base, local, incoming []string) []string {
+	inBase := make(map[string]bool, len(base))
+	for _, tag := range base {
+		inBase[tag] = true
+	}
+	inLocal := make(map[string]bool, len(local))
+	for _, tag := range local {
+		inLocal[tag] = true
+	}
+	inIncoming := make(map[string]bool, len(incoming))
+	for _, tag := range incoming {
+		inIncoming[tag] = true
+	}
+
+	var merged []string
+	for _, tag := range base {
+		if inLocal[tag] && inIncoming[tag] {
+			merged = append(merged, tag)
+		}
+	}
+	for _, tag := range append(append([]string(nil), local...), incoming...) {
+		if !inBase[tag] {
+			merged = append(merged, tag)
+		}
+	}
+	return models.UniqueTags(merged)
+}
+
+// sameTags reports whether two tag lists are equal
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMergeTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     []string
+		local    []string
+		incoming []string
+		want     []string
+	}{
+		{"unchanged", []string{"a", "b"}, []string{"a", "b"}, []string{"a", "b"}, []string{"a", "b"}},
+		{"added on both sides", []string{"a"}, []string{"a", "b"}, []string{"a", "c"}, []string{"a", "b", "c"}},
+		{"removed locally", []string{"a", "b"}, []string{"a"}, []string{"a", "b"}, []string{"a"}},
+		{"removed elsewhere", []string{"a", "b"}, []string{"a", "b"}, []string{"b"}, []string{"b"}},
+		{"removed and added", []string{"a", "b"}, []string{"b", "c"}, []string{"a", "d"}, []string{"c", "d"}},
+		{"added on both sides alike", nil, []string{"a"}, []string{"a"}, []string{"a"}},
+		{"no base", nil, []string{"b"}, []string{"a"}, []string{"a", "b"}},
+		{"all removed", []string{"a"}, nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeTags(tt.base, tt.local, tt.incoming)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("mergeTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// tagCounts formats the tags of a store as "tag:count", most used first
+func tagCounts(store *NoteStore) string {
+	var counts []string
+	for _, tag := range store.ListTags() {
+		counts = append(counts, fmt.Sprintf("%s:%d", tag.Tag, tag.Count))
+	}
+	return strings.Join(counts, " ")
+}
+
+func TestStoreMergeTags(t *testing.T) {
+	store, key := testStore(t)
+
+	inline, err := store.CreateNote("about #todo and #later", key)
+	if err != nil {
+		t.Fatalf("CreateNote() error: %v", err)
+	}
+	tagged, err := store.CreateNote("no inline tags", key)
+	if err != nil {
+		t.Fatalf("CreateNote() error: %v", err)
+	}
+	if _, err := store.SetNoteTags(tagged.ID, []string{"Later", "idea"}, key); err != nil {
+		t.Fatalf("SetNoteTags() error: %v", err)
+	}
+	trashed, err := store.CreateNote("#todo in trash", key)
+	if err != nil {
+		t.Fatalf("CreateNote() error: %v", err)
+	}
+	if _, err := store.MoveToTrash(trashed.ID, key); err != nil {
+		t.Fatalf("MoveToTrash() error: %v", err)
+	}
+
+	if got := tagCounts(store); got != "later:2 idea:1 todo:1" {
+		t.Errorf("ListTags() = %q", got)
+	}
+	if got := len(store.GetNotesByTags([]string{"later", "idea"}, true)); got != 1 {
+		t.Errorf("GetNotesByTags() matching all = %d notes, want 1", got)
+	}
+	if got := len(store.GetNotesByTags([]string{"#TODO", "idea"}, false)); got != 2 {
+		t.Errorf("GetNotesByTags() matching any = %d notes, want 2", got)
+	}
+
+	changed, err := store.MergeTags([]string{"todo", "#later"}, "Next", key)
+	if err != nil {
+		t.Fatalf("MergeTags() error: %v", err)
+	}
+	if changed != 3 {
+		t.Errorf("MergeTags() changed %d notes, want 3", changed)
+	}
+	if got := tagCounts(store); got != "next:2 idea:1" {
+		t.Errorf("ListTags() after merging = %q", got)
+	}
+	note, err := store.GetNote(inline.ID)
+	if err != nil || note.Content != "about #next and #next" {
+		t.Errorf("content after merging = %v, %v", note, err)
+	}
+	note, err = store.GetNote(tagged.ID)
+	if err != nil || strings.Join(note.Tags, " ") != "idea next" {
+		t.Errorf("tags after merging = %v, %v", note, err)
+	}
+
+	if _, err := store.RenameTag("idea", "two words", key); err == nil {
+		t.Errorf("RenameTag() to an invalid tag succeeded")
+	}
+	if changed, err := store.RenameTag("missing", "next", key); err != nil || changed != 0 {
+		t.Errorf("RenameTag() of an unused tag = %d, %v; want no change", changed, err)
+	}
+}
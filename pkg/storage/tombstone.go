@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+const (
+	tombstoneDirName = "tombstones"
+
+	// tombstoneRecord is the record kind of deletion records
+	tombstoneRecord = "tombstone"
+
+	// DefaultTombstoneRetention is how long deletion records are kept unless configured otherwise.
+	// A device that stays offline longer than this can bring deleted notes back.
+	DefaultTombstoneRetention = 90 * 24 * time.Hour
+)
+
+// tombstone records that a note was deleted, so that other devices delete it too
+// instead of writing their copy back
+type tombstone struct {
+	NoteID    string               `json:"-"`
+	DeletedAt time.Time            `json:"deleted_at"`
+	Versions  models.VersionVector `json:"versions,omitempty"` // Version of the note that was deleted, plus the deletion
+}
+
+// covers reports whether the deletion happened after every edit in the note,
+// i.e. whether the note is a stale copy that must not come back
+func (t *tombstone) covers(note *models.Note) bool {
+	if len(note.Versions) == 0 {
+		// Notes saved before versions were tracked can only be compared by time
+		return !note.UpdatedAt.After(t.DeletedAt)
+	}
+	order := note.Versions.Compare(t.Versions)
+	return order == models.VersionEqual || order == models.VersionBefore
+}
+
+// encodeTombstone encrypts a tombstone; the deletion time is part of the ciphertext
+func encodeTombstone(t *tombstone, key []byte) ([]byte, error) {
+	return encodeRecord(tombstoneRecord, t.NoteID, t, key)
+}
+
+// decodeTombstone decrypts a tombstone file whose name gives the expected note ID
+func decodeTombstone(data []byte, expectedID string, key []byte) (*tombstone, error) {
+	var t tombstone
+	if err := decodeRecord(tombstoneRecord, data, expectedID, key, &t); err != nil {
+		return nil, err
+	}
+	t.NoteID = expectedID
+	return &t, nil
+}
+
+// tombstonePath returns the file recording the deletion of a note
+func (s *NoteStore) tombstonePath(noteID string) string {
+	return filepath.Join(s.dataDir, tombstoneDirName, noteID+".json")
+}
+
+// SetTombstoneRetention sets how long deletion records are kept; 0 keeps the default
+func (s *NoteStore) SetTombstoneRetention(retention time.Duration) {
+	if retention <= 0 {
+		retention = DefaultTombstoneRetention
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tombstoneRetention = retention
+}
+
+// writeTombstone records the deletion of a note. The note's version is carried over so
+// copies edited elsewhere after the deletion are recognised and kept.
+func (s *NoteStore) writeTombstone(noteID string, versions models.VersionVector) error {
+	s.mutex.RLock()
+	key := s.key
+	t := &tombstone{
+		NoteID:    noteID,
+		DeletedAt: time.Now(),
+		Versions:  versions.Increment(s.deviceID),
+	}
+	s.mutex.RUnlock()
+
+	if key == nil {
+		return fmt.Errorf("not authenticated")
+	}
+
+	data, err := encodeTombstone(t, key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dataDir, tombstoneDirName), 0755); err != nil {
+		return fmt.Errorf("failed to create tombstone directory: %v", err)
+	}
+	return utils.WriteFileAtomic(s.tombstonePath(noteID), data, 0644)
+}
+
+// loadTombstone returns the deletion record of a note, or nil if it was not deleted
+func (s *NoteStore) loadTombstone(noteID string) *tombstone {
+	data, err := os.ReadFile(s.tombstonePath(noteID))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading tombstone of note %s: %v", noteID, err)
+		}
+		return nil
+	}
+
+	t, err := decodeTombstone(data, noteID, s.key)
+	if err != nil {
+		log.Printf("Ignoring tombstone of note %s: %v", noteID, err)
+		return nil
+	}
+	return t
+}
+
+// loadTombstones reads all deletion records, keyed by note ID
+func (s *NoteStore) loadTombstones() map[string]*tombstone {
+	tombstones := make(map[string]*tombstone)
+
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, tombstoneDirName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading tombstones: %v", err)
+		}
+		return tombstones
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !utils.IsValidShortHashFilename(entry.Name()) {
+			continue
+		}
+		noteID := strings.TrimSuffix(entry.Name(), ".json")
+		if t := s.loadTombstone(noteID); t != nil {
+			tombstones[noteID] = t
+		}
+	}
+	return tombstones
+}
+
+// removeTombstone forgets the deletion of a note, e.g. because it was edited again elsewhere
+func (s *NoteStore) removeTombstone(noteID string) {
+	if err := os.Remove(s.tombstonePath(noteID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to remove tombstone of note %s: %v", noteID, err)
+	}
+}
+
+// collectTombstones removes deletion records older than the retention period
+func (s *NoteStore) collectTombstones(tombstones map[string]*tombstone) {
+	s.mutex.RLock()
+	retention := s.tombstoneRetention
+	s.mutex.RUnlock()
+
+	for noteID, t := range tombstones {
+		if time.Since(t.DeletedAt) > retention {
+			s.removeTombstone(noteID)
+			delete(tombstones, noteID)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gote/pkg/models"
+	"gote/pkg/utils"
+)
+
+func TestTombstoneCovers(t *testing.T) {
+	deletedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	deletion := &tombstone{
+		NoteID:    "1a2b3c4d",
+		DeletedAt: deletedAt,
+		Versions:  models.VersionVector{"laptop": 3, "phone": 1},
+	}
+
+	tests := []struct {
+		name      string
+		versions  models.VersionVector
+		updatedAt time.Time
+		want      bool
+	}{
+		{"deleted version", models.VersionVector{"laptop": 3, "phone": 1}, deletedAt, true},
+		{"older version", models.VersionVector{"laptop": 2}, deletedAt, true},
+		{"edited after the deletion", models.VersionVector{"laptop": 4, "phone": 1}, deletedAt, false},
+		{"edited concurrently", models.VersionVector{"laptop": 2, "phone": 2}, deletedAt, false},
+		{"no versions, older", nil, deletedAt.Add(-time.Hour), true},
+		{"no versions, newer", nil, deletedAt.Add(time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			note := &models.Note{ID: deletion.NoteID, Versions: tt.versions, UpdatedAt: tt.updatedAt}
+			if got := deletion.covers(note); got != tt.want {
+				t.Errorf("covers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTombstone(t *testing.T) {
+	key := testKey(t)
+	deletion := &tombstone{
+		NoteID:    "1a2b3c4d",
+		DeletedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Versions:  models.VersionVector{"laptop": 3},
+	}
+	data, err := encodeTombstone(deletion, key)
+	if err != nil {
+		t.Fatalf("encodeTombstone() error: %v", err)
+	}
+
+	decoded, err := decodeTombstone(data, deletion.NoteID, key)
+	if err != nil {
+		t.Fatalf("decodeTombstone() error: %v", err)
+	}
+	if decoded.NoteID != deletion.NoteID || !decoded.DeletedAt.Equal(deletion.DeletedAt) ||
+		decoded.Versions.Compare(deletion.Versions) != models.VersionEqual {
+		t.Errorf("decodeTombstone() = %+v, want %+v", decoded, deletion)
+	}
+
+	// A category file with the same ID is not a deletion record
+	category, err := encodeRecord(categoryRecord, deletion.NoteID, deletion, key)
+	if err != nil {
+		t.Fatalf("encodeRecord() error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		expectedID string
+		key        []byte
+	}{
+		{"moved to another file", data, "ffffffff", key},
+		{"another key", data, deletion.NoteID, testKey(t)},
+		{"another record kind", category, deletion.NoteID, key},
+		{"not JSON", []byte("not json"), deletion.NoteID, key},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeTombstone(tt.data, tt.expectedID, tt.key); err == nil {
+				t.Errorf("decodeTombstone() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestCollectTombstones(t *testing.T) {
+	store, key := testStore(t)
+	store.SetTombstoneRetention(10 * 24 * time.Hour)
+
+	ages := map[string]time.Duration{
+		"1a2b3c4d": time.Hour,
+		"2b3c4d5e": 9 * 24 * time.Hour,
+		"3c4d5e6f": 11 * 24 * time.Hour,
+		"4d5e6f7a": 100 * 24 * time.Hour,
+	}
+	if err := os.MkdirAll(filepath.Join(store.dataDir, tombstoneDirName), 0755); err != nil {
+		t.Fatalf("MkdirAll() error: %v", err)
+	}
+	for noteID, age := range ages {
+		data, err := encodeTombstone(&tombstone{NoteID: noteID, DeletedAt: time.Now().Add(-age)}, key)
+		if err != nil {
+			t.Fatalf("encodeTombstone() error: %v", err)
+		}
+		if err := utils.WriteFileAtomic(store.tombstonePath(noteID), data, 0644); err != nil {
+			t.Fatalf("WriteFileAtomic() error: %v", err)
+		}
+	}
+
+	tombstones := store.loadTombstones()
+	if len(tombstones) != len(ages) {
+		t.Fatalf("loadTombstones() = %d records, want %d", len(tombstones), len(ages))
+	}
+	store.collectTombstones(tombstones)
+
+	for noteID, age := range ages {
+		_, kept := tombstones[noteID]
+		_, err := os.Stat(store.tombstonePath(noteID))
+		want := age < 10*24*time.Hour
+		if kept != want || (err == nil) != want {
+			t.Errorf("deletion record %d days old: kept %v, file kept %v; want %v",
+				int(age/(24*time.Hour)), kept, err == nil, want)
+		}
+	}
+}
+
+func TestDeletedNoteStaysDeleted(t *testing.T) {
+	store, key := testStore(t)
+	store.SetDeviceID("laptop")
+	note, err := store.CreateNote("content", key)
+	if err != nil {
+		t.Fatalf("CreateNote() error: %v", err)
+	}
+	path := filepath.Join(store.dataDir, note.ID+".json")
+	staleCopy, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+
+	if err := store.DeleteNote(note.ID); err != nil {
+		t.Fatalf("DeleteNote() error: %v", err)
+	}
+
+	// Another device that missed the deletion writes its copy back
+	if err := utils.WriteFileAtomic(path, staleCopy, 0644); err != nil {
+		t.Fatalf("WriteFileAtomic() error: %v", err)
+	}
+	if _, err := store.RefreshFromDisk(); err != nil {
+		t.Fatalf("RefreshFromDisk() error: %v", err)
+	}
+	if _, err := store.GetNote(note.ID); err == nil {
+		t.Errorf("a stale copy of a deleted note came back")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the stale copy was not removed: %v", err)
+	}
+
+	// A copy edited on another device after the deletion is kept
+	edited := testNote()
+	edited.ID = note.ID
+	edited.Content = "edited on the phone"
+	edited.Versions = note.Versions.Increment("phone")
+	data, err := encodeNote(edited, key, models.NoteFormatV3)
+	if err != nil {
+		t.Fatalf("encodeNote() error: %v", err)
+	}
+	if err := utils.WriteFileAtomic(path, data, 0644); err != nil {
+		t.Fatalf("WriteFileAtomic() error: %v", err)
+	}
+	if _, err := store.RefreshFromDisk(); err != nil {
+		t.Fatalf("RefreshFromDisk() error: %v", err)
+	}
+	got, err := store.GetNote(note.ID)
+	if err != nil || got.Content != edited.Content {
+		t.Errorf("GetNote() = %v, %v; want the edited copy", got, err)
+	}
+	if _, err := os.Stat(store.tombstonePath(note.ID)); !os.IsNotExist(err) {
+		t.Errorf("the deletion record of the edited note was kept: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"gote/pkg/utils"
+)
+
+const (
+	// A path is processed once it has been quiet for this long, so a file written in
+	// several chunks or replaced through a temporary file is read only once, complete
+	watchDebounce = 250 * time.Millisecond
+
+	// While events keep arriving a path is held back at most this long after its first event
+	watchMaxDelay = 2 * time.Second
+
+	// Batches with more changed paths than this (e.g. a sync client catching up) are
+	// handled with one full sync instead of file by file
+	watchFullSyncThreshold = 50
+)
+
+// watchLoop processes events of the notes directory, see debounceEvents. The watcher is
+// passed in since switching to polling replaces s.watcher while the loop is running.
+func (s *NoteStore) watchLoop(watcher *fsnotify.Watcher) {
+	debounceEvents(watcher, func(path string) bool {
+		return s.isRecordPath(path) || isWatchedFile(path)
+	}, s.processWatchBatch)
+}
+
+// debounceEvents collects file system events per path and processes each path after it settled.
+// Events only mark a path as changed; what happens is decided by the state of the file on
+// disk when it is processed, so a rename into place counts as an update, not a removal.
+// Paths that change together are processed together once the directory has been quiet.
+// It returns when the watcher is closed.
+func debounceEvents(watcher *fsnotify.Watcher, accept func(path string) bool, process func(paths []string)) {
+	type pendingPath struct {
+		first time.Time // First event not processed yet
+		last  time.Time // Latest event
+	}
+	pending := make(map[string]pendingPath)
+	var firstPending time.Time // Oldest event not processed yet
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			if !accept(event.Name) {
+				continue
+			}
+
+			now := time.Now()
+			if len(pending) == 0 {
+				firstPending = now
+			}
+			path, known := pending[event.Name]
+			if !known {
+				path.first = now
+			}
+			path.last = now
+			pending[event.Name] = path
+
+			wait := watchDebounce
+			if remaining := watchMaxDelay - now.Sub(firstPending); remaining < wait {
+				wait = max(remaining, 0)
+			}
+			timer.Reset(wait)
+
+		case <-timer.C:
+			// Once the oldest event is overdue everything is processed, settled or not,
+			// so files that are written continuously are still picked up
+			now := time.Now()
+			overdue := now.Sub(firstPending) >= watchMaxDelay
+
+			var batch []string
+			next := time.Duration(0)
+			firstPending = time.Time{}
+			for name, path := range pending {
+				if wait := watchDebounce - now.Sub(path.last); wait > 0 && !overdue {
+					if next == 0 || wait < next {
+						next = wait
+					}
+					if firstPending.IsZero() || path.first.Before(firstPending) {
+						firstPending = path.first
+					}
+					continue
+				}
+				batch = append(batch, name)
+				delete(pending, name)
+			}
+			if len(pending) > 0 {
+				if remaining := watchMaxDelay - now.Sub(firstPending); remaining < next {
+					next = max(remaining, 0)
+				}
+				timer.Reset(next)
+			}
+			process(batch)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+		}
+	}
+}
+
+// isWatchedFile reports whether a path is a note file or a sync conflict copy of one
+func isWatchedFile(path string) bool {
+	filename := filepath.Base(path)
+	if !strings.HasSuffix(filename, ".json") {
+		return false
+	}
+	if _, ok := utils.ParseSyncConflictFilename(filename); ok {
+		return true
+	}
+	if !utils.IsValidShortHashFilename(filename) {
+		log.Printf("Ignoring file with invalid name pattern: %s", filename)
+		return false
+	}
+	return true
+}
+
+// processWatchBatch brings the store up to date with a set of settled paths
+func (s *NoteStore) processWatchBatch(paths []string) {
+	if len(paths) == 0 || s.syncSuspended() {
+		return
+	}
+
+	notePaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !s.isRecordPath(path) {
+			notePaths = append(notePaths, path)
+		}
+	}
+	if len(notePaths) < len(paths) {
+		s.watchRecords()
+		s.invalidateRecords()
+		paths = notePaths
+	}
+
+	if len(paths) > watchFullSyncThreshold {
+		log.Printf("Processing %d changed files with a full sync", len(paths))
+		if _, err := s.syncFromDisk(nil); err != nil {
+			log.Printf("Error syncing changed files: %v", err)
+		}
+		return
+	}
+
+	for _, path := range paths {
+		_, err := os.Stat(path)
+		exists := err == nil
+
+		filename := filepath.Base(path)
+		if _, ok := utils.ParseSyncConflictFilename(filename); ok {
+			if exists {
+				s.handleSyncConflictFile(path)
+			} else {
+				s.mutex.Lock()
+				delete(s.syncConflictsSeen, filename)
+				s.mutex.Unlock()
+			}
+			continue
+		}
+
+		if exists {
+			s.handleFileWrite(path)
+		} else {
+			s.handleFileRemove(path)
+		}
+	}
+}
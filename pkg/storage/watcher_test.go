@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// startDebounce runs debounceEvents on a fake watcher and returns its event channel and
+// the batches it processes; closing the channel stops it
+func startDebounce(t *testing.T, accept func(path string) bool) (chan fsnotify.Event, chan []string) {
+	t.Helper()
+	watcher := &fsnotify.Watcher{
+		Events: make(chan fsnotify.Event),
+		Errors: make(chan error),
+	}
+	batches := make(chan []string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		debounceEvents(watcher, accept, func(paths []string) {
+			sort.Strings(paths)
+			batches <- paths
+		})
+	}()
+	t.Cleanup(func() {
+		close(watcher.Events)
+		<-done
+	})
+	return watcher.Events, batches
+}
+
+// nextBatch waits for the next processed batch, or fails after timeout
+func nextBatch(t *testing.T, batches chan []string, timeout time.Duration) []string {
+	t.Helper()
+	select {
+	case batch := <-batches:
+		return batch
+	case <-time.After(timeout):
+		t.Fatalf("no batch processed within %v", timeout)
+		return nil
+	}
+}
+
+func TestDebounceEvents(t *testing.T) {
+	accept := func(path string) bool { return strings.HasSuffix(path, ".json") }
+
+	tests := []struct {
+		name   string
+		events []string // Sent in quick succession
+		want   []string
+	}{
+		{"single event", []string{"a.json"}, []string{"a.json"}},
+		{"repeated events of one path", []string{"a.json", "a.json", "a.json"}, []string{"a.json"}},
+		{"paths changing together", []string{"a.json", "b.json", "a.json", "c.json"}, []string{"a.json", "b.json", "c.json"}},
+		{"ignored paths", []string{"a.json", "a.json.tmp", "notes.txt"}, []string{"a.json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, batches := startDebounce(t, accept)
+			for _, name := range tt.events {
+				events <- fsnotify.Event{Name: name, Op: fsnotify.Write}
+			}
+
+			got := nextBatch(t, batches, watchDebounce+time.Second)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("batch = %v, want %v", got, tt.want)
+			}
+			select {
+			case batch := <-batches:
+				t.Errorf("unexpected second batch %v", batch)
+			case <-time.After(2 * watchDebounce):
+			}
+		})
+	}
+}
+
+func TestDebounceEventsOnlyIgnored(t *testing.T) {
+	events, batches := startDebounce(t, func(path string) bool { return false })
+	events <- fsnotify.Event{Name: "a.json", Op: fsnotify.Create}
+
+	select {
+	case batch := <-batches:
+		t.Errorf("ignored path processed in batch %v", batch)
+	case <-time.After(2 * watchDebounce):
+	}
+}
+
+func TestDebounceEventsMaxDelay(t *testing.T) {
+	events, batches := startDebounce(t, func(path string) bool { return true })
+
+	// A file written more often than the debounce interval never settles
+	start := time.Now()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(watchDebounce / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				select {
+				case events <- fsnotify.Event{Name: "busy.json", Op: fsnotify.Write}:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	events <- fsnotify.Event{Name: "busy.json", Op: fsnotify.Write}
+
+	got := nextBatch(t, batches, watchMaxDelay+time.Second)
+	elapsed := time.Since(start)
+	if len(got) != 1 || got[0] != "busy.json" {
+		t.Errorf("batch = %v, want [busy.json]", got)
+	}
+	if elapsed < watchMaxDelay-watchDebounce {
+		t.Errorf("busy path processed after %v, before it was overdue", elapsed)
+	}
+}
@@ -7,12 +7,19 @@ import (
 
 // WailsNote represents a note structure optimized for Wails bindings
 type WailsNote struct {
-	ID               string `json:"id"`
-	Content          string `json:"content"`
-	Category         string `json:"category"`
-	OriginalCategory string `json:"original_category,omitempty"`
-	CreatedAt        string `json:"created_at"` // Use string representation for better Wails compatibility
-	UpdatedAt        string `json:"updated_at"` // Use string representation for better Wails compatibility
+	ID               string   `json:"id"`
+	Content          string   `json:"content"`
+	Category         string   `json:"category"`
+	OriginalCategory string   `json:"original_category,omitempty"`
+	ConflictOf       string   `json:"conflict_of,omitempty"`  // Set on conflict copies created during sync
+	NeedsReview      bool     `json:"needs_review,omitempty"` // A merge left conflict markers in the content
+	Partial          bool     `json:"partial,omitempty"`      // Content is only the title; the note has to be fetched to edit it
+	Tags             []string `json:"tags,omitempty"`         // Tags given to the note
+	AllTags          []string `json:"all_tags,omitempty"`     // Its tags together with the #tags in its content
+	Notebook         string   `json:"notebook,omitempty"`     // ID of the notebook the note is in
+	Title            string   `json:"title"`                  // First line of the content, which [[links]] can refer to
+	CreatedAt        string   `json:"created_at"`             // Use string representation for better Wails compatibility
+	UpdatedAt        string   `json:"updated_at"`             // Use string representation for better Wails compatibility
 }
 
 // ConvertToWailsNote converts a models.Note to WailsNote with proper time formatting
@@ -21,16 +28,203 @@ func ConvertToWailsNote(note *models.Note) WailsNote {
 		return WailsNote{}
 	}
 
+	content := note.Content
+	if note.Partial {
+		content = note.Title
+	}
+
 	return WailsNote{
 		ID:               note.ID,
-		Content:          note.Content,
+		Content:          content,
 		Category:         string(note.Category),
 		OriginalCategory: string(note.OriginalCategory),
+		ConflictOf:       note.ConflictOf,
+		NeedsReview:      note.NeedsReview,
+		Partial:          note.Partial,
+		Tags:             note.Tags,
+		AllTags:          note.AllTags(),
+		Notebook:         note.Notebook,
+		Title:            note.DisplayTitle(),
 		CreatedAt:        note.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:        note.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
+// WailsNoteConflict is sent to the frontend when edits of a note on two devices need the user's attention
+type WailsNoteConflict struct {
+	Note   WailsNote `json:"note"`
+	Kind   string    `json:"kind"`              // "copy", "merged" or "sync_file"
+	CopyID string    `json:"copy_id,omitempty"` // Conflict copy note or sync conflict revision
+}
+
+// WailsImageChange is sent to the frontend when an image appears on or disappears from disk
+type WailsImageChange struct {
+	ImageID string   `json:"image_id"`
+	NoteIDs []string `json:"note_ids"` // Notes that reference the image and need to be rendered again
+}
+
+// WailsLoadProgress is sent to the frontend while the notes of an unlocked vault are decrypted
+type WailsLoadProgress struct {
+	Loaded int `json:"loaded"`
+	Total  int `json:"total"`
+}
+
+// WailsCategory represents a user-defined category for Wails bindings
+type WailsCategory struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Color     string `json:"color,omitempty"`
+	SortOrder int    `json:"sort_order"`
+}
+
+// ConvertToWailsCategory converts a models.Category to WailsCategory
+func ConvertToWailsCategory(category *models.Category) WailsCategory {
+	if category == nil {
+		return WailsCategory{}
+	}
+
+	return WailsCategory{
+		ID:        string(category.ID),
+		Name:      category.Name,
+		Color:     category.Color,
+		SortOrder: category.SortOrder,
+	}
+}
+
+// ConvertToWailsCategories converts a slice of categories to WailsCategory
+func ConvertToWailsCategories(categories []*models.Category) []WailsCategory {
+	wailsCategories := make([]WailsCategory, len(categories))
+	for i, category := range categories {
+		wailsCategories[i] = ConvertToWailsCategory(category)
+	}
+	return wailsCategories
+}
+
+// WailsNotebook represents a notebook for Wails bindings
+type WailsNotebook struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	ParentID  string `json:"parent_id,omitempty"`
+	SortOrder int    `json:"sort_order"`
+	Trashed   bool   `json:"trashed,omitempty"`
+}
+
+// ConvertToWailsNotebook converts a models.Notebook to WailsNotebook
+func ConvertToWailsNotebook(notebook *models.Notebook) WailsNotebook {
+	if notebook == nil {
+		return WailsNotebook{}
+	}
+
+	return WailsNotebook{
+		ID:        notebook.ID,
+		Name:      notebook.Name,
+		ParentID:  notebook.ParentID,
+		SortOrder: notebook.SortOrder,
+		Trashed:   notebook.Trashed,
+	}
+}
+
+// ConvertToWailsNotebooks converts a slice of notebooks to WailsNotebook
+func ConvertToWailsNotebooks(notebooks []*models.Notebook) []WailsNotebook {
+	wailsNotebooks := make([]WailsNotebook, len(notebooks))
+	for i, notebook := range notebooks {
+		wailsNotebooks[i] = ConvertToWailsNotebook(notebook)
+	}
+	return wailsNotebooks
+}
+
+// WailsUnresolvedLink is a link target that matches no note, with the notes linking to it
+type WailsUnresolvedLink struct {
+	Target  string   `json:"target"`
+	NoteIDs []string `json:"note_ids"`
+}
+
+// ConvertToWailsUnresolvedLinks converts unresolved links for Wails bindings
+func ConvertToWailsUnresolvedLinks(links []models.UnresolvedLink) []WailsUnresolvedLink {
+	wailsLinks := make([]WailsUnresolvedLink, len(links))
+	for i, link := range links {
+		wailsLinks[i] = WailsUnresolvedLink{Target: link.Target, NoteIDs: link.NoteIDs}
+	}
+	return wailsLinks
+}
+
+// WailsGraphNode is a note in the note graph
+type WailsGraphNode struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Category string `json:"category"`
+}
+
+// WailsGraphEdge is a link from the source note to the target note
+type WailsGraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// WailsNoteGraph represents the notes and the links between them for Wails bindings
+type WailsNoteGraph struct {
+	Nodes []WailsGraphNode `json:"nodes"`
+	Edges []WailsGraphEdge `json:"edges"`
+}
+
+// ConvertToWailsNoteGraph converts a models.NoteGraph to WailsNoteGraph
+func ConvertToWailsNoteGraph(graph models.NoteGraph) WailsNoteGraph {
+	wailsGraph := WailsNoteGraph{
+		Nodes: make([]WailsGraphNode, len(graph.Nodes)),
+		Edges: make([]WailsGraphEdge, len(graph.Edges)),
+	}
+	for i, node := range graph.Nodes {
+		wailsGraph.Nodes[i] = WailsGraphNode{ID: node.ID, Title: node.Title, Category: string(node.Category)}
+	}
+	for i, edge := range graph.Edges {
+		wailsGraph.Edges[i] = WailsGraphEdge{Source: edge.Source, Target: edge.Target}
+	}
+	return wailsGraph
+}
+
+// WailsTagCount is a tag with the number of notes that have it
+type WailsTagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ConvertToWailsTagCounts converts tag counts for Wails bindings
+func ConvertToWailsTagCounts(tags []models.TagCount) []WailsTagCount {
+	wailsTags := make([]WailsTagCount, len(tags))
+	for i, tag := range tags {
+		wailsTags[i] = WailsTagCount{Tag: tag.Tag, Count: tag.Count}
+	}
+	return wailsTags
+}
+
+// WailsRevision represents a stored note revision for Wails bindings
+type WailsRevision struct {
+	ID        string `json:"id"`
+	NoteID    string `json:"note_id"`
+	SavedAt   string `json:"saved_at"`
+	UpdatedAt string `json:"updated_at"`
+	Size      int    `json:"size"`
+
+	SyncConflict bool `json:"sync_conflict,omitempty"`
+}
+
+// ConvertToWailsRevisions converts revisions to WailsRevision with proper time formatting
+func ConvertToWailsRevisions(revisions []*models.Revision) []WailsRevision {
+	wailsRevisions := make([]WailsRevision, len(revisions))
+	for i, revision := range revisions {
+		wailsRevisions[i] = WailsRevision{
+			ID:        revision.ID,
+			NoteID:    revision.NoteID,
+			SavedAt:   revision.SavedAt.Format(time.RFC3339),
+			UpdatedAt: revision.UpdatedAt.Format(time.RFC3339),
+			Size:      revision.Size,
+
+			SyncConflict: revision.SyncConflict,
+		}
+	}
+	return wailsRevisions
+}
+
 // ConvertToWailsNotes converts a slice of models.Note to slice of WailsNote
 func ConvertToWailsNotes(notes []*models.Note) []WailsNote {
 	if notes == nil {
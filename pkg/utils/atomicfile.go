@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// WriteFileAtomic writes data to a temporary file in the same directory, fsyncs it, renames it
+// over path and fsyncs the directory, so a crash leaves either the old or the new content at path
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return WriteFileAtomicFunc(path, data, perm, nil)
+}
+
+// WriteFileAtomicFunc works like WriteFileAtomic and calls beforeRename with the info of the
+// complete temporary file just before it replaces path. The rename keeps the modification time,
+// so file watchers can use it to recognise the write.
+func WriteFileAtomicFunc(path string, data []byte, perm os.FileMode, beforeRename func(os.FileInfo)) error {
+	dir := filepath.Dir(path)
+
+	// The temporary name does not end in the target extension, so globs and watchers skip it
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// Remove the temporary file unless it was renamed into place
+	renamed := false
+	defer func() {
+		if !renamed {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil && runtime.GOOS != "windows" {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if beforeRename != nil {
+		info, err := os.Stat(tmpPath)
+		if err != nil {
+			return err
+		}
+		beforeRename(info)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	renamed = true
+
+	return SyncDir(dir)
+}
+
+// SyncDir fsyncs a directory so that renames and new entries in it survive a crash.
+// Windows does not support syncing directories, so it is a no-op there.
+func SyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,109 @@
+package utils
+
+import "strings"
+
+// Diff operations
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// Above this many line pairs the diff falls back to replacing the changed block as a whole
+const maxDiffCells = 4 * 1024 * 1024
+
+// DiffLine is one line of a line-based diff
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// SplitLines splits text into lines without their line endings
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// DiffLines returns the line-based differences that turn oldText into newText
+func DiffLines(oldText, newText string) []DiffLine {
+	oldLines, newLines := SplitLines(oldText), SplitLines(newText)
+
+	var diff []DiffLine
+	oldPos, newPos := 0, 0
+	// A final sentinel pair flushes the changes after the last common line
+	for _, pair := range append(MatchLines(oldLines, newLines), [2]int{len(oldLines), len(newLines)}) {
+		for ; oldPos < pair[0]; oldPos++ {
+			diff = append(diff, DiffLine{Op: DiffDelete, Text: oldLines[oldPos]})
+		}
+		for ; newPos < pair[1]; newPos++ {
+			diff = append(diff, DiffLine{Op: DiffInsert, Text: newLines[newPos]})
+		}
+		if oldPos < len(oldLines) {
+			diff = append(diff, DiffLine{Op: DiffEqual, Text: oldLines[oldPos]})
+			oldPos++
+			newPos++
+		}
+	}
+	return diff
+}
+
+// MatchLines aligns two line slices by their longest common subsequence.
+// It returns the index pairs (old, new) of the common lines in ascending order.
+func MatchLines(oldLines, newLines []string) [][2]int {
+	var pairs [][2]int
+
+	// Common prefix and suffix need no table
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		pairs = append(pairs, [2]int{prefix, prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	a := oldLines[prefix : len(oldLines)-suffix]
+	b := newLines[prefix : len(newLines)-suffix]
+
+	// Very large changed blocks are treated as replaced entirely
+	if len(a) > 0 && len(b) > 0 && len(a)*len(b) <= maxDiffCells {
+		// lcs[i][j] is the LCS length of a[i:] and b[j:]
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				switch {
+				case a[i] == b[j]:
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				case lcs[i+1][j] >= lcs[i][j+1]:
+					lcs[i][j] = lcs[i+1][j]
+				default:
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		for i, j := 0, 0; i < len(a) && j < len(b); {
+			switch {
+			case a[i] == b[j]:
+				pairs = append(pairs, [2]int{prefix + i, prefix + j})
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				i++
+			default:
+				j++
+			}
+		}
+	}
+
+	for k := suffix; k > 0; k-- {
+		pairs = append(pairs, [2]int{len(oldLines) - k, len(newLines) - k})
+	}
+	return pairs
+}
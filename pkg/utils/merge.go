@@ -0,0 +1,84 @@
+package utils
+
+import "strings"
+
+// Markers written around the two sides of a merge conflict
+const (
+	MergeMarkerOurs   = "<<<<<<< this device"
+	MergeMarkerSep    = "======="
+	MergeMarkerTheirs = ">>>>>>> other device"
+)
+
+// Merge3 merges the line-based changes that ours and theirs each made to base.
+// Changes to different lines are combined; where both sides changed the same lines
+// differently, both versions are kept between conflict markers and clean is false.
+func Merge3(base, ours, theirs string) (merged string, clean bool) {
+	baseLines, ourLines, theirLines := SplitLines(base), SplitLines(ours), SplitLines(theirs)
+
+	// Position in ours and theirs of every base line both sides kept
+	ourMatch := make(map[int]int)
+	for _, pair := range MatchLines(baseLines, ourLines) {
+		ourMatch[pair[0]] = pair[1]
+	}
+	theirMatch := make(map[int]int)
+	for _, pair := range MatchLines(baseLines, theirLines) {
+		theirMatch[pair[0]] = pair[1]
+	}
+
+	var out []string
+	clean = true
+	b, o, t := 0, 0, 0
+	for b <= len(baseLines) {
+		// Find the next base line that is unchanged on both sides; the end is the final anchor
+		next := b
+		for next < len(baseLines) {
+			_, inOurs := ourMatch[next]
+			_, inTheirs := theirMatch[next]
+			if inOurs && inTheirs {
+				break
+			}
+			next++
+		}
+		nextOurs, nextTheirs := len(ourLines), len(theirLines)
+		if next < len(baseLines) {
+			nextOurs, nextTheirs = ourMatch[next], theirMatch[next]
+		}
+
+		baseChunk := baseLines[b:next]
+		ourChunk := ourLines[o:nextOurs]
+		theirChunk := theirLines[t:nextTheirs]
+		switch {
+		case equalLines(ourChunk, baseChunk):
+			out = append(out, theirChunk...)
+		case equalLines(theirChunk, baseChunk), equalLines(ourChunk, theirChunk):
+			out = append(out, ourChunk...)
+		default:
+			clean = false
+			out = append(out, MergeMarkerOurs)
+			out = append(out, ourChunk...)
+			out = append(out, MergeMarkerSep)
+			out = append(out, theirChunk...)
+			out = append(out, MergeMarkerTheirs)
+		}
+
+		if next < len(baseLines) {
+			out = append(out, baseLines[next])
+		}
+		b, o, t = next+1, nextOurs+1, nextTheirs+1
+	}
+
+	return strings.Join(out, "\n"), clean
+}
+
+// equalLines reports whether two line slices are identical
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package utils
+
+import "testing"
+
+func TestMerge3(t *testing.T) {
+	conflict := func(ours, theirs string) string {
+		return MergeMarkerOurs + "\n" + ours + "\n" + MergeMarkerSep + "\n" + theirs + "\n" + MergeMarkerTheirs
+	}
+
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		wantClean bool
+	}{
+		{
+			name:      "no changes",
+			base:      "one\ntwo\nthree",
+			ours:      "one\ntwo\nthree",
+			theirs:    "one\ntwo\nthree",
+			want:      "one\ntwo\nthree",
+			wantClean: true,
+		},
+		{
+			name:      "only ours changed",
+			base:      "one\ntwo\nthree",
+			ours:      "one\nTWO\nthree",
+			theirs:    "one\ntwo\nthree",
+			want:      "one\nTWO\nthree",
+			wantClean: true,
+		},
+		{
+			name:      "only theirs changed",
+			base:      "one\ntwo\nthree",
+			ours:      "one\ntwo\nthree",
+			theirs:    "one\ntwo\nTHREE",
+			want:      "one\ntwo\nTHREE",
+			wantClean: true,
+		},
+		{
+			name:      "disjoint edits",
+			base:      "one\ntwo\nthree\nfour",
+			ours:      "ONE\ntwo\nthree\nfour",
+			theirs:    "one\ntwo\nthree\nFOUR",
+			want:      "ONE\ntwo\nthree\nFOUR",
+			wantClean: true,
+		},
+		{
+			name:      "overlapping edits",
+			base:      "one\ntwo\nthree",
+			ours:      "one\ntwo a\nthree",
+			theirs:    "one\ntwo b\nthree",
+			want:      "one\n" + conflict("two a", "two b") + "\nthree",
+			wantClean: false,
+		},
+		{
+			name:      "identical edits on both sides",
+			base:      "one\ntwo\nthree",
+			ours:      "one\nTWO\nthree",
+			theirs:    "one\nTWO\nthree",
+			want:      "one\nTWO\nthree",
+			wantClean: true,
+		},
+		{
+			name:      "insert at start and at end",
+			base:      "one\ntwo",
+			ours:      "zero\none\ntwo",
+			theirs:    "one\ntwo\nthree",
+			want:      "zero\none\ntwo\nthree",
+			wantClean: true,
+		},
+		{
+			name:      "both insert at end",
+			base:      "one",
+			ours:      "one\nours",
+			theirs:    "one\ntheirs",
+			want:      "one\n" + conflict("ours", "theirs"),
+			wantClean: false,
+		},
+		{
+			name:      "one side deletes everything",
+			base:      "one\ntwo\nthree",
+			ours:      "",
+			theirs:    "one\ntwo\nthree",
+			want:      "",
+			wantClean: true,
+		},
+		{
+			name:      "deleting everything against an edit",
+			base:      "one\ntwo",
+			ours:      "one\nTWO",
+			theirs:    "",
+			want:      MergeMarkerOurs + "\none\nTWO\n" + MergeMarkerSep + "\n" + MergeMarkerTheirs,
+			wantClean: false,
+		},
+		{
+			name:      "trailing newline kept",
+			base:      "one\ntwo\n",
+			ours:      "ONE\ntwo\n",
+			theirs:    "one\ntwo\nthree\n",
+			want:      "ONE\ntwo\nthree\n",
+			wantClean: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clean := Merge3(tt.base, tt.ours, tt.theirs)
+			if got != tt.want {
+				t.Errorf("Merge3() = %q, want %q", got, tt.want)
+			}
+			if clean != tt.wantClean {
+				t.Errorf("Merge3() clean = %v, want %v", clean, tt.wantClean)
+			}
+		})
+	}
+}
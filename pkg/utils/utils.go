@@ -28,6 +28,28 @@ func IsValidShortHashFilename(filename string) bool {
 	return matched
 }
 
+// Conflict copies created by sync clients next to a note file:
+//
+//	a1b2c3d4 (conflicted copy).json, a1b2c3d4 (Laptop's conflicted copy 2026-01-01).json  (Dropbox, Nextcloud)
+//	a1b2c3d4.sync-conflict-20260101-120000-ABCDEFG.json                                    (Syncthing)
+//	a1b2c3d4_conflict-20260101-120000.json                                                 (ownCloud)
+var syncConflictPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^([0-9a-fA-F]{8}) \([^()/\\]*conflicted copy[^()/\\]*\)\.json$`),
+	regexp.MustCompile(`^([0-9a-fA-F]{8})\.sync-conflict-[0-9A-Za-z-]+\.json$`),
+	regexp.MustCompile(`^([0-9a-fA-F]{8})_conflict-[0-9-]+\.json$`),
+}
+
+// ParseSyncConflictFilename checks whether the filename is a conflict copy of a note made by a
+// sync client and returns the ID of the note it belongs to
+func ParseSyncConflictFilename(filename string) (noteID string, ok bool) {
+	for _, pattern := range syncConflictPatterns {
+		if match := pattern.FindStringSubmatch(filename); match != nil {
+			return match[1], true
+		}
+	}
+	return "", false
+}
+
 // GenerateSessionID generates a secure random session ID
 func GenerateSessionID() string {
 	bytes := make([]byte, 32)